@@ -0,0 +1,345 @@
+package goshorewall
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+const actionFilePrefix = "action."
+
+var (
+	ErrActionAlreadyExists   = errors.New("action already exists")
+	ErrActionNotFound        = errors.New("action not found")
+	ErrActionInvalidName     = errors.New("invalid action name")
+	ErrActionInvalidOption   = errors.New("invalid action option")
+	ErrActionBodyNotFound    = errors.New("action body not found")
+	ErrActionBodyNotOwned    = errors.New("action body is not managed by this application")
+	ErrActionNotDeclared     = errors.New("action not declared")
+	ErrActionOptionsConflict = errors.New("conflicting action options")
+)
+
+// Options accepted in the OPTIONS column of the actions file.
+var actionOptions = []string{
+	"audit",
+	"builtin",
+	"inline",
+	"logjump",
+	"mangle",
+	"nat",
+	"noinline",
+	"nolog",
+	"section",
+	"terminating",
+}
+
+// Options that take a value, as in "proto=tcp".
+var actionValueOptions = []string{
+	"proto",
+	"state",
+}
+
+// builtinActions are the actions known to the Shorewall compiler that don't
+// need to be declared in the actions file.
+var builtinActions = []string{
+	"ACCEPT", "A_ACCEPT", "ADD", "AUDIT", "BLACKLIST", "CONMARK", "CONTINUE",
+	"COUNT", "DEL", "DIVERT", "DNAT", "DROP", "A_DROP", "DSCP", "HELPER",
+	"INLINE", "IPTABLES", "IP6TABLES", "LOG", "MARK", "NFLOG", "NFQUEUE",
+	"NONAT", "QUEUE", "REDIRECT", "REJECT", "A_REJECT", "SAME", "TARPIT",
+	"ULOG",
+}
+
+// Action represents a user-defined action declared in the actions file.
+// The body of the action lives in the action.NAME file.
+type Action struct {
+	Name    string
+	Options []string
+}
+
+func (a Action) Compare(other Action) int {
+	return strings.Compare(a.Name, other.Name)
+}
+
+func (a Action) Equals(other Action) bool {
+	return a.Name == other.Name
+}
+
+func (a Action) Format() string {
+	if len(a.Options) == 0 {
+		return a.Name
+	}
+	return formatColumns(actionColumns, a.Name, strings.Join(a.Options, ","))
+}
+
+// FormatNamed formats the action declaration in the column=value form.
+func (a Action) FormatNamed() string {
+	return formatNamedColumns(actionColumns, a.Name, strings.Join(a.Options, ","))
+}
+
+// HasOption reports whether the action declares the given option.
+func (a Action) HasOption(option string) bool {
+	return slices.ContainsFunc(a.Options, func(o string) bool {
+		name, _, _ := strings.Cut(o, "=")
+		return name == option
+	})
+}
+
+// Validate checks the action name and options.
+func (a Action) Validate() error {
+	if err := validateActionName(a.Name); err != nil {
+		return err
+	}
+	for _, o := range a.Options {
+		name, _, hasValue := strings.Cut(o, "=")
+		if hasValue && slices.Contains(actionValueOptions, name) {
+			continue
+		}
+		if hasValue || !slices.Contains(actionOptions, name) {
+			return fmt.Errorf("%w: %q", ErrActionInvalidOption, o)
+		}
+	}
+	if a.HasOption("inline") && a.HasOption("noinline") {
+		return fmt.Errorf("%w: inline and noinline", ErrActionOptionsConflict)
+	}
+	return nil
+}
+
+func validateActionName(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: empty name", ErrActionInvalidName)
+	}
+	for i, c := range name {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c == '_':
+		case (c >= '0' && c <= '9' || c == '-') && i > 0:
+		default:
+			return fmt.Errorf("%w: %q", ErrActionInvalidName, name)
+		}
+	}
+	return nil
+}
+
+func Actions() ([]Action, error) {
+	entries, _, err := ActionsWithOptions(ReadOptions{})
+	return entries, err
+}
+
+// ActionsWithOptions reads the actions file, following includes and
+// evaluating conditional directives as configured by opts. In lenient mode
+// the lines that could not be parsed are returned as warnings, in strict mode
+// they are returned as a ParseErrors error.
+func ActionsWithOptions(opts ReadOptions) ([]Action, []ParseError, error) {
+	lines, err := loadConfigFile(actionsFile, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	issues := &parseIssues{}
+	return result(parseActionsLines(lines, issues), issues, opts.Strict)
+}
+
+func AddAction(action Action) error {
+	return readWriteFile(fullActionsFile, addActionBuff, action)
+}
+
+func RemoveAction(name string) error {
+	return readWriteFile(fullActionsFile, removeActionBuff, name)
+}
+
+// ActionBody returns the body of the action called name.
+func ActionBody(name string) ([]Rule, error) {
+	return readActionBody(shorewallConfigPath, name)
+}
+
+// WriteActionBody creates or replaces the body of the action called name.
+func WriteActionBody(name string, body []Rule) error {
+	return writeActionBody(shorewallConfigPath, name, formatMacroBody(body), nil)
+}
+
+// DeleteActionBody deletes the body of the action called name.
+func DeleteActionBody(name string) error {
+	return deleteActionBody(shorewallConfigPath, name, nil)
+}
+
+// ValidateRuleActions checks that every rule references either a built-in
+// action, a macro, or an action declared in the actions file.
+func ValidateRuleActions(rules []Rule) error {
+	return validateRuleActionsInDirs([]string{shorewallConfigPath, shorewallSharePath}, rules)
+}
+
+func getActionsBuff(buff []byte) ([]Action, error) {
+	return parseActions(buff), nil
+}
+
+func addActionBuff(buff []byte, action Action) ([]byte, error) {
+	if err := action.Validate(); err != nil {
+		return nil, err
+	}
+
+	actions, err := getActionsBuff(buff)
+	if err != nil {
+		return nil, err
+	}
+
+	if slices.ContainsFunc(actions, func(a Action) bool {
+		return a.Equals(action)
+	}) {
+		return nil, ErrActionAlreadyExists
+	}
+
+	return fmt.Appendf(buff, "%s\n", action.Format()), nil
+}
+
+// removeActionBuff removes the declaration of the action called name,
+// leaving the other lines of buff untouched.
+func removeActionBuff(buff []byte, name string) ([]byte, error) {
+	t := parseCST(buff)
+	i, _ := t.find(func(l logicalLine, _ directiveState) bool {
+		return slices.ContainsFunc(parseActionsLines([]logicalLine{l}, nil), func(a Action) bool {
+			return a.Name == name
+		})
+	})
+	if i == -1 {
+		return nil, ErrActionNotFound
+	}
+	t.remove(i)
+	return t.Bytes(), nil
+}
+
+func parseActions(data []byte) []Action {
+	return parseActionsLines(tokenize(data), nil)
+}
+
+func parseActionsLines(lines []logicalLine, issues *parseIssues) (actions []Action) {
+	var state directiveState
+	for _, l := range lines {
+		parts, ok := entryColumns(l, &state, actionColumns, 1, issues)
+		if !ok {
+			continue
+		}
+		if err := validateActionName(parts[0]); err != nil {
+			issues.add(l, 1, "%v", err)
+			continue
+		}
+		action := Action{
+			Name: parts[0],
+		}
+		if len(parts) > 1 && parts[1] != "-" && parts[1] != "" {
+			action.Options = strings.Split(parts[1], ",")
+		}
+		actions = append(actions, action)
+	}
+	return
+}
+
+func actionBodyFileName(name string) string {
+	return actionFilePrefix + name
+}
+
+func readActionBody(dir, name string) ([]Rule, error) {
+	if err := validateActionName(name); err != nil {
+		return nil, err
+	}
+	buff, err := os.ReadFile(path.Join(dir, actionBodyFileName(name)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrActionBodyNotFound, name)
+	} else if err != nil {
+		return nil, err
+	}
+	return getMacroBuff(buff)
+}
+
+// writeActionBody creates or replaces the action.NAME file in dir. If owned
+// is not nil, an existing file is replaced only when owned returns true for
+// its content.
+func writeActionBody(dir, name string, content []byte, owned func([]byte) bool) error {
+	if err := validateActionName(name); err != nil {
+		return err
+	}
+	p := path.Join(dir, actionBodyFileName(name))
+	if owned != nil {
+		buff, err := os.ReadFile(p)
+		if err == nil && !owned(buff) {
+			return fmt.Errorf("%w: %s", ErrActionBodyNotOwned, name)
+		} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.WriteFile(p, content, 0600)
+}
+
+func deleteActionBody(dir, name string, owned func([]byte) bool) error {
+	if err := validateActionName(name); err != nil {
+		return err
+	}
+	p := path.Join(dir, actionBodyFileName(name))
+	if owned != nil {
+		buff, err := os.ReadFile(p)
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %s", ErrActionBodyNotFound, name)
+		} else if err != nil {
+			return err
+		}
+		if !owned(buff) {
+			return fmt.Errorf("%w: %s", ErrActionBodyNotOwned, name)
+		}
+	}
+	err := os.Remove(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrActionBodyNotFound, name)
+	}
+	return err
+}
+
+// actionBaseName returns the name of the action or macro referenced by a
+// rule action, stripping parameters, log levels and modifiers.
+func actionBaseName(action string) string {
+	name, _, _ := splitMacroInvocation(action)
+	return strings.TrimRight(name, "+-!")
+}
+
+// validateRuleActions checks the actions of rules against the built-in
+// actions, the known macros and the declared actions.
+func validateRuleActions(rules []Rule, actions []Action, macros []string) error {
+	var errs []error
+	for _, r := range rules {
+		a, err := ParseRuleAction(r.Action)
+		if err == nil {
+			err = a.Validate(actions, macros)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateRuleActionsInDirs loads the declared actions and the macros from
+// dirs and validates rules against them. The standard actions of the share
+// directory are declared in actions.std.
+func validateRuleActionsInDirs(dirs []string, rules []Rule) error {
+	var actions []Action
+	var macros []string
+	for _, dir := range dirs {
+		for _, f := range []string{actionsFile, actionsStdFile} {
+			buff, err := os.ReadFile(path.Join(dir, f))
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			actions = append(actions, parseActions(buff)...)
+		}
+
+		m, err := listMacros(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		macros = append(macros, m...)
+	}
+	return validateRuleActions(rules, actions, macros)
+}
@@ -0,0 +1,104 @@
+package goshorewall
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+const actions01 = `
+#ACTION		OPTIONS
+MyReject	inline
+Limit		noinline,audit
+SSHKnock
+`
+
+func TestGetActionsBuff(t *testing.T) {
+	actions, err := getActionsBuff([]byte(actions01))
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, 3, len(actions), "expected 3 actions")
+
+	assert.Equal(t, "MyReject", actions[0].Name)
+	assert.Equal(t, []string{"inline"}, actions[0].Options)
+	assert.Equal(t, "Limit", actions[1].Name)
+	assert.Equal(t, []string{"noinline", "audit"}, actions[1].Options)
+	assert.True(t, actions[1].HasOption("audit"))
+	assert.Equal(t, "SSHKnock", actions[2].Name)
+	assert.Nil(t, actions[2].Options)
+}
+
+func TestAddActionBuff(t *testing.T) {
+	buff, err := addActionBuff([]byte(actions01), Action{Name: "Mon", Options: []string{"inline", "proto=tcp"}})
+	assert.NoError(t, err, "expected no error")
+	actions, err := getActionsBuff(buff)
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, 4, len(actions), "expected 4 actions")
+	assert.Equal(t, Action{Name: "Mon", Options: []string{"inline", "proto=tcp"}}, actions[3])
+
+	_, err = addActionBuff([]byte(actions01), Action{Name: "Limit"})
+	assert.ErrorIs(t, err, ErrActionAlreadyExists, "expected ErrActionAlreadyExists")
+
+	_, err = addActionBuff([]byte(actions01), Action{Name: "Bad", Options: []string{"inline", "noinline"}})
+	assert.ErrorIs(t, err, ErrActionOptionsConflict, "expected ErrActionOptionsConflict")
+
+	_, err = addActionBuff([]byte(actions01), Action{Name: "Bad", Options: []string{"fast"}})
+	assert.ErrorIs(t, err, ErrActionInvalidOption, "expected ErrActionInvalidOption")
+
+	_, err = addActionBuff([]byte(actions01), Action{Name: "1Bad"})
+	assert.ErrorIs(t, err, ErrActionInvalidName, "expected ErrActionInvalidName")
+}
+
+func TestRemoveActionBuff(t *testing.T) {
+	buff, err := removeActionBuff([]byte(actions01), "Limit")
+	assert.NoError(t, err, "expected no error")
+	actions, err := getActionsBuff(buff)
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, 2, len(actions), "expected 2 actions")
+	assert.Equal(t, "MyReject", actions[0].Name)
+	assert.Equal(t, "SSHKnock", actions[1].Name)
+	assert.Equal(t, "\n#ACTION\t\tOPTIONS\nMyReject\tinline\nSSHKnock\n", string(buff))
+
+	_, err = removeActionBuff([]byte(actions01), "Missing")
+	assert.ErrorIs(t, err, ErrActionNotFound, "expected ErrActionNotFound")
+}
+
+func TestActionBody(t *testing.T) {
+	dir := t.TempDir()
+	id := uuid.NewString()
+	body := []Rule{{Action: "REJECT:info"}}
+
+	content := wrapBuffWithAppIdentifier(formatMacroBody(body), id)
+	assert.NoError(t, writeActionBody(dir, "MyReject", content, isMacroBodyOwnedBy(id)))
+
+	rules, err := readActionBody(dir, "MyReject")
+	assert.NoError(t, err, "reading action body")
+	assert.Equal(t, body, rules)
+
+	err = writeActionBody(dir, "MyReject", content, isMacroBodyOwnedBy(uuid.NewString()))
+	assert.ErrorIs(t, err, ErrActionBodyNotOwned)
+	assert.NoError(t, deleteActionBody(dir, "MyReject", isMacroBodyOwnedBy(id)))
+
+	_, err = readActionBody(dir, "MyReject")
+	assert.ErrorIs(t, err, ErrActionBodyNotFound)
+}
+
+func TestValidateRuleActions(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(path.Join(dir, actionsFile), []byte(actions01), 0600))
+	assert.NoError(t, os.WriteFile(path.Join(dir, "macro.Web"), []byte(macroWeb), 0600))
+
+	rules := []Rule{
+		{Action: "ACCEPT", Source: "net", Destination: "fw"},
+		{Action: "DNAT-", Source: "net", Destination: "loc:10.0.0.1"},
+		{Action: "Web(ACCEPT)", Source: "net", Destination: "fw"},
+		{Action: "Limit(3,60):info", Source: "net", Destination: "fw"},
+		{Action: "MyReject", Source: "net", Destination: "fw"},
+	}
+	assert.NoError(t, validateRuleActionsInDirs([]string{dir}, rules))
+
+	rules = append(rules, Rule{Action: "Undeclared", Source: "net", Destination: "fw"})
+	assert.ErrorIs(t, validateRuleActionsInDirs([]string{dir}, rules), ErrActionNotDeclared)
+}
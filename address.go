@@ -0,0 +1,459 @@
+package goshorewall
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidAddress        = errors.New("invalid address")
+	ErrAddressFamilyMismatch = errors.New("address does not match the address family")
+	ErrIPv6NotBracketed      = errors.New("IPv6 address must be enclosed in <> or []")
+)
+
+// AddressKind is the kind of an item of an address list.
+type AddressKind int
+
+const (
+	// AddressHost is a single IP address.
+	AddressHost AddressKind = iota
+	// AddressNetwork is a network in CIDR notation.
+	AddressNetwork
+	// AddressRange is a range of addresses, first-last.
+	AddressRange
+	// AddressIPSet is an ipset, +name.
+	AddressIPSet
+	// AddressCountry is a country code, ^CC.
+	AddressCountry
+	// AddressInterface is the primary address of an interface, &iface.
+	AddressInterface
+	// AddressMAC is a MAC address, ~00-11-22-33-44-55.
+	AddressMAC
+	// AddressVariable is a shell variable, $NAME.
+	AddressVariable
+	// AddressDNS is a DNS name, resolved when Shorewall compiles the
+	// configuration.
+	AddressDNS
+)
+
+// addressSigils are the leading characters of the address items that are
+// names.
+var addressSigils = map[byte]AddressKind{
+	'+': AddressIPSet, '^': AddressCountry, '&': AddressInterface, '~': AddressMAC, '$': AddressVariable,
+}
+
+// AddressItem is an item of an address list.
+type AddressItem struct {
+	Kind AddressKind
+	// Prefix is the address of an AddressHost, as a full length prefix, or
+	// the network of an AddressNetwork.
+	Prefix netip.Prefix
+	// From and To are the bounds of an AddressRange.
+	From, To netip.Addr
+	// Name is the name of the other kinds, without its leading sigil.
+	Name string
+}
+
+func (a AddressItem) String() string {
+	switch a.Kind {
+	case AddressHost:
+		return a.Prefix.Addr().String()
+	case AddressNetwork:
+		return a.Prefix.String()
+	case AddressRange:
+		return a.From.String() + "-" + a.To.String()
+	case AddressIPSet:
+		return "+" + a.Name
+	case AddressCountry:
+		return "^" + a.Name
+	case AddressInterface:
+		return "&" + a.Name
+	case AddressMAC:
+		return "~" + a.Name
+	case AddressVariable:
+		return "$" + a.Name
+	}
+	return a.Name
+}
+
+// AddressList is a comma separated list of addresses, with an optional
+// exclusion list introduced by "!", as in "10.0.0.0/8!10.1.0.0/16".
+type AddressList struct {
+	Include []AddressItem
+	Exclude []AddressItem
+}
+
+func (l AddressList) String() string {
+	s := joinAddressItems(l.Include)
+	if len(l.Exclude) > 0 {
+		s += "!" + joinAddressItems(l.Exclude)
+	}
+	return s
+}
+
+// IsEmpty reports whether the list has no address.
+func (l AddressList) IsEmpty() bool {
+	return len(l.Include) == 0 && len(l.Exclude) == 0
+}
+
+func joinAddressItems(items []AddressItem) string {
+	s := make([]string, len(items))
+	for i, item := range items {
+		s[i] = item.String()
+	}
+	return strings.Join(s, ",")
+}
+
+// AddressSpec is the parsed form of an address specification such as the
+// SOURCE and DEST columns of a rule, zone[!zone,...][:interface][:addresses][:port],
+// or of a bare address list.
+type AddressSpec struct {
+	// Zones are the zones of the specification, empty for a bare address
+	// list. ExcludedZones are the zones following "!", as in "all!loc".
+	Zones         []string
+	ExcludedZones []string
+	Interface     string
+	Addresses     AddressList
+	// Bracketed reports whether the addresses are enclosed in <> or [], as
+	// required for IPv6 addresses following a zone or an interface.
+	Bracketed bool
+	// Port is the port of the DEST column of DNAT and REDIRECT rules.
+	Port string
+}
+
+func (a AddressSpec) String() string {
+	var parts []string
+	if len(a.Zones) > 0 {
+		zones := strings.Join(a.Zones, ",")
+		if len(a.ExcludedZones) > 0 {
+			zones += "!" + strings.Join(a.ExcludedZones, ",")
+		}
+		parts = append(parts, zones)
+	}
+	if a.Interface != "" {
+		parts = append(parts, a.Interface)
+	}
+	if !a.Addresses.IsEmpty() {
+		addrs := a.Addresses.String()
+		if a.Bracketed {
+			addrs = "<" + addrs + ">"
+		}
+		parts = append(parts, addrs)
+	}
+	if a.Port != "" {
+		parts = append(parts, a.Port)
+	}
+	return strings.Join(parts, ":")
+}
+
+// ValidateAddress validates an address specification in the zone:address
+// form used by the SOURCE and DEST columns. For IPv6 the addresses following
+// a zone or interface must be enclosed in <> or [], as in
+// "net:<2001:db8::/32>".
+func ValidateAddress(family Family, addr string) error {
+	_, err := ParseAddress(family, addr)
+	return err
+}
+
+// ParseAddress parses an address specification in the zone:address form
+// used by the SOURCE and DEST columns, see ValidateAddress. An empty
+// specification or "-" gives the zero AddressSpec.
+//
+// A name following the zone is the interface, unless it is the last
+// component and looks like a DNS name; a name following the addresses is the
+// port.
+func ParseAddress(family Family, addr string) (AddressSpec, error) {
+	var spec AddressSpec
+	if addr == "" || addr == "-" {
+		return spec, nil
+	}
+
+	parts, err := splitAddressColumns(addr)
+	if err != nil {
+		return spec, err
+	}
+
+	if len(parts) == 1 {
+		// Either a zone or a bare address list
+		if zones, excluded, ok := parseZoneList(parts[0]); ok {
+			spec.Zones, spec.ExcludedZones = zones, excluded
+			return spec, nil
+		}
+		spec.Addresses, err = parseAddressList(family, parts[0], addr)
+		return spec, err
+	}
+
+	zones, excluded, isZone := parseZoneList(parts[0])
+	if family == IPv6 && !isZone {
+		// A bare IPv6 address list, colons belong to the addresses
+		spec.Addresses, err = parseAddressList(family, addr, addr)
+		return spec, err
+	}
+	if family == IPv6 && len(parts) > 4 {
+		return spec, fmt.Errorf("%w: %q", ErrIPv6NotBracketed, addr)
+	}
+
+	if !isZone {
+		return spec, fmt.Errorf("%w: %q: invalid zone %q", ErrInvalidAddress, addr, parts[0])
+	}
+	spec.Zones, spec.ExcludedZones = zones, excluded
+	for i, p := range parts[1:] {
+		last := i == len(parts)-2
+		if p == "" {
+			if family == IPv6 {
+				return spec, fmt.Errorf("%w: %q", ErrIPv6NotBracketed, addr)
+			}
+			return spec, fmt.Errorf("%w: %q: empty component", ErrInvalidAddress, addr)
+		}
+		hasAddresses := !spec.Addresses.IsEmpty()
+		switch {
+		case isBracketed(p):
+			if hasAddresses {
+				return spec, fmt.Errorf("%w: %q: unexpected addresses %q", ErrInvalidAddress, addr, p)
+			}
+			spec.Bracketed = true
+			if spec.Addresses, err = parseAddressList(family, p[1:len(p)-1], addr); err != nil {
+				return spec, err
+			}
+		case family == IPv6 && looksLikeIPv6(p):
+			return spec, fmt.Errorf("%w: %q", ErrIPv6NotBracketed, addr)
+		case hasAddresses && isName(p) && !strings.ContainsAny(p, ".+"):
+			if spec.Port != "" {
+				return spec, fmt.Errorf("%w: %q: unexpected component %q", ErrInvalidAddress, addr, p)
+			}
+			spec.Port = p
+		case isInterfaceName(p) && spec.Interface == "" && !(last && isDNSName(p)):
+			spec.Interface = p
+		case hasAddresses:
+			return spec, fmt.Errorf("%w: %q: unexpected addresses %q", ErrInvalidAddress, addr, p)
+		default:
+			if spec.Addresses, err = parseAddressList(family, p, addr); err != nil {
+				return spec, err
+			}
+		}
+	}
+	return spec, nil
+}
+
+// splitAddressColumns splits an address specification on the colons that
+// are not enclosed in <> or [].
+func splitAddressColumns(addr string) ([]string, error) {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range addr {
+		switch c {
+		case '<', '[':
+			depth++
+		case '>', ']':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("%w: %q: unbalanced brackets", ErrInvalidAddress, addr)
+			}
+		case ':':
+			if depth == 0 {
+				parts = append(parts, addr[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("%w: %q: unbalanced brackets", ErrInvalidAddress, addr)
+	}
+	return append(parts, addr[start:]), nil
+}
+
+func isBracketed(s string) bool {
+	return len(s) >= 2 && (s[0] == '<' && s[len(s)-1] == '>' || s[0] == '[' && s[len(s)-1] == ']')
+}
+
+func looksLikeIPv6(s string) bool {
+	s, _, _ = strings.Cut(strings.TrimPrefix(s, "!"), "/")
+	_, err := netip.ParseAddr(s)
+	return err == nil && strings.Contains(s, ":")
+}
+
+// isName reports whether s is a valid zone, interface or host name.
+func isName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '_', c == '-', c == '.', c == '+':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isInterfaceName reports whether s can be an interface name rather than an
+// address: a name not starting with a digit.
+func isInterfaceName(s string) bool {
+	return isName(s) && (s[0] < '0' || s[0] > '9')
+}
+
+// ParseSnatDestination parses the DEST column of a snat entry,
+// interface[:digit][:addresses]. The alias digit is kept in the interface
+// name, as in "eth0:1".
+func ParseSnatDestination(family Family, dest string) (AddressSpec, error) {
+	var spec AddressSpec
+	if dest == "" || dest == "-" {
+		return spec, nil
+	}
+	parts, err := splitAddressColumns(dest)
+	if err != nil {
+		return spec, err
+	}
+	if !isName(parts[0]) {
+		return spec, fmt.Errorf("%w: %q: invalid interface %q", ErrInvalidAddress, dest, parts[0])
+	}
+	spec.Interface = parts[0]
+	rest := parts[1:]
+	if len(rest) > 0 && len(rest[0]) == 1 && rest[0][0] >= '0' && rest[0][0] <= '9' {
+		spec.Interface += ":" + rest[0]
+		rest = rest[1:]
+	}
+	switch {
+	case len(rest) == 0:
+	case len(rest) == 1 && isBracketed(rest[0]):
+		spec.Bracketed = true
+		spec.Addresses, err = parseAddressList(family, rest[0][1:len(rest[0])-1], dest)
+	case family == IPv6:
+		return spec, fmt.Errorf("%w: %q", ErrIPv6NotBracketed, dest)
+	case len(rest) == 1:
+		spec.Addresses, err = parseAddressList(family, rest[0], dest)
+	default:
+		return spec, fmt.Errorf("%w: %q: unexpected component %q", ErrInvalidAddress, dest, rest[1])
+	}
+	return spec, err
+}
+
+// isZoneList reports whether s is a comma separated list of zones.
+func isZoneList(s string) bool {
+	for z := range strings.SplitSeq(s, ",") {
+		z = strings.TrimPrefix(z, "$")
+		z = strings.TrimRight(z, "+-")
+		if z == "" || !isName(z) || strings.Contains(z, ".") || z[0] >= '0' && z[0] <= '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseZoneList parses a comma separated list of zones, with an optional
+// list of excluded zones introduced by "!", as in "all!loc,dmz".
+func parseZoneList(s string) ([]string, []string, bool) {
+	include, exclude, found := strings.Cut(s, "!")
+	if !isZoneList(include) || found && !isZoneList(exclude) {
+		return nil, nil, false
+	}
+	var excluded []string
+	if found {
+		excluded = strings.Split(exclude, ",")
+	}
+	return strings.Split(include, ","), excluded, true
+}
+
+// isDNSName reports whether the name s is a DNS name rather than an
+// interface name such as "eth0.100": it has a dot and its last label is not
+// a number.
+func isDNSName(s string) bool {
+	i := strings.LastIndex(s, ".")
+	if i == -1 || i == len(s)-1 {
+		return false
+	}
+	_, err := strconv.Atoi(s[i+1:])
+	return err != nil
+}
+
+// parseAddressList parses a comma separated list of addresses with an
+// optional exclusion list introduced by "!".
+func parseAddressList(family Family, list, addr string) (AddressList, error) {
+	var l AddressList
+	include, exclude, _ := strings.Cut(list, "!")
+	for _, part := range []struct {
+		list  string
+		items *[]AddressItem
+	}{{include, &l.Include}, {exclude, &l.Exclude}} {
+		if part.list == "" {
+			continue
+		}
+		for item := range strings.SplitSeq(part.list, ",") {
+			a, err := parseAddressItem(family, item)
+			if err != nil {
+				return AddressList{}, fmt.Errorf("%w: %q: %w", ErrInvalidAddress, addr, err)
+			}
+			*part.items = append(*part.items, a)
+		}
+	}
+	return l, nil
+}
+
+func parseAddressItem(family Family, item string) (AddressItem, error) {
+	switch {
+	case item == "":
+		return AddressItem{}, errors.New("empty address")
+	case item[0] == '+', item[0] == '^', item[0] == '&', item[0] == '~', item[0] == '$':
+		if len(item) == 1 {
+			return AddressItem{}, fmt.Errorf("incomplete address %q", item)
+		}
+		return AddressItem{Kind: addressSigils[item[0]], Name: item[1:]}, nil
+	}
+
+	if from, to, ok := strings.Cut(item, "-"); ok {
+		if a, err := netip.ParseAddr(from); err == nil {
+			b, err := netip.ParseAddr(to)
+			if err != nil {
+				return AddressItem{}, fmt.Errorf("invalid range %q", item)
+			}
+			if err := checkAddrFamily(family, a); err != nil {
+				return AddressItem{}, err
+			}
+			return AddressItem{Kind: AddressRange, From: a, To: b}, checkAddrFamily(family, b)
+		}
+	}
+
+	if p, err := netip.ParsePrefix(item); err == nil {
+		return AddressItem{Kind: AddressNetwork, Prefix: p}, checkAddrFamily(family, p.Addr())
+	}
+	if a, err := netip.ParseAddr(item); err == nil {
+		return AddressItem{Kind: AddressHost, Prefix: netip.PrefixFrom(a, a.BitLen())}, checkAddrFamily(family, a)
+	}
+	if isName(item) && !strings.Contains(item, "/") {
+		return AddressItem{Kind: AddressDNS, Name: item}, nil
+	}
+	return AddressItem{}, fmt.Errorf("invalid address %q", item)
+}
+
+func checkAddrFamily(family Family, a netip.Addr) error {
+	if a.Is4() && family == IPv6 || a.Is6() && !a.Is4In6() && family == IPv4 {
+		return fmt.Errorf("%w: %s is not an %s address", ErrAddressFamilyMismatch, a, family)
+	}
+	return nil
+}
+
+// containsIPLiteral reports whether an address specification contains an IP
+// address literal of any family.
+func containsIPLiteral(addr string) bool {
+	parts, err := splitAddressColumns(addr)
+	if err != nil {
+		return false
+	}
+	for _, p := range parts {
+		if isBracketed(p) {
+			p = p[1 : len(p)-1]
+		}
+		for item := range strings.FieldsFuncSeq(p, func(r rune) bool { return r == ',' || r == '!' || r == '-' }) {
+			item, _, _ = strings.Cut(item, "/")
+			if _, err := netip.ParseAddr(item); err == nil {
+				return true
+			}
+		}
+	}
+	// An unbracketed IPv6 address is split on its colons
+	return looksLikeIPv6(addr)
+}
@@ -0,0 +1,126 @@
+package goshorewall
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateAddress(t *testing.T) {
+	testCases := []struct {
+		family Family
+		addr   string
+		err    error
+	}{
+		{IPv4, "net", nil},
+		{IPv4, "h,h4,g", nil},
+		{IPv4, "$FW", nil},
+		{IPv4, "net:1.2.3.4", nil},
+		{IPv4, "pve:10.90.0.4:22", nil},
+		{IPv4, "loc:eth0:192.168.1.0/24", nil},
+		{IPv4, "net:10.0.0.0/8!10.1.0.0/16", nil},
+		{IPv4, "net:+blacklist", nil},
+		{IPv4, "net:^CN", nil},
+		{IPv4, "172.16.0.0/12", nil},
+		{IPv4, "net:10.0.0.1-10.0.0.9", nil},
+		{IPv4, "net:<2001:db8::/32>", ErrAddressFamilyMismatch},
+		{IPv4, "net:1.2.3.4/33", ErrInvalidAddress},
+		{IPv6, "net:<2001:db8::/32>", nil},
+		{IPv6, "net:[2001:db8::1]", nil},
+		{IPv6, "loc:eth0:<2001:db8::1,2001:db8::2>", nil},
+		{IPv6, "net:<2001:db8::/32!2001:db8:1::/48>", nil},
+		{IPv6, "2001:db8::/32", nil},
+		{IPv6, "net:2001:db8::1", ErrIPv6NotBracketed},
+		{IPv6, "net:<1.2.3.4>", ErrAddressFamilyMismatch},
+		{IPv6, "net:<2001:db8::1", ErrInvalidAddress},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.family.String()+" "+tc.addr, func(t *testing.T) {
+			err := ValidateAddress(tc.family, tc.addr)
+			if tc.err == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestCheckRuleFamilyAgnostic(t *testing.T) {
+	assert.NoError(t, checkRuleFamilyAgnostic(Rule{Action: "ACCEPT", Source: "net", Destination: "fw", Protocol: "tcp", Dport: "22"}))
+	assert.NoError(t, checkRuleFamilyAgnostic(Rule{Action: "ACCEPT", Source: "net:+allowed", Destination: "fw"}))
+	assert.ErrorIs(t, checkRuleFamilyAgnostic(Rule{Action: "ACCEPT", Source: "net:1.2.3.4", Destination: "fw"}), ErrRuleNotFamilyAgnostic)
+	assert.ErrorIs(t, checkRuleFamilyAgnostic(Rule{Action: "ACCEPT", Source: "net", Destination: "fw:<2001:db8::1>"}), ErrRuleNotFamilyAgnostic)
+}
+
+func TestParseAddress(t *testing.T) {
+	host := func(s string) AddressItem {
+		a := netip.MustParseAddr(s)
+		return AddressItem{Kind: AddressHost, Prefix: netip.PrefixFrom(a, a.BitLen())}
+	}
+	network := func(s string) AddressItem {
+		return AddressItem{Kind: AddressNetwork, Prefix: netip.MustParsePrefix(s)}
+	}
+
+	testCases := []struct {
+		family Family
+		addr   string
+		spec   AddressSpec
+	}{
+		{IPv4, "-", AddressSpec{}},
+		{IPv4, "h,h4,g", AddressSpec{Zones: []string{"h", "h4", "g"}}},
+		{IPv4, "all!loc,dmz", AddressSpec{Zones: []string{"all"}, ExcludedZones: []string{"loc", "dmz"}}},
+		{IPv4, "net:1.2.3.4,5.6.7.8", AddressSpec{Zones: []string{"net"}, Addresses: AddressList{Include: []AddressItem{host("1.2.3.4"), host("5.6.7.8")}}}},
+		{IPv4, "pve:10.90.0.4:22", AddressSpec{Zones: []string{"pve"}, Addresses: AddressList{Include: []AddressItem{host("10.90.0.4")}}, Port: "22"}},
+		{IPv4, "loc:eth0.100:192.168.1.0/24!192.168.1.1", AddressSpec{Zones: []string{"loc"}, Interface: "eth0.100",
+			Addresses: AddressList{Include: []AddressItem{network("192.168.1.0/24")}, Exclude: []AddressItem{host("192.168.1.1")}}}},
+		{IPv4, "net:eth0", AddressSpec{Zones: []string{"net"}, Interface: "eth0"}},
+		{IPv4, "net:www.example.com", AddressSpec{Zones: []string{"net"}, Addresses: AddressList{Include: []AddressItem{{Kind: AddressDNS, Name: "www.example.com"}}}}},
+		{IPv4, "net:+blacklist,^CN,&ppp0,~00-11-22-33-44-55", AddressSpec{Zones: []string{"net"}, Addresses: AddressList{Include: []AddressItem{
+			{Kind: AddressIPSet, Name: "blacklist"}, {Kind: AddressCountry, Name: "CN"},
+			{Kind: AddressInterface, Name: "ppp0"}, {Kind: AddressMAC, Name: "00-11-22-33-44-55"},
+		}}}},
+		{IPv4, "net:10.0.0.1-10.0.0.9", AddressSpec{Zones: []string{"net"}, Addresses: AddressList{Include: []AddressItem{
+			{Kind: AddressRange, From: netip.MustParseAddr("10.0.0.1"), To: netip.MustParseAddr("10.0.0.9")},
+		}}}},
+		{IPv4, "172.16.0.0/12", AddressSpec{Addresses: AddressList{Include: []AddressItem{network("172.16.0.0/12")}}}},
+		{IPv6, "loc:eth0:<2001:db8::1,2001:db8::2>", AddressSpec{Zones: []string{"loc"}, Interface: "eth0", Bracketed: true,
+			Addresses: AddressList{Include: []AddressItem{host("2001:db8::1"), host("2001:db8::2")}}}},
+		{IPv6, "2001:db8::/32", AddressSpec{Addresses: AddressList{Include: []AddressItem{network("2001:db8::/32")}}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.family.String()+" "+tc.addr, func(t *testing.T) {
+			spec, err := ParseAddress(tc.family, tc.addr)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.spec, spec)
+			if tc.addr != "-" {
+				assert.Equal(t, tc.addr, spec.String())
+			}
+		})
+	}
+
+	_, err := ParseAddress(IPv4, "net:1.2.3.4:5.6.7.8")
+	assert.ErrorIs(t, err, ErrInvalidAddress)
+}
+
+func TestParseSnatDestination(t *testing.T) {
+	spec, err := ParseSnatDestination(IPv4, "eth0")
+	assert.NoError(t, err)
+	assert.Equal(t, AddressSpec{Interface: "eth0"}, spec)
+
+	spec, err = ParseSnatDestination(IPv4, "eth0:1:203.0.113.5")
+	assert.NoError(t, err)
+	assert.Equal(t, "eth0:1", spec.Interface)
+	assert.Equal(t, "203.0.113.5", spec.Addresses.String())
+	assert.Equal(t, "eth0:1:203.0.113.5", spec.String())
+
+	spec, err = ParseSnatDestination(IPv6, "eth0:[2001:db8::1]")
+	assert.NoError(t, err)
+	assert.Equal(t, "2001:db8::1", spec.Addresses.String())
+
+	_, err = ParseSnatDestination(IPv6, "eth0:2001:db8::1")
+	assert.ErrorIs(t, err, ErrIPv6NotBracketed)
+}
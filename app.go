@@ -3,9 +3,9 @@ package goshorewall
 import (
 	"bytes"
 	"fmt"
-	"io"
 	"os"
 	"path"
+	"slices"
 
 	"github.com/gofrs/flock"
 	"github.com/google/uuid"
@@ -20,9 +20,17 @@ var lockDirPath = "/tmp/tsh/.goshorewall"
 // from other applications. This identifier must be saved by the application to be able
 // to manage its Shorewall configuration across restarts. App also handles concurrent
 // access to Shorewall configuration files.
+//
+// Mutations do not check the references between files by default: the check
+// is opt-in, enabled by setting App.ReferenceCheck with SetPreCommitHook.
 type App struct {
 	basePath   string
+	family     Family
 	identifier uuid.UUID
+	preCommit  PreCommitHook
+	// maxZoneNameLength is the limit of zone names, 0 for
+	// DefaultMaxZoneNameLength.
+	maxZoneNameLength int
 }
 
 // NewApp creates a new App with a random generated identifier.
@@ -33,24 +41,47 @@ func NewApp() (*App, error) {
 	return NewAppWithBasePath(shorewallConfigPath)
 }
 
+// NewApp6 creates a new App managing the shorewall6 configuration with a
+// random generated identifier.
+func NewApp6() (*App, error) {
+	return NewAppWithFamily(IPv6, IPv6.configPath())
+}
+
+// NewAppWithBasePath creates a new App managing the IPv4 Shorewall
+// configuration stored in basePath.
 func NewAppWithBasePath(basePath string) (*App, error) {
+	return NewAppWithFamily(IPv4, basePath)
+}
+
+// NewAppWithFamily creates a new App managing the configuration of the given
+// address family stored in basePath.
+func NewAppWithFamily(family Family, basePath string) (*App, error) {
 	id, err := uuid.NewRandom()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate application identifier: %w", err)
 	}
 	return &App{
 		basePath:   basePath,
+		family:     family,
 		identifier: id,
 	}, nil
 }
 
 // AppFromID creates an App instance from a previously saved identifier.
 func AppFromID(id string) (*App, error) {
+	return AppFromIDWithFamily(id, IPv4, IPv4.configPath())
+}
+
+// AppFromIDWithFamily creates an App instance managing the configuration of
+// the given address family stored in basePath from a previously saved identifier.
+func AppFromIDWithFamily(id string, family Family, basePath string) (*App, error) {
 	parsedID, err := uuid.Parse(id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse application identifier: %w", err)
 	}
 	return &App{
+		basePath:   basePath,
+		family:     family,
 		identifier: parsedID,
 	}, nil
 }
@@ -60,6 +91,38 @@ func (a *App) ID() string {
 	return a.identifier.String()
 }
 
+// SetPreCommitHook sets the hook called before the App instance writes its
+// blocks of the zones, interfaces, policy, rules, snat, proxyarp, proxyndp,
+// conntrack or actions file, e.g. App.ReferenceCheck. A nil hook disables
+// the check. No hook is set by default. While a hook is
+// set, mutations hold the locks of every file the hook may read, see
+// writeLocks.
+func (a *App) SetPreCommitHook(hook PreCommitHook) {
+	a.preCommit = hook
+}
+
+// SetMaxZoneNameLength sets the maximum length of the zone names accepted by
+// the App instance, for Shorewall configurations whose LOGFORMAT allows
+// names longer than DefaultMaxZoneNameLength. A limit of 0 restores the
+// default.
+func (a *App) SetMaxZoneNameLength(n int) {
+	a.maxZoneNameLength = n
+}
+
+// MaxZoneNameLength returns the maximum length of the zone names accepted by
+// the App instance.
+func (a *App) MaxZoneNameLength() int {
+	if a.maxZoneNameLength == 0 {
+		return DefaultMaxZoneNameLength
+	}
+	return a.maxZoneNameLength
+}
+
+// Family returns the address family managed by the App instance.
+func (a *App) Family() Family {
+	return a.family
+}
+
 // BasePath returns the Shorewall configuration base path used by the App instance.
 func (a *App) BasePath() string {
 	return a.basePath
@@ -75,6 +138,11 @@ func (a *App) InterfaceFilePath() string {
 	return path.Join(a.basePath, interfacesFile)
 }
 
+// HostsFilePath returns the full path to the hosts file used by the App instance.
+func (a *App) HostsFilePath() string {
+	return path.Join(a.basePath, hostsFile)
+}
+
 // PolicyFilePath returns the full path to the policy file used by the App instance.
 func (a *App) PolicyFilePath() string {
 	return path.Join(a.basePath, policyFile)
@@ -90,89 +158,488 @@ func (a *App) SnatFilePath() string {
 	return path.Join(a.basePath, snatFile)
 }
 
-// Reload reloads Shorewall configuration.
+// ProxyArpFilePath returns the full path to the proxyarp file used by the App instance.
+func (a *App) ProxyArpFilePath() string {
+	return path.Join(a.basePath, proxyArpFile)
+}
+
+// ProxyNdpFilePath returns the full path to the proxyndp file used by the App instance.
+func (a *App) ProxyNdpFilePath() string {
+	return path.Join(a.basePath, proxyNdpFile)
+}
+
+// ConntrackFilePath returns the full path to the conntrack file used by the App instance.
+func (a *App) ConntrackFilePath() string {
+	return path.Join(a.basePath, conntrackFile)
+}
+
+// ConfigFilePath returns the full path to the shorewall.conf file used by the App instance.
+func (a *App) ConfigFilePath() string {
+	return path.Join(a.basePath, a.family.confFile())
+}
+
+// ActionsFilePath returns the full path to the actions file used by the App instance.
+func (a *App) ActionsFilePath() string {
+	return path.Join(a.basePath, actionsFile)
+}
+
+// Reload reloads Shorewall configuration, using shorewall6 for IPv6 App instances.
 func (a *App) Reload() error {
-	return execWithLock("reload", Reload)
+	return execWithLock(a.lockComponent("reload"), func() error {
+		return reload(a.family)
+	})
 }
 
-// Version returns the Shorewall version.
+// Version returns the Shorewall version, using shorewall6 for IPv6 App instances.
 func (a *App) Version() (string, error) {
-	return Version()
+	return version(a.family)
+}
+
+// ValidateAddress validates an address specification, such as the SOURCE or
+// DEST column of a rule, for the address family of the App instance.
+func (a *App) ValidateAddress(addr string) error {
+	return ValidateAddress(a.family, addr)
 }
 
 // Interfaces returns the list of interfaces managed by the App instance.
 func (a *App) Interfaces() ([]Interface, error) {
-	return execGetWithLock("interfaces", a.ID(), interfacesFile, getInterfacesBuff)
+	entries, _, err := a.InterfacesWithOptions(ReadOptions{})
+	return entries, err
+}
+
+// InterfacesWithOptions is Interfaces reporting the lines of the block of the App instance
+// that could not be parsed: as warnings in lenient mode, as a ParseErrors
+// error if opts.Strict is set. The other options do not apply to App blocks.
+func (a *App) InterfacesWithOptions(opts ReadOptions) ([]Interface, []ParseError, error) {
+	return execGetWithOptionsLock(a.lockComponent("interfaces"), a.ID(), a.InterfaceFilePath(), parseInterfacesLines, opts.Strict)
 }
 
 // AddInterface adds a new interface to the Shorewall configuration managed by the App instance.
 func (a *App) AddInterface(iface Interface) error {
-	return execAddRemoveWithLock("interfaces", a.ID(), interfacesFile, addInterfaceBuff, iface)
+	return execFormatWithLock(a, a.lockComponent("interfaces"), a.InterfaceFilePath(), 2, addInterfaceBuff, iface)
 }
 
 // RemoveInterfaceByZone removes all interfaces associated with the specified zone
 func (a *App) RemoveInterfaceByZone(zone string) error {
-	return execAddRemoveWithLock("interfaces", a.ID(), interfacesFile, removeInterfaceByZoneBuff, zone)
+	return execFormatWithLock(a, a.lockComponent("interfaces"), a.InterfaceFilePath(), 2, removeInterfaceByZoneBuff, zone)
+}
+
+// UpdateInterface replaces an interface of the Shorewall configuration managed by the App instance.
+func (a *App) UpdateInterface(from, to Interface) error {
+	return execFormatWithLock(a, a.lockComponent("interfaces"), a.InterfaceFilePath(), 2, updateInterfaceBuff, entryUpdate[Interface]{old: from, new: to})
+}
+
+// Hosts returns the list of hosts file entries managed by the App instance.
+func (a *App) Hosts() ([]Host, error) {
+	entries, _, err := a.HostsWithOptions(ReadOptions{})
+	return entries, err
+}
+
+// HostsWithOptions is Hosts reporting the lines of the block of the App instance
+// that could not be parsed: as warnings in lenient mode, as a ParseErrors
+// error if opts.Strict is set. The other options do not apply to App blocks.
+func (a *App) HostsWithOptions(opts ReadOptions) ([]Host, []ParseError, error) {
+	return execGetWithOptionsLock(a.lockComponent("hosts"), a.ID(), a.HostsFilePath(), parseHostsLines, opts.Strict)
 }
 
 // Policies returns the list of policies managed by the App instance.
 func (a *App) Policies() ([]Policy, error) {
-	return execGetWithLock("policies", a.ID(), policyFile, getPoliciesBuff)
+	entries, _, err := a.PoliciesWithOptions(ReadOptions{})
+	return entries, err
+}
+
+// PoliciesWithOptions is Policies reporting the lines of the block of the App instance
+// that could not be parsed: as warnings in lenient mode, as a ParseErrors
+// error if opts.Strict is set. The other options do not apply to App blocks.
+func (a *App) PoliciesWithOptions(opts ReadOptions) ([]Policy, []ParseError, error) {
+	return execGetWithOptionsLock(a.lockComponent("policies"), a.ID(), a.PolicyFilePath(), parsePoliciesLines, opts.Strict)
 }
 
 // AddPolicy adds a new policy to the Shorewall configuration managed by the App instance.
 func (a *App) AddPolicy(policy Policy) error {
-	return execAddRemoveWithLock("policies", a.ID(), policyFile, addPolicyBuff, policy)
+	return execAppWithLock(a, a.lockComponent("policies"), a.PolicyFilePath(), addPolicyBuff, policy)
 }
 
 // RemovePolicy removes a policy from the Shorewall configuration managed by the App instance.
 func (a *App) RemovePolicy(policy Policy) error {
-	return execAddRemoveWithLock("policies", a.ID(), policyFile, removePolicyBuff, policy)
+	return execAppWithLock(a, a.lockComponent("policies"), a.PolicyFilePath(), removePolicyBuff, policy)
+}
+
+// UpdatePolicy replaces a policy of the Shorewall configuration managed by the App instance.
+func (a *App) UpdatePolicy(from, to Policy) error {
+	return execAppWithLock(a, a.lockComponent("policies"), a.PolicyFilePath(), updatePolicyBuff, entryUpdate[Policy]{old: from, new: to})
 }
 
-// Rules returns the list of rules managed by the App instance.
+// Rules returns the list of rules managed by the App instance. Each rule
+// reports the ?SECTION of the rules file it belongs to.
 func (a *App) Rules() ([]Rule, error) {
-	return execGetWithLock("rules", a.ID(), rulesFile, getRulesBuff)
+	rules, _, err := a.RulesWithOptions(ReadOptions{})
+	return rules, err
+}
+
+// RulesWithOptions is Rules reporting the lines of the blocks of the App
+// instance that could not be parsed: as warnings in lenient mode, as a
+// ParseErrors error if opts.Strict is set. The other options do not apply to
+// App blocks.
+func (a *App) RulesWithOptions(opts ReadOptions) ([]Rule, []ParseError, error) {
+	var rules []Rule
+	var warnings []ParseError
+	err := execWithLock(a.lockComponent("rules"), func() error {
+		buff, err := os.ReadFile(a.RulesFilePath())
+		if err != nil {
+			return err
+		}
+		issues := &parseIssues{file: a.RulesFilePath()}
+		rules, err = parseAppRules(a.ID(), buff, issues)
+		if err != nil {
+			return err
+		}
+		rules, warnings, err = result(rules, issues, opts.Strict)
+		return err
+	})
+	return rules, warnings, err
 }
 
 // AddRule adds a new rule to the Shorewall configuration managed by the App instance.
+// Rules with a Section are placed in a block of the App instance inside that
+// section of the rules file, the section is created if needed.
 func (a *App) AddRule(rule Rule) error {
-	return execAddRemoveWithLock("rules", a.ID(), rulesFile, addRuleBuff, rule)
+	return execWithLocks(a.writeLocks(a.lockComponent("rules")), func() error {
+		return writeAppFile(a, a.RulesFilePath(), func(buff []byte, rule Rule) ([]byte, error) {
+			return addAppRuleBuff(a.ID(), buff, rule)
+		}, rule)
+	})
 }
 
 // RemoveRule removes a rule from the Shorewall configuration managed by the App instance.
 func (a *App) RemoveRule(rule Rule) error {
-	return execAddRemoveWithLock("rules", a.ID(), rulesFile, removeRuleBuff, rule)
+	return execWithLocks(a.writeLocks(a.lockComponent("rules")), func() error {
+		return writeAppFile(a, a.RulesFilePath(), func(buff []byte, rule Rule) ([]byte, error) {
+			return removeAppRuleBuff(a.ID(), buff, rule)
+		}, rule)
+	})
+}
+
+// UpdateRule replaces a rule of the Shorewall configuration managed by the App instance.
+// Only the line of the rule is rewritten, unless it moves to another section.
+func (a *App) UpdateRule(from, to Rule) error {
+	return execWithLocks(a.writeLocks(a.lockComponent("rules")), func() error {
+		return writeAppFile(a, a.RulesFilePath(), func(buff []byte, u entryUpdate[Rule]) ([]byte, error) {
+			return updateAppRuleBuff(a.ID(), buff, u)
+		}, entryUpdate[Rule]{old: from, new: to})
+	})
 }
 
 // Snats returns the list of SNATs managed by the App instance.
 func (a *App) Snats() ([]Snat, error) {
-	return execGetWithLock("snats", a.ID(), snatFile, getSnatsBuff)
+	entries, _, err := a.SnatsWithOptions(ReadOptions{})
+	return entries, err
+}
+
+// SnatsWithOptions is Snats reporting the lines of the block of the App instance
+// that could not be parsed: as warnings in lenient mode, as a ParseErrors
+// error if opts.Strict is set. The other options do not apply to App blocks.
+func (a *App) SnatsWithOptions(opts ReadOptions) ([]Snat, []ParseError, error) {
+	return execGetWithOptionsLock(a.lockComponent("snats"), a.ID(), a.SnatFilePath(), parseSnatsLines, opts.Strict)
 }
 
 // AddSnat adds a new SNAT to the Shorewall configuration managed by the App instance.
 func (a *App) AddSnat(snat Snat) error {
-	return execAddRemoveWithLock("snats", a.ID(), snatFile, addSnatBuff, snat)
+	return execAppWithLock(a, a.lockComponent("snats"), a.SnatFilePath(), addSnatBuff, snat)
 }
 
 // RemoveSnat removes a SNAT from the Shorewall configuration managed by the App instance.
 func (a *App) RemoveSnat(snat Snat) error {
-	return execAddRemoveWithLock("snats", a.ID(), snatFile, removeSnatBuff, snat)
+	return execAppWithLock(a, a.lockComponent("snats"), a.SnatFilePath(), removeSnatBuff, snat)
+}
+
+// UpdateSnat replaces a SNAT of the Shorewall configuration managed by the App instance.
+func (a *App) UpdateSnat(from, to Snat) error {
+	return execAppWithLock(a, a.lockComponent("snats"), a.SnatFilePath(), updateSnatBuff, entryUpdate[Snat]{old: from, new: to})
 }
 
 // Zones returns the list of zones managed by the App instance.
 func (a *App) Zones() ([]Zone, error) {
-	return execGetWithLock("zones", a.ID(), zonesFile, getZonesBuff)
+	entries, _, err := a.ZonesWithOptions(ReadOptions{})
+	return entries, err
+}
+
+// ZonesWithOptions is Zones reporting the lines of the block of the App instance
+// that could not be parsed: as warnings in lenient mode, as a ParseErrors
+// error if opts.Strict is set. The other options do not apply to App blocks.
+func (a *App) ZonesWithOptions(opts ReadOptions) ([]Zone, []ParseError, error) {
+	return execGetWithOptionsLock(a.lockComponent("zones"), a.ID(), a.ZonesFilePath(), parseZonesLines, opts.Strict)
 }
 
 // AddZone adds a new zone to the Shorewall configuration managed by the App instance.
+// The zones of the whole edited file are checked with ValidateZones, with
+// names of up to MaxZoneNameLength characters: a firewall zone is rejected
+// if the zones file already has one.
 func (a *App) AddZone(zone Zone) error {
-	return execAddRemoveWithLock("zones", a.ID(), zonesFile, addZoneBuff, zone)
+	return execZoneWithLock(a, addZoneBuff, zone)
 }
 
 // RemoveZone removes a zone from the Shorewall configuration managed by the App instance.
+// The zones are checked as in AddZone, so the firewall zone cannot be removed.
 func (a *App) RemoveZone(zoneName string) error {
-	return execAddRemoveWithLock("zones", a.ID(), zonesFile, removeZoneBuff, zoneName)
+	return execZoneWithLock(a, removeZoneBuff, zoneName)
+}
+
+// UpdateZone replaces the zone named name in the Shorewall configuration managed by the App instance.
+// The zones are checked as in AddZone, so the firewall zone cannot be
+// changed to another type.
+func (a *App) UpdateZone(name string, zone Zone) error {
+	return execZoneWithLock(a, updateZoneBuff, entryUpdate[Zone]{old: Zone{Name: name}, new: zone})
+}
+
+// execZoneWithLock is execAppWithLock for the zones file, validating the
+// zones of the whole file, as the firewall zone is usually declared outside
+// of the block of the App instance.
+func execZoneWithLock[S any](a *App, fn func([]byte, S) ([]byte, error), item S) error {
+	return execWithLocks(a.writeLocks(a.lockComponent("zones")), func() error {
+		return writeAppFile(a, a.ZonesFilePath(), validatedZonesEdit(func(buff []byte, item S) ([]byte, error) {
+			return appUpdateBuff(a.ID(), buff, fn, item)
+		}, a.MaxZoneNameLength()), item)
+	})
+}
+
+// ProxyArps returns the list of proxyarp entries managed by the App instance.
+func (a *App) ProxyArps() ([]ProxyArp, error) {
+	entries, _, err := a.ProxyArpsWithOptions(ReadOptions{})
+	return entries, err
+}
+
+// ProxyArpsWithOptions is ProxyArps reporting the lines of the block of the App instance
+// that could not be parsed: as warnings in lenient mode, as a ParseErrors
+// error if opts.Strict is set. The other options do not apply to App blocks.
+func (a *App) ProxyArpsWithOptions(opts ReadOptions) ([]ProxyArp, []ParseError, error) {
+	return execGetWithOptionsLock(a.lockComponent("proxyarp"), a.ID(), a.ProxyArpFilePath(), parseProxyArpsLines, opts.Strict)
+}
+
+// AddProxyArp adds a new proxyarp entry to the Shorewall configuration managed by the App instance.
+// The INTERFACE and EXTERNAL columns must reference interfaces declared in the interfaces file.
+func (a *App) AddProxyArp(proxyArp ProxyArp) error {
+	return execInterfacesCheckWithLock(a, a.lockComponent("proxyarp"), a.ProxyArpFilePath(), func() error {
+		return validateProxyArpInterfaces(a.InterfaceFilePath(), proxyArp)
+	}, addProxyArpBuff, proxyArp)
+}
+
+// RemoveProxyArp removes a proxyarp entry from the Shorewall configuration managed by the App instance.
+func (a *App) RemoveProxyArp(proxyArp ProxyArp) error {
+	return execAppWithLock(a, a.lockComponent("proxyarp"), a.ProxyArpFilePath(), removeProxyArpBuff, proxyArp)
+}
+
+// UpdateProxyArp replaces a proxyarp entry of the Shorewall configuration managed by the App instance.
+func (a *App) UpdateProxyArp(from, to ProxyArp) error {
+	return execInterfacesCheckWithLock(a, a.lockComponent("proxyarp"), a.ProxyArpFilePath(), func() error {
+		return validateProxyArpInterfaces(a.InterfaceFilePath(), to)
+	}, updateProxyArpBuff, entryUpdate[ProxyArp]{old: from, new: to})
+}
+
+// ProxyNdps returns the list of proxyndp entries managed by the App instance.
+func (a *App) ProxyNdps() ([]ProxyNdp, error) {
+	entries, _, err := a.ProxyNdpsWithOptions(ReadOptions{})
+	return entries, err
+}
+
+// ProxyNdpsWithOptions is ProxyNdps reporting the lines of the block of the App instance
+// that could not be parsed: as warnings in lenient mode, as a ParseErrors
+// error if opts.Strict is set. The other options do not apply to App blocks.
+func (a *App) ProxyNdpsWithOptions(opts ReadOptions) ([]ProxyNdp, []ParseError, error) {
+	return execGetWithOptionsLock(a.lockComponent("proxyndp"), a.ID(), a.ProxyNdpFilePath(), parseProxyNdpsLines, opts.Strict)
+}
+
+// AddProxyNdp adds a new proxyndp entry to the Shorewall configuration managed by the App instance.
+// The INTERFACE and EXTERNAL columns must reference interfaces declared in the interfaces file.
+func (a *App) AddProxyNdp(proxyNdp ProxyNdp) error {
+	return execInterfacesCheckWithLock(a, a.lockComponent("proxyndp"), a.ProxyNdpFilePath(), func() error {
+		return validateProxyNdpInterfaces(a.InterfaceFilePath(), proxyNdp)
+	}, addProxyNdpBuff, proxyNdp)
+}
+
+// RemoveProxyNdp removes a proxyndp entry from the Shorewall configuration managed by the App instance.
+func (a *App) RemoveProxyNdp(proxyNdp ProxyNdp) error {
+	return execAppWithLock(a, a.lockComponent("proxyndp"), a.ProxyNdpFilePath(), removeProxyNdpBuff, proxyNdp)
+}
+
+// UpdateProxyNdp replaces a proxyndp entry of the Shorewall configuration managed by the App instance.
+func (a *App) UpdateProxyNdp(from, to ProxyNdp) error {
+	return execInterfacesCheckWithLock(a, a.lockComponent("proxyndp"), a.ProxyNdpFilePath(), func() error {
+		return validateProxyNdpInterfaces(a.InterfaceFilePath(), to)
+	}, updateProxyNdpBuff, entryUpdate[ProxyNdp]{old: from, new: to})
+}
+
+// Conntracks returns the list of conntrack entries managed by the App instance.
+func (a *App) Conntracks() ([]Conntrack, error) {
+	entries, _, err := a.ConntracksWithOptions(ReadOptions{})
+	return entries, err
+}
+
+// ConntracksWithOptions is Conntracks reporting the lines of the block of the App instance
+// that could not be parsed: as warnings in lenient mode, as a ParseErrors
+// error if opts.Strict is set. The other options do not apply to App blocks.
+func (a *App) ConntracksWithOptions(opts ReadOptions) ([]Conntrack, []ParseError, error) {
+	return execGetWithOptionsLock(a.lockComponent("conntrack"), a.ID(), a.ConntrackFilePath(), parseConntracksLines, opts.Strict)
+}
+
+// AddConntrack adds a new conntrack entry to the Shorewall configuration managed by the App instance.
+// The block of the App instance is written in format 3.
+func (a *App) AddConntrack(conntrack Conntrack) error {
+	return execFormatWithLock(a, a.lockComponent("conntrack"), a.ConntrackFilePath(), 3, addConntrackBuff, conntrack)
+}
+
+// RemoveConntrack removes a conntrack entry from the Shorewall configuration managed by the App instance.
+func (a *App) RemoveConntrack(conntrack Conntrack) error {
+	return execFormatWithLock(a, a.lockComponent("conntrack"), a.ConntrackFilePath(), 3, removeConntrackBuff, conntrack)
+}
+
+// UpdateConntrack replaces a conntrack entry of the Shorewall configuration managed by the App instance.
+func (a *App) UpdateConntrack(from, to Conntrack) error {
+	return execFormatWithLock(a, a.lockComponent("conntrack"), a.ConntrackFilePath(), 3, updateConntrackBuff, entryUpdate[Conntrack]{old: from, new: to})
+}
+
+// Config returns the parsed shorewall.conf file. The file is global to the
+// system and is not scoped to the App instance.
+func (a *App) Config() (*Config, error) {
+	var c *Config
+	err := execWithLock(a.lockComponent("config"), func() error {
+		buff, err := os.ReadFile(a.ConfigFilePath())
+		if err != nil {
+			return err
+		}
+		c = ParseConfig(buff)
+		return nil
+	})
+	return c, err
+}
+
+// SetConfigOption sets a single key of the shorewall.conf file, leaving the
+// rest of the file untouched.
+func (a *App) SetConfigOption(key, value string) error {
+	return execWithLock(a.lockComponent("config"), func() error {
+		return readWriteFile(a.ConfigFilePath(), setConfigOptionBuff, configOption{Key: key, Value: value})
+	})
+}
+
+// UpdateConfig reads the shorewall.conf file, calls fn on it and writes the
+// result back while holding the configuration lock. It allows the typed
+// setters of Config to be used atomically.
+func (a *App) UpdateConfig(fn func(*Config) error) error {
+	return execWithLock(a.lockComponent("config"), func() error {
+		return readWriteFile(a.ConfigFilePath(), updateConfigBuff, fn)
+	})
+}
+
+// Macros returns the names of the macros defined in the Shorewall configuration directory.
+func (a *App) Macros() ([]string, error) {
+	var names []string
+	err := execWithLock(a.lockComponent("macros"), func() (err error) {
+		names, err = listMacros(a.basePath)
+		return
+	})
+	return names, err
+}
+
+// Macro returns the body of the macro called name.
+func (a *App) Macro(name string) ([]Rule, error) {
+	var body []Rule
+	err := execWithLock(a.lockComponent("macros"), func() (err error) {
+		body, err = readMacro([]string{a.basePath}, name)
+		return
+	})
+	return body, err
+}
+
+// CreateMacro creates a new macro file managed by the App instance.
+func (a *App) CreateMacro(name string, body []Rule) error {
+	return execWithLock(a.lockComponent("macros"), func() error {
+		return createMacro(a.basePath, name, wrapBuffWithAppIdentifier(formatMacroBody(body), a.ID()))
+	})
+}
+
+// DeleteMacro deletes a macro file. Only macros created by the App instance can be deleted.
+func (a *App) DeleteMacro(name string) error {
+	return execWithLock(a.lockComponent("macros"), func() error {
+		return deleteMacro(a.basePath, name, isMacroBodyOwnedBy(a.ID()))
+	})
+}
+
+// ExpandMacroRule expands a rule invoking a macro, such as "OurMonitoring(ACCEPT)",
+// into the rules of the macro body.
+func (a *App) ExpandMacroRule(rule Rule) ([]Rule, error) {
+	var rules []Rule
+	err := execWithLock(a.lockComponent("macros"), func() (err error) {
+		rules, err = expandMacroRule([]string{a.basePath, shorewallSharePath}, rule, 0)
+		return
+	})
+	return rules, err
+}
+
+// Actions returns the list of actions declared by the App instance.
+func (a *App) Actions() ([]Action, error) {
+	entries, _, err := a.ActionsWithOptions(ReadOptions{})
+	return entries, err
+}
+
+// ActionsWithOptions is Actions reporting the lines of the block of the App instance
+// that could not be parsed: as warnings in lenient mode, as a ParseErrors
+// error if opts.Strict is set. The other options do not apply to App blocks.
+func (a *App) ActionsWithOptions(opts ReadOptions) ([]Action, []ParseError, error) {
+	return execGetWithOptionsLock(a.lockComponent("actions"), a.ID(), a.ActionsFilePath(), parseActionsLines, opts.Strict)
+}
+
+// AddAction declares a new action in the Shorewall configuration managed by the App instance.
+func (a *App) AddAction(action Action) error {
+	return execAppWithLock(a, a.lockComponent("actions"), a.ActionsFilePath(), addActionBuff, action)
+}
+
+// RemoveAction removes an action declaration from the Shorewall configuration managed by the App instance.
+func (a *App) RemoveAction(name string) error {
+	return execAppWithLock(a, a.lockComponent("actions"), a.ActionsFilePath(), removeActionBuff, name)
+}
+
+// ActionBody returns the body of the action called name.
+func (a *App) ActionBody(name string) ([]Rule, error) {
+	var body []Rule
+	err := execWithLock(a.lockComponent("actions"), func() (err error) {
+		body, err = readActionBody(a.basePath, name)
+		return
+	})
+	return body, err
+}
+
+// WriteActionBody creates or replaces the body of the action called name.
+// Existing bodies can only be replaced if they were written by the App instance.
+func (a *App) WriteActionBody(name string, body []Rule) error {
+	return execWithLock(a.lockComponent("actions"), func() error {
+		content := wrapBuffWithAppIdentifier(formatMacroBody(body), a.ID())
+		return writeActionBody(a.basePath, name, content, isMacroBodyOwnedBy(a.ID()))
+	})
+}
+
+// DeleteActionBody deletes the body of the action called name. Only bodies
+// written by the App instance can be deleted.
+func (a *App) DeleteActionBody(name string) error {
+	return execWithLock(a.lockComponent("actions"), func() error {
+		return deleteActionBody(a.basePath, name, isMacroBodyOwnedBy(a.ID()))
+	})
+}
+
+// ValidateRuleActions checks that every rule references either a built-in
+// action, a macro, or an action declared in the actions file.
+func (a *App) ValidateRuleActions(rules []Rule) error {
+	return execWithLock(a.lockComponent("actions"), func() error {
+		return validateRuleActionsInDirs([]string{a.basePath, shorewallSharePath}, rules)
+	})
+}
+
+// lockComponent returns the lock name of component. IPv6 App instances use
+// distinct locks as they manage a distinct configuration tree.
+func (a *App) lockComponent(component string) string {
+	if a.family == IPv6 {
+		return component + "6"
+	}
+	return component
 }
 
 func execWithLock(component string, fn func() error) error {
@@ -201,6 +668,28 @@ func execGetWithLock[S any](component, id, path string, fn func([]byte) ([]S, er
 	return appReadFile(id, path, fn)
 }
 
+// execGetWithOptionsLock reads the block of the application id in the file
+// at p with parse, under the lock of component. The lines that could not be
+// parsed are returned as warnings, or as a ParseErrors error if strict is set.
+func execGetWithOptionsLock[S any](component, id, p string, parse func([]logicalLine, *parseIssues) []S, strict bool) ([]S, []ParseError, error) {
+	var entries []S
+	var warnings []ParseError
+	err := execWithLock(component, func() error {
+		buff, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		is, ie, _, err := extractApplicationSubsetBufferIndexes(id, buff)
+		if err != nil {
+			return err
+		}
+		issues := &parseIssues{file: p}
+		entries, warnings, err = result(parse(blockLines(buff, is, ie), issues), issues, strict)
+		return err
+	})
+	return entries, warnings, err
+}
+
 func execAddRemoveWithLock[S any](component, id, path string, fn func([]byte, S) ([]byte, error), item S) error {
 	flock, err := takeLock(component)
 	if err != nil {
@@ -214,6 +703,93 @@ func execAddRemoveWithLock[S any](component, id, path string, fn func([]byte, S)
 	return appReadWriteFile(id, path, fn, item)
 }
 
+// execInterfacesCheckWithLock is execAppWithLock running check, which
+// validates the item against the interfaces file, before the write. The
+// interfaces lock is held for the whole operation, so that the interfaces
+// checked cannot be removed before the file is written.
+func execInterfacesCheckWithLock[S any](a *App, component, path string, check func() error, fn func([]byte, S) ([]byte, error), item S) error {
+	components := a.writeLocks(component)
+	if interfaces := a.lockComponent("interfaces"); !slices.Contains(components, interfaces) {
+		components = append([]string{interfaces}, components...)
+	}
+	return execWithLocks(components, func() error {
+		if err := check(); err != nil {
+			return err
+		}
+		return writeAppFile(a, path, func(buff []byte, item S) ([]byte, error) {
+			return appUpdateBuff(a.ID(), buff, fn, item)
+		}, item)
+	})
+}
+
+// execAppWithLock is execAddRemoveWithLock running the pre-commit hook of
+// the App instance.
+func execAppWithLock[S any](a *App, component, path string, fn func([]byte, S) ([]byte, error), item S) error {
+	return execWithLocks(a.writeLocks(component), func() error {
+		return writeAppFile(a, path, func(buff []byte, item S) ([]byte, error) {
+			return appUpdateBuff(a.ID(), buff, fn, item)
+		}, item)
+	})
+}
+
+// execFormatWithLock is execAppWithLock for files whose column layout is
+// selected with ?FORMAT, see appFormatUpdateBuff.
+func execFormatWithLock[S any](a *App, component, path string, format int, fn func([]byte, S) ([]byte, error), item S) error {
+	return execWithLocks(a.writeLocks(component), func() error {
+		return writeAppFile(a, path, func(buff []byte, item S) ([]byte, error) {
+			return appFormatUpdateBuff(a.ID(), buff, format, fn, item)
+		}, item)
+	})
+}
+
+// referenceComponents are the components whose files are read by
+// App.ReferenceCheck, in the order their locks are taken.
+var referenceComponents = []string{"zones", "interfaces", "hosts", "policies", "rules", "snats"}
+
+// writeLocks returns the components to lock, in order, to write the file of
+// component. Without a pre-commit hook only component is locked. With one,
+// the locks of every reference component are taken too, as the hook may read
+// their files: App.ReferenceCheck loads the whole configuration. Locks are
+// always taken in the order of referenceComponents, followed by the other
+// components, which keeps concurrent mutations from deadlocking.
+func (a *App) writeLocks(component string) []string {
+	if a.preCommit == nil {
+		return []string{component}
+	}
+	components := a.referenceLocks()
+	if !slices.Contains(components, component) {
+		components = append(components, component)
+	}
+	return components
+}
+
+// referenceLocks returns the lock components of referenceComponents for the
+// address family of the App instance.
+func (a *App) referenceLocks() []string {
+	var components []string
+	for _, c := range referenceComponents {
+		components = append(components, a.lockComponent(c))
+	}
+	return components
+}
+
+// writeAppFile is readWriteFile running the pre-commit hook of the App
+// instance on the new content of the file.
+func writeAppFile[S any](a *App, path string, fn func([]byte, S) ([]byte, error), item S) error {
+	return readWriteFile(path, func(buff []byte, item S) ([]byte, error) {
+		buff, err := fn(buff, item)
+		if err != nil {
+			return nil, err
+		}
+		if a.preCommit != nil {
+			if err := a.preCommit(map[string][]byte{path: buff}); err != nil {
+				return nil, err
+			}
+		}
+		return buff, nil
+	}, item)
+}
+
 func takeLock(component string) (*flock.Flock, error) {
 	err := os.Mkdir(lockDirPath, 0o755)
 	if err != nil && !os.IsExist(err) {
@@ -279,6 +855,34 @@ func wrapBuffWithAppIdentifier(buff []byte, id string) []byte {
 	return b
 }
 
+// appBlockOwners returns the ID of the App owning each line of buff,
+// indexed by line number starting at 1. The markers and the lines outside of
+// any App block have an empty ID. The section blocks of the rules file are
+// owned by the App of the block.
+func appBlockOwners(buff []byte) []string {
+	startMarker := bytes.TrimSuffix(commentIdentifierLineStart(""), []byte(" ###\n"))
+	endMarker := bytes.TrimSuffix(commentIdentifierEnd(""), []byte(" ###\n"))
+
+	owners := []string{""}
+	var current string
+	for line := range bytes.SplitSeq(buff, []byte("\n")) {
+		switch {
+		case bytes.Contains(line, startMarker):
+			_, id, _ := bytes.Cut(line, startMarker)
+			id, _, _ = bytes.Cut(id, []byte(" ###"))
+			id, _, _ = bytes.Cut(id, []byte(" section: "))
+			current = string(id)
+			owners = append(owners, "")
+		case bytes.Contains(line, endMarker):
+			current = ""
+			owners = append(owners, "")
+		default:
+			owners = append(owners, current)
+		}
+	}
+	return owners
+}
+
 func appReadFile[S any](id, path string, fn func([]byte) ([]S, error)) ([]S, error) {
 	buff, err := os.ReadFile(path)
 	if err != nil {
@@ -292,21 +896,32 @@ func appReadFile[S any](id, path string, fn func([]byte) ([]S, error)) ([]S, err
 	return fn(buff[is:ie])
 }
 
-func appReadWriteFile[S any](id, path string, fn func([]byte, S) ([]byte, error), i S) error {
-	file, err := os.OpenFile(path, os.O_RDWR, 0600)
-	if err != nil {
-		return err
+// blockLines returns the logical lines of buff starting between the offsets
+// start and end. Unlike the lines tokenized from buff[start:end], they are
+// numbered from the start of buff.
+func blockLines(buff []byte, start, end uint) []logicalLine {
+	var lines []logicalLine
+	for _, l := range tokenize(buff) {
+		if l.start >= int(start) && l.start < int(end) {
+			lines = append(lines, l)
+		}
 	}
-	defer file.Close()
+	return lines
+}
 
-	buff, err := io.ReadAll(file)
-	if err != nil {
-		return err
-	}
+func appReadWriteFile[S any](id, path string, fn func([]byte, S) ([]byte, error), i S) error {
+	return readWriteFile(path, func(buff []byte, i S) ([]byte, error) {
+		return appUpdateBuff(id, buff, fn, i)
+	}, i)
+}
 
+// appUpdateBuff applies fn to the block of buff managed by the application id
+// and returns the whole updated buffer. If the application has no block yet,
+// a new one is appended to buff.
+func appUpdateBuff[S any](id string, buff []byte, fn func([]byte, S) ([]byte, error), i S) ([]byte, error) {
 	is, ie, found, err := extractApplicationSubsetBufferIndexes(id, buff)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	tmpBuff := make([]byte, ie-is)
@@ -314,23 +929,53 @@ func appReadWriteFile[S any](id, path string, fn func([]byte, S) ([]byte, error)
 
 	buff2, err := fn(tmpBuff, i)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if ie == is && !found {
 		buff2 = wrapBuffWithAppIdentifier(buff2, id)
+		if is > 0 && buff[is-1] != '\n' {
+			buff2 = append([]byte{'\n'}, buff2...)
+		}
 	}
 
-	newBuff := make([]byte, 0, len(buff2)+len(buff[ie:]))
+	newBuff := make([]byte, 0, int(is)+len(buff2)+len(buff[ie:]))
+	newBuff = append(newBuff, buff[:is]...)
 	newBuff = append(newBuff, buff2...)
 	newBuff = append(newBuff, buff[ie:]...)
+	return newBuff, nil
+}
 
-	n, err := file.WriteAt(newBuff, int64(is))
+// appFormatUpdateBuff is appUpdateBuff for files whose column layout is
+// selected with ?FORMAT. The block of the application starts with a ?FORMAT
+// directive selecting format and, if it differs, ends with one restoring the
+// format in effect before the block for the lines following it. A block left
+// without entries carries no directives.
+func appFormatUpdateBuff[S any](id string, buff []byte, format int, fn func([]byte, S) ([]byte, error), i S) ([]byte, error) {
+	is, _, _, err := extractApplicationSubsetBufferIndexes(id, buff)
 	if err != nil {
-		return err
-	}
-	if n < len(buff2) {
-		return fmt.Errorf("failed to write complete data to zones file")
+		return nil, err
 	}
-	return file.Truncate(int64(int(is) + n))
+	prev := max(formatAt(buff[:is]), 1)
+	header := formatDirective(format)
+
+	return appUpdateBuff(id, buff, func(block []byte, i S) ([]byte, error) {
+		if lines := tokenize(block); len(lines) > 1 && lines[len(lines)-1].directiveName() == "FORMAT" {
+			block = block[:lines[len(lines)-1].start]
+		}
+		if !bytes.HasPrefix(block, header) {
+			block = append(header, block...)
+		}
+		block, err := fn(block, i)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.ContainsFunc(tokenize(block), func(l logicalLine) bool { return !l.directive }) {
+			return bytes.TrimPrefix(block, header), nil
+		}
+		if prev != format {
+			block = append(block, formatDirective(prev)...)
+		}
+		return block, nil
+	}, i)
 }
@@ -2,6 +2,7 @@ package goshorewall
 
 import (
 	"os"
+	"path"
 	"testing"
 
 	"github.com/google/uuid"
@@ -34,3 +35,120 @@ func TestSingleAppReadWriteFile(t *testing.T) {
 	assert.Equal(t, 1, len(interfaces), "Expected one interface")
 	assert.Equal(t, iface, interfaces[0], "Expected interface to match")
 }
+
+func TestAppFamily(t *testing.T) {
+	app, err := NewApp6()
+	assert.NoError(t, err, "Creating app")
+	assert.Equal(t, IPv6, app.Family())
+	assert.Equal(t, "/etc/shorewall6", app.BasePath())
+	assert.Equal(t, "/etc/shorewall6/shorewall6.conf", app.ConfigFilePath())
+	assert.Equal(t, "rules6", app.lockComponent("rules"))
+
+	app, err = AppFromID(app.ID())
+	assert.NoError(t, err, "Creating app from ID")
+	assert.Equal(t, IPv4, app.Family())
+	assert.Equal(t, "/etc/shorewall/rules", app.RulesFilePath())
+	assert.Equal(t, "rules", app.lockComponent("rules"))
+}
+
+func TestDualStackApp(t *testing.T) {
+	lockDirPath = "/tmp/.goshorewall"
+
+	dir4, dir6 := t.TempDir(), t.TempDir()
+	for _, dir := range []string{dir4, dir6} {
+		f, err := os.Create(dir + "/rules")
+		assert.NoError(t, err, "Creating rules file")
+		assert.NoError(t, f.Close(), "Closing file")
+	}
+
+	v4, err := NewAppWithFamily(IPv4, dir4)
+	assert.NoError(t, err, "Creating app")
+	v6, err := AppFromIDWithFamily(v4.ID(), IPv6, dir6)
+	assert.NoError(t, err, "Creating app")
+
+	_, err = NewDualStackApp(v6, v4)
+	assert.ErrorIs(t, err, ErrDualStackFamily)
+
+	d, err := NewDualStackApp(v4, v6)
+	assert.NoError(t, err, "Creating dual-stack app")
+
+	rule := Rule{Action: "ACCEPT", Source: "net", Destination: "fw", Protocol: "tcp", Dport: "22"}
+	assert.NoError(t, d.AddRule(rule), "Adding rule")
+	assert.ErrorIs(t, d.AddRule(Rule{Action: "ACCEPT", Source: "net:1.2.3.4", Destination: "fw"}), ErrRuleNotFamilyAgnostic)
+
+	for _, app := range []*App{v4, v6} {
+		rules, err := app.Rules()
+		assert.NoError(t, err, "Getting rules")
+		assert.Equal(t, []Rule{rule}, rules)
+	}
+
+	// The IPv6 rule already exists, the IPv4 one must be rolled back
+	assert.NoError(t, v4.RemoveRule(rule), "Removing rule")
+	assert.ErrorIs(t, d.AddRule(rule), ErrRuleAlreadyExists)
+	rules, err := v4.Rules()
+	assert.NoError(t, err, "Getting rules")
+	assert.Empty(t, rules)
+
+	assert.NoError(t, v4.AddRule(rule), "Adding rule")
+	assert.NoError(t, d.RemoveRule(rule), "Removing rule")
+}
+
+func TestAppFormatUpdateBuff(t *testing.T) {
+	id := uuid.NewString()
+	buff := []byte("net\teth0\tdetect\tdhcp\n")
+
+	buff, err := appFormatUpdateBuff(id, buff, 2, addInterfaceBuff, Interface{Zone: "loc", Name: "eth1", Options: "routefilter"})
+	assert.NoError(t, err, "adding interface")
+	buff, err = appFormatUpdateBuff(id, buff, 2, addInterfaceBuff, Interface{Zone: "dmz", Name: "eth2", Options: "nosmurfs"})
+	assert.NoError(t, err, "adding interface")
+	assert.Equal(t, "net\teth0\tdetect\tdhcp\n"+
+		string(commentIdentifierLineStart(id))+
+		"?FORMAT 2\nloc\teth1\troutefilter\ndmz\teth2\tnosmurfs\n?FORMAT 1\n"+
+		string(commentIdentifierEnd(id)), string(buff))
+
+	interfaces := parseInterfaces(append(buff, "vpn\twg0\t-\toptional\n"...))
+	assert.Len(t, interfaces, 4)
+	assert.Equal(t, "routefilter", interfaces[1].Options)
+	assert.Equal(t, "optional", interfaces[3].Options)
+
+	buff, err = appFormatUpdateBuff(id, buff, 2, removeInterfaceByZoneBuff, "loc")
+	assert.NoError(t, err, "removing interface")
+	assert.Equal(t, "net\teth0\tdetect\tdhcp\n"+
+		string(commentIdentifierLineStart(id))+
+		"?FORMAT 2\ndmz\teth2\tnosmurfs\n?FORMAT 1\n"+
+		string(commentIdentifierEnd(id)), string(buff))
+
+	buff, err = appFormatUpdateBuff(id, buff, 2, removeInterfaceByZoneBuff, "dmz")
+	assert.NoError(t, err, "removing the last interface")
+	assert.Equal(t, "net\teth0\tdetect\tdhcp\n"+
+		string(commentIdentifierLineStart(id))+
+		string(commentIdentifierEnd(id)), string(buff))
+
+	buff, err = appFormatUpdateBuff(id, []byte("?FORMAT 2\n"), 2, addInterfaceBuff, Interface{Zone: "loc", Name: "eth1"})
+	assert.NoError(t, err, "adding interface")
+	assert.Equal(t, "?FORMAT 2\n"+
+		string(commentIdentifierLineStart(id))+
+		"?FORMAT 2\nloc\teth1\t\n"+
+		string(commentIdentifierEnd(id)), string(buff))
+}
+
+func TestApp_ZonesValidation(t *testing.T) {
+	lockDirPath = "/tmp/.goshorewall"
+
+	dir := t.TempDir()
+	writeFile(t, path.Join(dir, zonesFile), "net\tipv4\n")
+
+	app, err := NewAppWithBasePath(dir)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, app.AddZone(Zone{Name: "loc", Type: "ipv4"}), ErrZoneFirewallNotFound)
+	assert.NoError(t, app.AddZone(Zone{Name: "fw", Type: "firewall"}))
+	assert.ErrorIs(t, app.UpdateZone("fw", Zone{Name: "fw", Type: "ipv4"}), ErrZoneFirewallNotFound)
+	assert.ErrorIs(t, app.RemoveZone("fw"), ErrZoneFirewallNotFound)
+	_, err = app.RemoveZoneCascade("fw")
+	assert.ErrorIs(t, err, ErrZoneFirewallNotFound)
+
+	assert.ErrorIs(t, app.AddZone(Zone{Name: "guests", Type: "ipv4"}), ErrZoneNameTooLong)
+	app.SetMaxZoneNameLength(8)
+	assert.NoError(t, app.AddZone(Zone{Name: "guests", Type: "ipv4"}))
+	assert.NoError(t, app.RemoveZone("guests"))
+}
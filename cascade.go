@@ -0,0 +1,246 @@
+package goshorewall
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ZoneCascade lists the entries removed, or that would be removed, with a
+// zone by App.RemoveZoneCascade.
+type ZoneCascade struct {
+	Zone       Zone
+	Interfaces []Interface
+	Hosts      []Host
+	Policies   []Policy
+	Rules      []Rule
+	// Snats are the entries whose DEST is one of the removed interfaces.
+	Snats []Snat
+}
+
+// fileEdit is the new content of a file written by a multi-file operation.
+type fileEdit struct {
+	path     string
+	old, new []byte
+}
+
+// RemoveZoneCascade removes the zone name from the block of the App instance
+// in the zones file, together with the entries of the blocks of the App
+// instance referencing it: its interfaces and hosts, the policies and rules
+// using it as SOURCE or DEST, and the snat entries of its interfaces. The
+// zones left are checked as in AddZone. The files are only written once every
+// change has been computed and the pre-commit hook has accepted all of them
+// together; each file is replaced as a whole, see commitEdits.
+func (a *App) RemoveZoneCascade(name string) (ZoneCascade, error) {
+	return a.removeZoneCascade(name, false)
+}
+
+// RemoveZoneCascadeDryRun returns what RemoveZoneCascade would remove, without
+// writing any file.
+func (a *App) RemoveZoneCascadeDryRun(name string) (ZoneCascade, error) {
+	return a.removeZoneCascade(name, true)
+}
+
+func (a *App) removeZoneCascade(name string, dryRun bool) (ZoneCascade, error) {
+	var c ZoneCascade
+	err := execWithLocks(a.referenceLocks(), func() error {
+		var edits []fileEdit
+		var err error
+		c, edits, err = a.zoneCascadeEdits(name)
+		if err != nil || dryRun {
+			return err
+		}
+		return a.commitEdits(edits)
+	})
+	if err != nil {
+		return ZoneCascade{}, err
+	}
+	return c, nil
+}
+
+// zoneCascadeEdits computes the edits of RemoveZoneCascade, ordered so that
+// the entries referencing the zone, or its interfaces, are removed first.
+func (a *App) zoneCascadeEdits(name string) (ZoneCascade, []fileEdit, error) {
+	var c ZoneCascade
+	references := func(spec string) bool {
+		return slices.Contains(zoneReferences(spec), name)
+	}
+
+	zones, err := a.editAppBlock(a.ZonesFilePath(), func(b []byte) []byte {
+		b, removed := removeAllBuff(b, parseZonesLines, func(z Zone) bool {
+			return zoneName(z) == name
+		})
+		if len(removed) > 0 {
+			c.Zone = removed[0]
+		}
+		return b
+	})
+	if err != nil {
+		return c, nil, err
+	}
+	if c.Zone.Name == "" {
+		return c, nil, ErrZoneNotFound
+	}
+	if err := validateZones(parseZones(zones.new), a.MaxZoneNameLength()); err != nil {
+		return c, nil, err
+	}
+
+	interfaces, err := a.editAppBlock(a.InterfaceFilePath(), func(b []byte) []byte {
+		b, c.Interfaces = removeAllBuff(b, parseInterfacesLines, func(i Interface) bool {
+			return i.Zone == name
+		})
+		return b
+	})
+	if err != nil {
+		return c, nil, err
+	}
+	hosts, err := a.editAppBlock(a.HostsFilePath(), func(b []byte) []byte {
+		b, c.Hosts = removeAllBuff(b, parseHostsLines, func(h Host) bool {
+			return h.Zone == name
+		})
+		return b
+	})
+	if err != nil {
+		return c, nil, err
+	}
+	policies, err := a.editAppBlock(a.PolicyFilePath(), func(b []byte) []byte {
+		b, c.Policies = removeAllBuff(b, parsePoliciesLines, func(p Policy) bool {
+			return references(p.Source) || references(p.Destination)
+		})
+		return b
+	})
+	if err != nil {
+		return c, nil, err
+	}
+	snats, err := a.editAppBlock(a.SnatFilePath(), func(b []byte) []byte {
+		b, c.Snats = removeAllBuff(b, parseSnatsLines, func(s Snat) bool {
+			return slices.ContainsFunc(snatInterfaces(s.Destination), func(name string) bool {
+				return slices.ContainsFunc(c.Interfaces, func(i Interface) bool { return i.Name == name })
+			})
+		})
+		return b
+	})
+	if err != nil {
+		return c, nil, err
+	}
+	rules, err := editFile(a.RulesFilePath(), func(buff []byte) ([]byte, error) {
+		rules, err := getAppRulesBuff(a.ID(), buff)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rules {
+			// The DEST column of REDIRECT rules is a port of the firewall
+			if references(r.Source) || actionBaseName(r.Action) != "REDIRECT" && references(r.Destination) {
+				if buff, err = removeAppRuleBuff(a.ID(), buff, r); err != nil {
+					return nil, err
+				}
+				c.Rules = append(c.Rules, r)
+			}
+		}
+		return buff, nil
+	})
+	if err != nil {
+		return c, nil, err
+	}
+
+	var edits []fileEdit
+	for _, e := range []*fileEdit{rules, snats, policies, hosts, interfaces, zones} {
+		if e != nil {
+			edits = append(edits, *e)
+		}
+	}
+	return c, edits, nil
+}
+
+// editAppBlock is editFile applying fn to the block of the App instance. Files
+// without a block of the App instance are left untouched.
+func (a *App) editAppBlock(p string, fn func([]byte) []byte) (*fileEdit, error) {
+	return editFile(p, func(buff []byte) ([]byte, error) {
+		_, _, found, err := extractApplicationSubsetBufferIndexes(a.ID(), buff)
+		if err != nil || !found {
+			return buff, err
+		}
+		return appUpdateBuff(a.ID(), buff, func(b []byte, _ struct{}) ([]byte, error) {
+			return fn(b), nil
+		}, struct{}{})
+	})
+}
+
+// editFile returns the edit applying fn to the file at p, or nil if the file
+// does not exist or is left unchanged.
+func editFile(p string, fn func([]byte) ([]byte, error)) (*fileEdit, error) {
+	buff, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	updated, err := fn(buff)
+	if err != nil || bytes.Equal(updated, buff) {
+		return nil, err
+	}
+	return &fileEdit{path: p, old: buff, new: updated}, nil
+}
+
+// zoneName returns the name of the zone without the parents of a nested
+// zone.
+func zoneName(z Zone) string {
+	name, _, _ := strings.Cut(z.Name, ":")
+	return name
+}
+
+// commitEdits writes edits as a whole. The pre-commit hook of the App
+// instance is run once, with the new content of every file. The new contents
+// are then written to temporary files, which only replace the files once all
+// of them are written, so that no file is ever left partially written. If a
+// file cannot be replaced, the files already replaced are restored.
+func (a *App) commitEdits(edits []fileEdit) error {
+	if a.preCommit != nil {
+		files := make(map[string][]byte, len(edits))
+		for _, e := range edits {
+			files[e.path] = e.new
+		}
+		if err := a.preCommit(files); err != nil {
+			return err
+		}
+	}
+
+	var temps []string
+	defer func() {
+		for _, t := range temps {
+			os.Remove(t)
+		}
+	}()
+	for _, e := range edits {
+		t, err := writeTempFile(e.path, e.new)
+		if err != nil {
+			return err
+		}
+		temps = append(temps, t)
+	}
+	for i, e := range edits {
+		if err := os.Rename(temps[i], e.path); err != nil {
+			errs := []error{err}
+			for _, w := range slices.Backward(edits[:i]) {
+				errs = append(errs, replaceFile(w.path, w.old))
+			}
+			temps = temps[i:]
+			return errors.Join(errs...)
+		}
+	}
+	temps = nil
+	return nil
+}
+
+// execWithLocks is execWithLock taking the locks of several components, in
+// order.
+func execWithLocks(components []string, fn func() error) error {
+	if len(components) == 0 {
+		return fn()
+	}
+	return execWithLock(components[0], func() error {
+		return execWithLocks(components[1:], fn)
+	})
+}
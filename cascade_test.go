@@ -0,0 +1,147 @@
+package goshorewall
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoveZoneCascade(t *testing.T) {
+	lockDirPath = "/tmp/.goshorewall"
+
+	dir := t.TempDir()
+	writeFile(t, path.Join(dir, zonesFile), "fw\tfirewall\nnet\tipv4\n")
+	writeFile(t, path.Join(dir, interfacesFile), "?FORMAT 2\nnet\teth0\tdhcp\n")
+	writeFile(t, path.Join(dir, policyFile), "")
+	writeFile(t, path.Join(dir, rulesFile), "?SECTION NEW\n")
+	writeFile(t, path.Join(dir, snatFile), "")
+
+	app, err := NewAppWithBasePath(dir)
+	assert.NoError(t, err)
+	app.SetPreCommitHook(app.ReferenceCheck())
+
+	assert.NoError(t, app.AddZone(Zone{Name: "loc", Type: "ipv4"}))
+	assert.NoError(t, app.AddZone(Zone{Name: "dmz", Type: "ipv4"}))
+	assert.NoError(t, app.AddInterface(Interface{Zone: "loc", Name: "eth1"}))
+	assert.NoError(t, app.AddInterface(Interface{Zone: "loc", Name: "eth2"}))
+	assert.NoError(t, app.AddInterface(Interface{Zone: "dmz", Name: "eth3"}))
+	assert.NoError(t, app.AddPolicy(Policy{Source: "loc", Destination: "net", Policy: "ACCEPT"}))
+	assert.NoError(t, app.AddPolicy(Policy{Source: "dmz", Destination: "net", Policy: "ACCEPT"}))
+	assert.NoError(t, app.AddRule(Rule{Action: "ACCEPT", Source: "loc", Destination: "$FW", Protocol: "tcp", Dport: "22"}))
+	assert.NoError(t, app.AddRule(Rule{Action: "DNAT", Source: "net", Destination: "loc:192.168.1.5", Protocol: "tcp", Dport: "80", Section: "NEW"}))
+	assert.NoError(t, app.AddRule(Rule{Action: "ACCEPT", Source: "dmz", Destination: "net"}))
+	assert.NoError(t, app.AddSnat(Snat{Action: "MASQUERADE", Source: "10.0.0.0/8", Destination: "eth2"}))
+	assert.NoError(t, app.AddSnat(Snat{Action: "MASQUERADE", Source: "10.0.0.0/8", Destination: "eth0"}))
+
+	before := map[string][]byte{}
+	for _, f := range []string{zonesFile, interfacesFile, policyFile, rulesFile, snatFile} {
+		before[f], err = os.ReadFile(path.Join(dir, f))
+		assert.NoError(t, err)
+	}
+
+	c, err := app.RemoveZoneCascadeDryRun("loc")
+	assert.NoError(t, err)
+	assert.Equal(t, "loc", c.Zone.Name)
+	assert.Len(t, c.Interfaces, 2)
+	assert.Len(t, c.Policies, 1)
+	assert.Len(t, c.Rules, 2)
+	assert.Equal(t, "NEW", c.Rules[1].Section)
+	assert.Equal(t, []Snat{{Action: "MASQUERADE", Source: "10.0.0.0/8", Destination: "eth2"}}, c.Snats)
+	for f, b := range before {
+		after, err := os.ReadFile(path.Join(dir, f))
+		assert.NoError(t, err)
+		assert.Equal(t, string(b), string(after), "dry run wrote %s", f)
+	}
+
+	removed, err := app.RemoveZoneCascade("loc")
+	assert.NoError(t, err)
+	assert.Equal(t, c, removed)
+
+	zones, err := app.Zones()
+	assert.NoError(t, err)
+	assert.Equal(t, []Zone{{Name: "dmz", Type: "ipv4"}}, zones)
+	interfaces, err := app.Interfaces()
+	assert.NoError(t, err)
+	assert.Equal(t, []Interface{{Zone: "dmz", Name: "eth3"}}, interfaces)
+	rules, err := app.Rules()
+	assert.NoError(t, err)
+	assert.Len(t, rules, 1)
+	snats, err := app.Snats()
+	assert.NoError(t, err)
+	assert.Len(t, snats, 1)
+	problems, err := app.ValidateReferences(ReadOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+
+	_, err = app.RemoveZoneCascade("loc")
+	assert.ErrorIs(t, err, ErrZoneNotFound)
+}
+
+func TestRemoveZoneCascade_Rollback(t *testing.T) {
+	lockDirPath = "/tmp/.goshorewall"
+
+	dir := t.TempDir()
+	writeFile(t, path.Join(dir, zonesFile), "fw\tfirewall\n")
+	writeFile(t, path.Join(dir, interfacesFile), "")
+	writeFile(t, path.Join(dir, rulesFile), "")
+
+	app, err := NewAppWithBasePath(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, app.AddZone(Zone{Name: "loc", Type: "ipv4"}))
+	assert.NoError(t, app.AddRule(Rule{Action: "ACCEPT", Source: "loc", Destination: "$FW"}))
+	rules, err := os.ReadFile(path.Join(dir, rulesFile))
+	assert.NoError(t, err)
+
+	// A rule of another application still uses the zone
+	writeFile(t, path.Join(dir, rulesFile), string(rules)+"DROP\tloc\tall\n")
+	app.SetPreCommitHook(app.ReferenceCheck())
+	_, err = app.RemoveZoneCascade("loc")
+	assert.ErrorIs(t, err, ErrDanglingReference)
+
+	after, err := os.ReadFile(path.Join(dir, rulesFile))
+	assert.NoError(t, err)
+	assert.Equal(t, string(rules)+"DROP\tloc\tall\n", string(after))
+	zones, err := app.Zones()
+	assert.NoError(t, err)
+	assert.Len(t, zones, 1)
+}
+
+func TestRemoveZoneCascade_CommitsAllFiles(t *testing.T) {
+	lockDirPath = "/tmp/.goshorewall"
+
+	dir := t.TempDir()
+	writeFile(t, path.Join(dir, zonesFile), "fw\tfirewall\n")
+	writeFile(t, path.Join(dir, interfacesFile), "")
+	writeFile(t, path.Join(dir, rulesFile), "")
+	assert.NoError(t, os.Chmod(path.Join(dir, rulesFile), 0o640))
+
+	app, err := NewAppWithBasePath(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, app.AddZone(Zone{Name: "loc", Type: "ipv4"}))
+	assert.NoError(t, app.AddInterface(Interface{Zone: "loc", Name: "eth1"}))
+	assert.NoError(t, app.AddRule(Rule{Action: "ACCEPT", Source: "loc", Destination: "$FW"}))
+
+	var calls []map[string][]byte
+	app.SetPreCommitHook(func(files map[string][]byte) error {
+		calls = append(calls, files)
+		return nil
+	})
+	_, err = app.RemoveZoneCascade("loc")
+	assert.NoError(t, err)
+
+	assert.Len(t, calls, 1, "the hook sees every edit at once")
+	for _, f := range []string{zonesFile, interfacesFile, rulesFile} {
+		after, err := os.ReadFile(path.Join(dir, f))
+		assert.NoError(t, err)
+		assert.Equal(t, string(calls[0][path.Join(dir, f)]), string(after), "content of %s", f)
+	}
+	info, err := os.Stat(path.Join(dir, rulesFile))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o640), info.Mode().Perm())
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3, "no temporary file is left")
+}
@@ -0,0 +1,167 @@
+package goshorewall
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var ErrUnknownColumn = errors.New("unknown column")
+
+// Column names of the supported files, in positional order, as accepted by
+// Shorewall in the column=value form.
+var (
+	zoneColumns      = []string{"zone", "type"}
+	interfaceColumns = []string{"zone", "interface", "broadcast", "options"}
+	hostColumns      = []string{"zone", "hosts", "options"}
+	policyColumns    = []string{"source", "dest", "policy", "loglevel"}
+	ruleColumns      = []string{"action", "source", "dest", "proto", "dport", "sport", "origdest", "rate", "user", "mark", "connlimit", "time", "headers", "switch", "helper"}
+	snatColumns      = []string{"action", "source", "dest"}
+	proxyArpColumns  = []string{"address", "interface", "external", "haveroute", "persistent"}
+	actionColumns    = []string{"action", "options"}
+	conntrackColumns = []string{"action", "source", "dest", "proto", "dport", "sport", "user", "switch"}
+)
+
+// Column names of the alternate layouts selected with ?FORMAT: the interfaces
+// file without the BROADCAST column, macro and action bodies in format 1,
+// without the ORIGDEST column, and the conntrack file in format 1, without
+// the ACTION and SWITCH columns.
+var (
+	interfaceFormat2Columns = []string{"zone", "interface", "options"}
+	macroFormat1Columns     = []string{"action", "source", "dest", "proto", "dport", "sport", "rate", "user"}
+	conntrackFormat1Columns = []string{"source", "dest", "proto", "dport", "sport", "user"}
+)
+
+// expandColumns returns fields in positional order, with the column=value
+// pairs (also written as column=>value, optionally between braces and
+// separated by commas) moved to the position of their column. Positional
+// fields following a pair continue after the column of the pair. Columns not
+// given are left empty.
+//
+// A field is only considered a pair if its name is one of names, so that
+// values containing '=', such as interface options, stay positional. Pairs
+// naming unknown columns between braces are dropped and reported with
+// ErrUnknownColumn.
+func expandColumns(fields, names []string) ([]string, error) {
+	var columns []string
+	var errs []error
+	pos := 0
+	set := func(i int, value string) {
+		for len(columns) <= i {
+			columns = append(columns, "")
+		}
+		columns[i] = value
+	}
+
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		if !strings.HasPrefix(field, "{") {
+			if name, value, ok := splitColumnPair(field); ok {
+				if c := slices.Index(names, name); c != -1 {
+					set(c, value)
+					pos = max(pos, c+1)
+					continue
+				}
+			}
+			set(pos, field)
+			pos++
+			continue
+		}
+
+		// Join the fields up to the closing brace and split the pairs on
+		// commas, keeping commas inside values such as "dport=22,80".
+		var b strings.Builder
+		for ; i < len(fields); i++ {
+			b.WriteString(fields[i] + " ")
+			if strings.HasSuffix(fields[i], "}") {
+				break
+			}
+		}
+		body := strings.TrimSpace(b.String())
+		body = strings.TrimSuffix(strings.TrimPrefix(body, "{"), "}")
+
+		var pairs []string
+		for _, piece := range strings.FieldsFunc(body, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		}) {
+			if _, _, ok := splitColumnPair(piece); ok || len(pairs) == 0 {
+				pairs = append(pairs, piece)
+			} else {
+				pairs[len(pairs)-1] += "," + piece
+			}
+		}
+
+		for _, p := range pairs {
+			name, value, ok := splitColumnPair(p)
+			c := slices.Index(names, name)
+			if !ok || c == -1 {
+				errs = append(errs, fmt.Errorf("%w: %q", ErrUnknownColumn, p))
+				continue
+			}
+			set(c, value)
+		}
+	}
+	return columns, errors.Join(errs...)
+}
+
+// splitColumnPair splits a column=value or column=>value pair, returning the
+// lower case column name.
+func splitColumnPair(s string) (string, string, bool) {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || name == "" || strings.ContainsAny(name, ",:") {
+		return "", "", false
+	}
+	return strings.ToLower(name), strings.TrimPrefix(value, ">"), true
+}
+
+// formatColumns formats the values of an entry in positional form, separated
+// by tabs. Empty values followed by a non empty one are written as "-" to
+// keep the following columns in place.
+func formatColumns(names []string, values ...string) string {
+	values = slices.Clone(values)
+	last := -1
+	for i, v := range values {
+		if v != "" {
+			last = i
+		}
+		// A value read back as a pair or as braces is written as a pair of
+		// its own column.
+		if name, _, ok := splitColumnPair(v); (ok && slices.Contains(names, name)) || strings.HasPrefix(v, "{") {
+			v = names[i] + "=" + v
+		}
+		values[i] = quoteColumn(v)
+	}
+	for i := 0; i < last; i++ {
+		if values[i] == "" {
+			values[i] = "-"
+		}
+	}
+	return strings.Join(values, "\t")
+}
+
+// quoteColumn quotes values that would otherwise be split or cut by the
+// tokenizer.
+func quoteColumn(v string) string {
+	if strings.ContainsAny(v, " \t\r\v\f#") || strings.HasSuffix(v, "\\") {
+		return `"` + v + `"`
+	}
+	return v
+}
+
+// formatNamedColumns formats the values of an entry in the alternate
+// column=value form, e.g. "ACCEPT { source=net, dest=fw, proto=tcp, dport=22 }":
+// the first value positionally and the following non empty values as pairs
+// between braces.
+func formatNamedColumns(names []string, values ...string) string {
+	var pairs []string
+	for i, v := range values[1:] {
+		if v != "" && v != "-" {
+			pairs = append(pairs, names[i+1]+"="+quoteColumn(v))
+		}
+	}
+	if len(pairs) == 0 {
+		return quoteColumn(values[0])
+	}
+	return quoteColumn(values[0]) + " { " + strings.Join(pairs, ", ") + " }"
+}
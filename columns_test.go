@@ -0,0 +1,74 @@
+package goshorewall
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const rulesNamedColumns01 = `ACCEPT	net	fw	proto=tcp	dport=22
+ACCEPT	{ source=net, dest=fw, proto=tcp, dport=80,443 }
+DNAT	net	loc:192.168.1.3	{ proto=>tcp dport=8080 }
+ACCEPT	{ source=net, dest=fw, bogus=1 }
+`
+
+func TestParseRules_NamedColumns(t *testing.T) {
+	rules := parseRules([]byte(rulesNamedColumns01))
+	assert.Len(t, rules, 4)
+	assert.Equal(t, Rule{Action: "ACCEPT", Source: "net", Destination: "fw", Protocol: "tcp", Dport: "22"}, rules[0])
+	assert.Equal(t, Rule{Action: "ACCEPT", Source: "net", Destination: "fw", Protocol: "tcp", Dport: "80,443"}, rules[1])
+	assert.Equal(t, Rule{Action: "DNAT", Source: "net", Destination: "loc:192.168.1.3", Protocol: "tcp", Dport: "8080"}, rules[2])
+	assert.Equal(t, Rule{Action: "ACCEPT", Source: "net", Destination: "fw"}, rules[3])
+}
+
+func TestExpandColumns(t *testing.T) {
+	columns, err := expandColumns([]string{"loc", "eth0", "dhcp,arp_filter=1"}, []string{"zone", "interface"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"loc", "eth0", "dhcp,arp_filter=1"}, columns)
+
+	columns, err = expandColumns([]string{"{", "interface=eth0,", "zone=loc", "}"}, interfaceColumns)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"loc", "eth0"}, columns)
+
+	columns, err = expandColumns([]string{"ACCEPT", "net", "fw", "proto=tcp", "22"}, ruleColumns)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ACCEPT", "net", "fw", "tcp", "22"}, columns)
+
+	_, err = expandColumns([]string{"ACCEPT", "{frob=1}"}, ruleColumns)
+	assert.ErrorIs(t, err, ErrUnknownColumn)
+}
+
+func TestFormatNamed(t *testing.T) {
+	rule := Rule{Action: "ACCEPT", Source: "net", Destination: "fw", Protocol: "tcp", Dport: "22,80"}
+	assert.Equal(t, "ACCEPT { source=net, dest=fw, proto=tcp, dport=22,80 }", rule.FormatNamed())
+	assert.Equal(t, []Rule{rule}, parseRules([]byte(rule.FormatNamed()+"\n")))
+	assert.Equal(t, "ACCEPT\tnet\tfw\ttcp\t22,80\t\t", rule.Format())
+
+	zone := Zone{Name: "net", Type: "ipv4"}
+	assert.Equal(t, "net { type=ipv4 }", zone.FormatNamed())
+	assert.Equal(t, []Zone{zone}, parseZones([]byte(zone.FormatNamed()+"\n")))
+
+	policy := Policy{Source: "net", Destination: "all", Policy: "DROP", Log: "info"}
+	assert.Equal(t, []Policy{policy}, parsePolicies([]byte(policy.FormatNamed()+"\n")))
+
+	iface := Interface{Zone: "net", Name: "eth0", Options: "dhcp,tcpflags"}
+	assert.Equal(t, "net { interface=eth0, options=dhcp,tcpflags }", iface.FormatNamed())
+	assert.Equal(t, []Interface{iface}, parseInterfaces([]byte(iface.FormatNamed()+"\n")))
+
+	proxyArp := ProxyArp{Address: "10.0.0.5", Interface: "eth1", External: "eth0", Persistent: "yes"}
+	assert.Equal(t, "10.0.0.5 { interface=eth1, external=eth0, persistent=yes }", proxyArp.FormatNamed())
+	assert.Equal(t, []ProxyArp{proxyArp}, parseProxyArps([]byte(proxyArp.FormatNamed()+"\n")))
+}
+
+func TestFormatColumns_Placeholders(t *testing.T) {
+	rule := Rule{Action: "ACCEPT", Source: "net", Destination: "fw", Sport: "1024:"}
+	assert.Equal(t, "ACCEPT\tnet\tfw\t-\t-\t1024:\t", rule.Format())
+
+	rule = Rule{Action: "LOG:info", Source: "net", Destination: "fw", Origdest: "with # hash"}
+	assert.Equal(t, "LOG:info\tnet\tfw\t-\t-\t-\t\"with # hash\"", rule.Format())
+	assert.Equal(t, "with # hash", parseRules([]byte(rule.Format() + "\n"))[0].Origdest)
+
+	assert.Equal(t, "\"a b\"", quoteColumn("a b"))
+	assert.Equal(t, "\"a\\\"", quoteColumn("a\\"))
+	assert.Equal(t, "eth0", quoteColumn("eth0"))
+}
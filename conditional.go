@@ -0,0 +1,332 @@
+package goshorewall
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+const paramsFile = "params"
+
+var (
+	ErrConditionalUnbalanced = errors.New("unbalanced conditional directive")
+	ErrConditionalExpression = errors.New("invalid conditional expression")
+)
+
+// ReadOptions controls how the top-level functions, such as RulesWithOptions,
+// read the configuration files.
+type ReadOptions struct {
+	// Raw returns the raw view of the files: ?IF, ?ELSIF, ?ELSE and ?ENDIF
+	// are not evaluated and the entries of every branch are returned.
+	// Otherwise only the entries of the active branches are returned.
+	Raw bool
+	// Family selects the configuration read by the top-level functions,
+	// /etc/shorewall or /etc/shorewall6, and sets the __IPV4 and __IPV6
+	// built-in variables.
+	Family Family
+	// Params are the variables referenced as $NAME. If nil, they are read
+	// from the params file of the configuration directory.
+	Params map[string]string
+	// Capabilities are the names of the available capabilities, such as
+	// IPSET_MATCH, referenced as __IPSET_MATCH.
+	Capabilities []string
+	// Strict makes the read fail with a ParseErrors error listing the lines
+	// that could not be parsed, instead of returning them as warnings.
+	Strict bool
+}
+
+// ReadParams reads the NAME=value assignments of the params file.
+func ReadParams() (map[string]string, error) {
+	return readParams(path.Join(shorewallConfigPath, paramsFile))
+}
+
+func readParams(p string) (map[string]string, error) {
+	buff, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	return parseParams(buff), nil
+}
+
+// parseParams parses the simple assignments of a params file, which is a
+// shell script. Assignments may be prefixed with "export".
+func parseParams(data []byte) map[string]string {
+	var b bytes.Buffer
+	for l := range bytes.Lines(data) {
+		trimmed := bytes.TrimLeft(l, " \t")
+		if rest, ok := bytes.CutPrefix(trimmed, []byte("export ")); ok {
+			l = bytes.TrimLeft(rest, " \t")
+		}
+		b.Write(l)
+	}
+
+	c := ParseConfig(b.Bytes())
+	params := make(map[string]string)
+	for _, k := range c.Keys() {
+		params[k], _ = c.Get(k)
+	}
+	return params
+}
+
+// conditionalFrame is an ?IF ... ?ENDIF block being read.
+type conditionalFrame struct {
+	// parent reports whether the enclosing block is active.
+	parent bool
+	// active reports whether the current branch is active.
+	active bool
+	// taken reports whether a branch of the block has already been active.
+	taken bool
+	// sawElse reports whether the ?ELSE branch has been reached.
+	sawElse bool
+}
+
+// conditionals evaluates the conditional directives of a file.
+type conditionals struct {
+	opts  ReadOptions
+	stack []conditionalFrame
+}
+
+// active reports whether the lines read are in an active branch.
+func (c *conditionals) active() bool {
+	return len(c.stack) == 0 || c.stack[len(c.stack)-1].active
+}
+
+// update updates the state with l and reports whether l is a conditional
+// directive.
+func (c *conditionals) update(l logicalLine) (bool, error) {
+	name := l.directiveName()
+	switch name {
+	case "IF":
+		f := conditionalFrame{parent: c.active()}
+		if f.parent {
+			v, err := c.eval(l.directiveArgs())
+			if err != nil {
+				return true, err
+			}
+			f.active, f.taken = v, v
+		}
+		c.stack = append(c.stack, f)
+	case "ELSIF", "ELSE":
+		if len(c.stack) == 0 || c.stack[len(c.stack)-1].sawElse {
+			return true, fmt.Errorf("%w: unexpected ?%s", ErrConditionalUnbalanced, name)
+		}
+		f := &c.stack[len(c.stack)-1]
+		f.active = false
+		if f.parent && !f.taken {
+			v := true
+			if name == "ELSIF" {
+				var err error
+				if v, err = c.eval(l.directiveArgs()); err != nil {
+					return true, err
+				}
+			}
+			f.active, f.taken = v, v
+		}
+		f.sawElse = name == "ELSE"
+	case "ENDIF":
+		if len(c.stack) == 0 {
+			return true, fmt.Errorf("%w: unexpected ?ENDIF", ErrConditionalUnbalanced)
+		}
+		c.stack = c.stack[:len(c.stack)-1]
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// end checks that every block has been closed.
+func (c *conditionals) end() error {
+	if len(c.stack) != 0 {
+		return fmt.Errorf("%w: missing ?ENDIF", ErrConditionalUnbalanced)
+	}
+	return nil
+}
+
+func (c *conditionals) eval(expr string) (bool, error) {
+	p := exprParser{tokens: splitExpr(expr), c: c}
+	if len(p.tokens) == 0 {
+		return false, fmt.Errorf("%w: empty expression", ErrConditionalExpression)
+	}
+	v, err := p.or()
+	if err != nil {
+		return false, err
+	}
+	if p.pos != len(p.tokens) {
+		return false, fmt.Errorf("%w: unexpected %q in %q", ErrConditionalExpression, p.tokens[p.pos], expr)
+	}
+	return truthy(v), nil
+}
+
+// value returns the value of an operand: a $NAME param, a __NAME built-in
+// variable or capability, or a literal.
+func (c *conditionals) value(operand string) string {
+	switch {
+	case strings.HasPrefix(operand, "${") && strings.HasSuffix(operand, "}"):
+		return c.opts.Params[operand[2:len(operand)-1]]
+	case strings.HasPrefix(operand, "$"):
+		return c.opts.Params[operand[1:]]
+	case strings.HasPrefix(operand, "__"):
+		name := operand[2:]
+		switch {
+		case name == "IPV4":
+			return boolValue(c.opts.Family == IPv4)
+		case name == "IPV6":
+			return boolValue(c.opts.Family == IPv6)
+		default:
+			return boolValue(slices.Contains(c.opts.Capabilities, name))
+		}
+	case len(operand) >= 2 && operand[0] == '\'' && operand[len(operand)-1] == '\'':
+		return operand[1 : len(operand)-1]
+	}
+	return operand
+}
+
+// truthy follows Perl, which Shorewall uses to evaluate expressions: "" and
+// "0" are false.
+func truthy(v string) bool {
+	return v != "" && v != "0"
+}
+
+func boolValue(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// splitExpr splits a conditional expression into operators and operands.
+func splitExpr(expr string) []string {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		switch c := expr[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')':
+			tokens = append(tokens, string(c))
+			i++
+		case strings.HasPrefix(expr[i:], "&&"), strings.HasPrefix(expr[i:], "||"),
+			strings.HasPrefix(expr[i:], "=="), strings.HasPrefix(expr[i:], "!="):
+			tokens = append(tokens, expr[i:i+2])
+			i += 2
+		case c == '!':
+			tokens = append(tokens, "!")
+			i++
+		case c == '\'':
+			end := strings.IndexByte(expr[i+1:], '\'')
+			if end == -1 {
+				tokens = append(tokens, expr[i:])
+				return tokens
+			}
+			tokens = append(tokens, expr[i:i+end+2])
+			i += end + 2
+		default:
+			j := i
+			for j < len(expr) && !strings.ContainsRune(" \t()!&|=", rune(expr[j])) {
+				j++
+			}
+			if j == i {
+				// A lone '&', '|' or '='
+				j++
+			}
+			tokens = append(tokens, expr[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
+
+// exprParser evaluates the tokens of an expression with the grammar:
+//
+//	or      = and { "||" and }
+//	and     = not { "&&" not }
+//	not     = "!" not | compare
+//	compare = primary [ ( "==" | "!=" ) primary ]
+//	primary = "(" or ")" | operand
+type exprParser struct {
+	tokens []string
+	pos    int
+	c      *conditionals
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) or() (string, error) {
+	v, err := p.and()
+	for err == nil && p.peek() == "||" {
+		p.pos++
+		var r string
+		if r, err = p.and(); err == nil {
+			v = boolValue(truthy(v) || truthy(r))
+		}
+	}
+	return v, err
+}
+
+func (p *exprParser) and() (string, error) {
+	v, err := p.not()
+	for err == nil && p.peek() == "&&" {
+		p.pos++
+		var r string
+		if r, err = p.not(); err == nil {
+			v = boolValue(truthy(v) && truthy(r))
+		}
+	}
+	return v, err
+}
+
+func (p *exprParser) not() (string, error) {
+	if p.peek() == "!" {
+		p.pos++
+		v, err := p.not()
+		return boolValue(!truthy(v)), err
+	}
+	return p.compare()
+}
+
+func (p *exprParser) compare() (string, error) {
+	v, err := p.primary()
+	if err != nil {
+		return "", err
+	}
+	if op := p.peek(); op == "==" || op == "!=" {
+		p.pos++
+		r, err := p.primary()
+		if err != nil {
+			return "", err
+		}
+		return boolValue((v == r) == (op == "==")), nil
+	}
+	return v, nil
+}
+
+func (p *exprParser) primary() (string, error) {
+	tok := p.peek()
+	switch tok {
+	case "":
+		return "", fmt.Errorf("%w: unexpected end of expression", ErrConditionalExpression)
+	case "(":
+		p.pos++
+		v, err := p.or()
+		if err != nil {
+			return "", err
+		}
+		if p.peek() != ")" {
+			return "", fmt.Errorf("%w: missing ')'", ErrConditionalExpression)
+		}
+		p.pos++
+		return v, nil
+	case ")", "&&", "||", "==", "!=", "&", "|", "=":
+		return "", fmt.Errorf("%w: unexpected %q", ErrConditionalExpression, tok)
+	}
+	p.pos++
+	return p.c.value(tok), nil
+}
@@ -0,0 +1,89 @@
+package goshorewall
+
+import (
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const rulesConditional01 = `ACCEPT	net	fw	tcp	22
+?IF __IPV6
+ACCEPT	net	fw	ipv6-icmp
+?ELSE
+ACCEPT	net	fw	icmp
+?ENDIF
+?IF $WEB && ! $MAINTENANCE
+ACCEPT	net	fw	tcp	80
+?ELSIF $MAINTENANCE == 'yes'
+REJECT	net	fw	tcp	80
+?ENDIF
+?IF __IPSET_MATCH
+?INCLUDE rules.missing
+?ENDIF
+`
+
+func TestConditionals(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, path.Join(dir, rulesFile), rulesConditional01)
+	writeFile(t, path.Join(dir, paramsFile), "WEB=1\nexport MAINTENANCE=yes\n")
+
+	load := func(opts ReadOptions) ([]Rule, error) {
+		ld, err := newFileLoader(dir, confFile, opts)
+		assert.NoError(t, err)
+		lines, err := ld.load(path.Join(dir, rulesFile))
+		return parseRuleLines(lines, 3, directiveState{}, nil), err
+	}
+
+	rules, err := load(ReadOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, rules, 3)
+	assert.Equal(t, "icmp", rules[1].Protocol)
+	assert.Equal(t, "REJECT", rules[2].Action)
+
+	rules, err = load(ReadOptions{Family: IPv6, Params: map[string]string{"WEB": "1"}})
+	assert.NoError(t, err)
+	assert.Len(t, rules, 3)
+	assert.Equal(t, "ipv6-icmp", rules[1].Protocol)
+	assert.Equal(t, "ACCEPT", rules[2].Action)
+
+	_, err = load(ReadOptions{Capabilities: []string{"IPSET_MATCH"}})
+	assert.ErrorIs(t, err, ErrIncludeNotFound)
+
+	writeFile(t, path.Join(dir, rulesFile), "?IF __IPV4\nACCEPT\tnet\tfw\n?ELSE\nDROP\tnet\tfw\n")
+	_, err = load(ReadOptions{})
+	assert.ErrorIs(t, err, ErrConditionalUnbalanced)
+
+	rules, err = load(ReadOptions{Raw: true})
+	assert.NoError(t, err)
+	assert.Len(t, rules, 2)
+}
+
+func TestConditionals_Eval(t *testing.T) {
+	c := conditionals{opts: ReadOptions{Params: map[string]string{"A": "1", "B": "0", "N": "eth0"}}}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"$A", true},
+		{"$B", false},
+		{"$UNSET", false},
+		{"!$B", true},
+		{"$A && $B", false},
+		{"$A || $B", true},
+		{"${N} == eth0", true},
+		{"$N != 'eth0'", false},
+		{"!($A && $B) && __IPV4", true},
+		{"__IPV6", false},
+	}
+	for _, tt := range tests {
+		got, err := c.eval(tt.expr)
+		assert.NoError(t, err, tt.expr)
+		assert.Equal(t, tt.want, got, tt.expr)
+	}
+
+	for _, expr := range []string{"", "$A &&", "($A", "$A)", "== $A"} {
+		_, err := c.eval(expr)
+		assert.ErrorIs(t, err, ErrConditionalExpression, expr)
+	}
+}
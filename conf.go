@@ -0,0 +1,376 @@
+package goshorewall
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	ErrConfigInvalidKey   = errors.New("invalid shorewall.conf key")
+	ErrConfigInvalidValue = errors.New("invalid shorewall.conf value")
+)
+
+// Documented shorewall.conf options that have typed accessors on Config.
+const (
+	ConfigIPForwarding   = "IP_FORWARDING"
+	ConfigLogLevel       = "LOG_LEVEL"
+	ConfigLogFormat      = "LOGFORMAT"
+	ConfigStartupEnabled = "STARTUP_ENABLED"
+	ConfigDocker         = "DOCKER"
+	ConfigBlacklist      = "BLACKLIST"
+	ConfigConfigPath     = "CONFIG_PATH"
+)
+
+// Values accepted by the IP_FORWARDING option.
+const (
+	IPForwardingOn   = "On"
+	IPForwardingOff  = "Off"
+	IPForwardingKeep = "Keep"
+)
+
+// Config is a parsed shorewall.conf file. Unlike the columnar files,
+// shorewall.conf is a KEY=value shell file. Config keeps every line of the
+// original file, including comments and ordering, so that setting a key only
+// rewrites the line holding it.
+type Config struct {
+	lines []configLine
+	// noFinalNewline is set when the last line of the file has no newline.
+	noFinalNewline bool
+}
+
+type configLine struct {
+	raw string
+	key string
+	// valueStart and valueEnd delimit the (possibly quoted) value in raw.
+	valueStart int
+	valueEnd   int
+}
+
+type configOption struct {
+	Key   string
+	Value string
+}
+
+// ParseConfig parses the content of a shorewall.conf file.
+func ParseConfig(data []byte) *Config {
+	c := &Config{}
+	for l := range bytes.Lines(data) {
+		c.lines = append(c.lines, parseConfigLine(strings.TrimSuffix(string(l), "\n")))
+	}
+	c.noFinalNewline = len(data) > 0 && data[len(data)-1] != '\n'
+	return c
+}
+
+func parseConfigLine(raw string) configLine {
+	line := configLine{raw: raw}
+	trimmed := strings.TrimLeft(raw, " \t")
+	if len(trimmed) == 0 || trimmed[0] == '#' {
+		return line
+	}
+	eq := strings.IndexByte(raw, '=')
+	if eq == -1 {
+		return line
+	}
+	key := strings.TrimSpace(raw[:eq])
+	if !isConfigKey(key) {
+		return line
+	}
+
+	start := eq + 1
+	end := start
+	if end < len(raw) && (raw[end] == '"' || raw[end] == '\'') {
+		quote := raw[end]
+		end++
+		for end < len(raw) && raw[end] != quote {
+			if raw[end] == '\\' && quote == '"' {
+				end++
+			}
+			end++
+		}
+		end = min(end+1, len(raw))
+	} else {
+		for end < len(raw) && raw[end] != ' ' && raw[end] != '\t' && raw[end] != '#' {
+			end++
+		}
+	}
+
+	line.key = key
+	line.valueStart = start
+	line.valueEnd = end
+	return line
+}
+
+func isConfigKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i, c := range key {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c == '_':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Bytes returns the content of the configuration file.
+func (c *Config) Bytes() []byte {
+	var b bytes.Buffer
+	for i, l := range c.lines {
+		b.WriteString(l.raw)
+		if i < len(c.lines)-1 || !c.noFinalNewline {
+			b.WriteByte('\n')
+		}
+	}
+	return b.Bytes()
+}
+
+// Keys returns the keys set in the configuration file, in file order.
+func (c *Config) Keys() []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, l := range c.lines {
+		if l.key != "" && !seen[l.key] {
+			seen[l.key] = true
+			keys = append(keys, l.key)
+		}
+	}
+	return keys
+}
+
+// Get returns the unquoted value of key. As in a shell file, when a key is
+// assigned more than once the last assignment wins.
+func (c *Config) Get(key string) (string, bool) {
+	i := c.index(key)
+	if i == -1 {
+		return "", false
+	}
+	l := c.lines[i]
+	return unquoteConfigValue(l.raw[l.valueStart:l.valueEnd]), true
+}
+
+// Set assigns value to key. If the key is already present only its value is
+// rewritten, otherwise a new KEY=value line is appended to the file.
+func (c *Config) Set(key, value string) error {
+	if !isConfigKey(key) {
+		return fmt.Errorf("%w: %q", ErrConfigInvalidKey, key)
+	}
+	if strings.ContainsAny(value, "\n\r") {
+		return fmt.Errorf("%w: %s contains a newline", ErrConfigInvalidValue, key)
+	}
+	quoted := quoteConfigValue(value)
+
+	i := c.index(key)
+	if i == -1 {
+		c.lines = append(c.lines, parseConfigLine(key+"="+quoted))
+		c.noFinalNewline = false
+		return nil
+	}
+	l := c.lines[i]
+	c.lines[i] = parseConfigLine(l.raw[:l.valueStart] + quoted + l.raw[l.valueEnd:])
+	return nil
+}
+
+func (c *Config) index(key string) int {
+	for i := len(c.lines) - 1; i >= 0; i-- {
+		if c.lines[i].key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// IPForwarding returns the value of IP_FORWARDING (On, Off or Keep).
+func (c *Config) IPForwarding() string {
+	v, _ := c.Get(ConfigIPForwarding)
+	switch strings.ToLower(v) {
+	case "on", "yes":
+		return IPForwardingOn
+	case "off", "no":
+		return IPForwardingOff
+	case "keep":
+		return IPForwardingKeep
+	}
+	return v
+}
+
+// SetIPForwarding sets IP_FORWARDING. Value must be On, Off or Keep.
+func (c *Config) SetIPForwarding(value string) error {
+	switch value {
+	case IPForwardingOn, IPForwardingOff, IPForwardingKeep:
+		return c.Set(ConfigIPForwarding, value)
+	}
+	return fmt.Errorf("%w: %s must be one of On, Off or Keep, got %q", ErrConfigInvalidValue, ConfigIPForwarding, value)
+}
+
+// LogLevel returns the value of LOG_LEVEL.
+func (c *Config) LogLevel() string {
+	v, _ := c.Get(ConfigLogLevel)
+	return v
+}
+
+// SetLogLevel sets LOG_LEVEL.
+func (c *Config) SetLogLevel(level string) error {
+	return c.Set(ConfigLogLevel, level)
+}
+
+// LogFormat returns the value of LOGFORMAT.
+func (c *Config) LogFormat() string {
+	v, _ := c.Get(ConfigLogFormat)
+	return v
+}
+
+// SetLogFormat sets LOGFORMAT.
+func (c *Config) SetLogFormat(format string) error {
+	return c.Set(ConfigLogFormat, format)
+}
+
+// StartupEnabled returns the value of STARTUP_ENABLED.
+func (c *Config) StartupEnabled() (bool, error) {
+	return c.getBool(ConfigStartupEnabled)
+}
+
+// SetStartupEnabled sets STARTUP_ENABLED.
+func (c *Config) SetStartupEnabled(enabled bool) error {
+	return c.setBool(ConfigStartupEnabled, enabled)
+}
+
+// Docker returns the value of DOCKER.
+func (c *Config) Docker() (bool, error) {
+	return c.getBool(ConfigDocker)
+}
+
+// SetDocker sets DOCKER.
+func (c *Config) SetDocker(enabled bool) error {
+	return c.setBool(ConfigDocker, enabled)
+}
+
+// Blacklist returns the connection states listed in BLACKLIST.
+func (c *Config) Blacklist() []string {
+	v, _ := c.Get(ConfigBlacklist)
+	return splitConfigList(v, ",")
+}
+
+// SetBlacklist sets BLACKLIST to the given connection states.
+func (c *Config) SetBlacklist(states []string) error {
+	for _, s := range states {
+		switch strings.ToUpper(s) {
+		case "ALL", "NEW", "ESTABLISHED", "RELATED", "INVALID", "UNTRACKED":
+		default:
+			return fmt.Errorf("%w: %s contains unknown connection state %q", ErrConfigInvalidValue, ConfigBlacklist, s)
+		}
+	}
+	return c.Set(ConfigBlacklist, strings.Join(states, ","))
+}
+
+// ConfigPath returns the directories listed in CONFIG_PATH.
+func (c *Config) ConfigPath() []string {
+	v, _ := c.Get(ConfigConfigPath)
+	return splitConfigList(v, ":")
+}
+
+// SetConfigPath sets CONFIG_PATH to the given directories.
+func (c *Config) SetConfigPath(dirs []string) error {
+	return c.Set(ConfigConfigPath, strings.Join(dirs, ":"))
+}
+
+func (c *Config) getBool(key string) (bool, error) {
+	v, ok := c.Get(key)
+	if !ok || v == "" {
+		return false, nil
+	}
+	switch strings.ToLower(v) {
+	case "yes", "on", "1":
+		return true, nil
+	case "no", "off", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("%w: %s must be Yes or No, got %q", ErrConfigInvalidValue, key, v)
+}
+
+func (c *Config) setBool(key string, value bool) error {
+	if value {
+		return c.Set(key, "Yes")
+	}
+	return c.Set(key, "No")
+}
+
+func splitConfigList(v, sep string) []string {
+	var out []string
+	for s := range strings.SplitSeq(v, sep) {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func unquoteConfigValue(v string) string {
+	if len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'' {
+		return v[1 : len(v)-1]
+	}
+	if len(v) >= 1 && v[0] == '"' {
+		v = strings.TrimSuffix(v[1:], `"`)
+		var b strings.Builder
+		for i := 0; i < len(v); i++ {
+			if v[i] == '\\' && i+1 < len(v) {
+				i++
+			}
+			b.WriteByte(v[i])
+		}
+		return b.String()
+	}
+	return v
+}
+
+func quoteConfigValue(v string) string {
+	if !strings.ContainsAny(v, " \t#\"'\\$`;&|<>()*?[]{}!~") {
+		return v
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, c := range v {
+		if c == '"' || c == '\\' || c == '$' || c == '`' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// ReadConfig reads and parses the shorewall.conf file.
+func ReadConfig() (*Config, error) {
+	buff, err := os.ReadFile(fullConfFile)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(buff), nil
+}
+
+// SetConfigOption sets a single key of the shorewall.conf file, leaving the
+// rest of the file untouched.
+func SetConfigOption(key, value string) error {
+	return readWriteFile(fullConfFile, setConfigOptionBuff, configOption{Key: key, Value: value})
+}
+
+func setConfigOptionBuff(buff []byte, option configOption) ([]byte, error) {
+	c := ParseConfig(buff)
+	if err := c.Set(option.Key, option.Value); err != nil {
+		return nil, err
+	}
+	return c.Bytes(), nil
+}
+
+func updateConfigBuff(buff []byte, fn func(*Config) error) ([]byte, error) {
+	c := ParseConfig(buff)
+	if err := fn(c); err != nil {
+		return nil, err
+	}
+	return c.Bytes(), nil
+}
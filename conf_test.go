@@ -0,0 +1,110 @@
+package goshorewall
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const conf01 = `###############################################################################
+#
+#  Shorewall Version 5 -- /etc/shorewall/shorewall.conf
+#
+###############################################################################
+#		       S T A R T U P   E N A B L E D
+###############################################################################
+
+STARTUP_ENABLED=No
+
+###############################################################################
+#			     L O G G I N G
+###############################################################################
+
+LOG_LEVEL="info"
+
+LOGFORMAT="%s %s "
+
+BLACKLIST="NEW,INVALID,UNTRACKED"
+
+CONFIG_PATH=":${CONFDIR}/shorewall:${SHAREDIR}/shorewall"
+
+IP_FORWARDING=Keep # managed by goshorewall
+
+DOCKER=No
+`
+
+func TestParseConfig(t *testing.T) {
+	c := ParseConfig([]byte(conf01))
+
+	enabled, err := c.StartupEnabled()
+	assert.NoError(t, err, "expected no error")
+	assert.False(t, enabled)
+
+	assert.Equal(t, "info", c.LogLevel())
+	assert.Equal(t, "%s %s ", c.LogFormat())
+	assert.Equal(t, IPForwardingKeep, c.IPForwarding())
+	assert.Equal(t, []string{"NEW", "INVALID", "UNTRACKED"}, c.Blacklist())
+	assert.Equal(t, []string{"${CONFDIR}/shorewall", "${SHAREDIR}/shorewall"}, c.ConfigPath())
+
+	docker, err := c.Docker()
+	assert.NoError(t, err, "expected no error")
+	assert.False(t, docker)
+
+	_, ok := c.Get("MISSING")
+	assert.False(t, ok)
+}
+
+func TestParseConfig_RoundTrip(t *testing.T) {
+	c := ParseConfig([]byte(conf01))
+	assert.Equal(t, conf01, string(c.Bytes()))
+}
+
+func TestParseConfig_FinalNewline(t *testing.T) {
+	c := ParseConfig([]byte("STARTUP_ENABLED=Yes\nLOG_LEVEL=info"))
+	assert.Equal(t, "STARTUP_ENABLED=Yes\nLOG_LEVEL=info", string(c.Bytes()))
+
+	c = ParseConfig([]byte("STARTUP_ENABLED=Yes\n\r\n"))
+	assert.Equal(t, "STARTUP_ENABLED=Yes\n\r\n", string(c.Bytes()))
+
+	assert.NoError(t, c.Set("DOCKER", "No"))
+	assert.True(t, strings.HasSuffix(string(c.Bytes()), "DOCKER=No\n"))
+}
+
+func TestConfigSet(t *testing.T) {
+	c := ParseConfig([]byte(conf01))
+
+	assert.NoError(t, c.SetStartupEnabled(true))
+	assert.NoError(t, c.SetIPForwarding(IPForwardingOn))
+	assert.NoError(t, c.SetLogFormat("Shorewall:%s:%s:"))
+	assert.NoError(t, c.Set("ZONE2ZONE", "-"))
+
+	out := string(c.Bytes())
+	assert.Contains(t, out, "\nSTARTUP_ENABLED=Yes\n")
+	assert.Contains(t, out, "\nIP_FORWARDING=On # managed by goshorewall\n")
+	assert.Contains(t, out, "\nLOGFORMAT=Shorewall:%s:%s:\n")
+	assert.Contains(t, out, "\nZONE2ZONE=-\n")
+	assert.Contains(t, out, "#  Shorewall Version 5 -- /etc/shorewall/shorewall.conf\n")
+
+	c = ParseConfig(c.Bytes())
+	enabled, err := c.StartupEnabled()
+	assert.NoError(t, err, "expected no error")
+	assert.True(t, enabled)
+	assert.Equal(t, IPForwardingOn, c.IPForwarding())
+}
+
+func TestConfigSet_Invalid(t *testing.T) {
+	c := ParseConfig([]byte(conf01))
+
+	assert.ErrorIs(t, c.Set("BAD KEY", "x"), ErrConfigInvalidKey)
+	assert.ErrorIs(t, c.Set("LOG_LEVEL", "a\nb"), ErrConfigInvalidValue)
+	assert.ErrorIs(t, c.SetIPForwarding("maybe"), ErrConfigInvalidValue)
+	assert.ErrorIs(t, c.SetBlacklist([]string{"NEW", "BOGUS"}), ErrConfigInvalidValue)
+}
+
+func TestConfigSet_Quoting(t *testing.T) {
+	c := ParseConfig(nil)
+	assert.NoError(t, c.SetLogFormat(`%s "x" $y`))
+	assert.Equal(t, "LOGFORMAT=\"%s \\\"x\\\" \\$y\"\n", string(c.Bytes()))
+	assert.Equal(t, `%s "x" $y`, ParseConfig(c.Bytes()).LogFormat())
+}
@@ -0,0 +1,236 @@
+package goshorewall
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrConntrackAlreadyExists = errors.New("conntrack entry already exists")
+	ErrConntrackNotFound      = errors.New("conntrack entry not found")
+)
+
+// conntrackNoTrack is the action of the entries of the conntrack file in
+// format 1, which has no ACTION column.
+const conntrackNoTrack = "NOTRACK"
+
+// Conntrack represents an entry of the Shorewall conntrack file. The layout
+// of the file is selected with ?FORMAT: format 1 has no ACTION and SWITCH
+// columns and every entry is a NOTRACK entry, formats 2 and 3 share the same
+// columns and only differ in the syntax of ACTION.
+type Conntrack struct {
+	Action string
+	Source string
+	Dest   string
+	Proto  string
+	DPort  string
+	SPort  string
+	User   string
+	// Switch is only present in the format 2 and 3 layouts and is dropped
+	// when writing in format 1.
+	Switch string
+	// Comment is the ?COMMENT annotation of the entry. It is not considered
+	// when comparing entries.
+	Comment string
+	// Location is the file and line the entry was read from.
+	Location Location
+}
+
+func (c Conntrack) Compare(other Conntrack) int {
+	if cmp := strings.Compare(c.Source, other.Source); cmp != 0 {
+		return cmp
+	}
+	if cmp := strings.Compare(c.Dest, other.Dest); cmp != 0 {
+		return cmp
+	}
+	if cmp := strings.Compare(c.Action, other.Action); cmp != 0 {
+		return cmp
+	}
+	if cmp := strings.Compare(c.Proto, other.Proto); cmp != 0 {
+		return cmp
+	}
+	if cmp := strings.Compare(c.DPort, other.DPort); cmp != 0 {
+		return cmp
+	}
+	if cmp := strings.Compare(c.SPort, other.SPort); cmp != 0 {
+		return cmp
+	}
+	if cmp := strings.Compare(c.User, other.User); cmp != 0 {
+		return cmp
+	}
+	return strings.Compare(c.Switch, other.Switch)
+}
+
+func (c Conntrack) Equals(other Conntrack) bool {
+	return c.Compare(other) == 0
+}
+
+// Format formats the entry in the format 3 layout, the one written by
+// shorewall update.
+func (c Conntrack) Format() string {
+	return c.formatAs(3)
+}
+
+// formatAs formats the entry in the layout selected by ?FORMAT format.
+func (c Conntrack) formatAs(format int) string {
+	if format < 2 {
+		line := formatColumns(conntrackFormat1Columns, c.Source, c.Dest, c.Proto, c.DPort, c.SPort, c.User)
+		return formatWithComment(c.Comment, strings.TrimRight(line, "\t"))
+	}
+	line := formatColumns(conntrackColumns, c.Action, c.Source, c.Dest, c.Proto, c.DPort, c.SPort, c.User, c.Switch)
+	return formatWithComment(c.Comment, strings.TrimRight(line, "\t"))
+}
+
+// FormatNamed formats the entry in the column=value form, with the column
+// names of formats 2 and 3.
+func (c Conntrack) FormatNamed() string {
+	return formatWithComment(c.Comment, formatNamedColumns(conntrackColumns, c.Action, c.Source, c.Dest, c.Proto, c.DPort, c.SPort, c.User, c.Switch))
+}
+
+func Conntracks() ([]Conntrack, error) {
+	entries, _, err := ConntracksWithOptions(ReadOptions{})
+	return entries, err
+}
+
+// ConntracksWithOptions reads the conntrack file, following includes and evaluating
+// conditional directives as configured by opts. In lenient mode the lines
+// that could not be parsed are returned as warnings, in strict mode they
+// are returned as a ParseErrors error.
+func ConntracksWithOptions(opts ReadOptions) ([]Conntrack, []ParseError, error) {
+	lines, err := loadConfigFile(conntrackFile, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	issues := &parseIssues{}
+	return result(parseConntracksLines(lines, issues), issues, opts.Strict)
+}
+
+// AddConntrack appends the entry to the conntrack file, in the layout of the
+// ?FORMAT in effect at the end of the file.
+func AddConntrack(conntrack Conntrack) error {
+	return readWriteFile(fullConntrackFile, addConntrackBuff, conntrack)
+}
+
+func RemoveConntrack(conntrack Conntrack) error {
+	return readWriteFile(fullConntrackFile, removeConntrackBuff, conntrack)
+}
+
+func UpdateConntrack(from, to Conntrack) error {
+	return readWriteFile(fullConntrackFile, updateConntrackBuff, entryUpdate[Conntrack]{old: from, new: to})
+}
+
+func addConntrackBuff(buff []byte, conntrack Conntrack) ([]byte, error) {
+	format := formatAt(buff)
+	if format < 2 {
+		conntrack.Action, conntrack.Switch = conntrackNoTrack, ""
+	}
+	if slices.ContainsFunc(parseConntracks(buff), conntrack.Equals) {
+		return nil, ErrConntrackAlreadyExists
+	}
+	return fmt.Appendf(buff, "%s\n", conntrack.formatAs(format)), nil
+}
+
+// removeConntrackBuff removes the first line matching conntrack, leaving the
+// other lines of buff untouched.
+func removeConntrackBuff(buff []byte, conntrack Conntrack) ([]byte, error) {
+	t := parseCST(buff)
+	i, _ := t.find(func(l logicalLine, state directiveState) bool {
+		c, ok := parseConntrackLine(l, &state, nil)
+		return ok && c.Equals(conntrack)
+	})
+	if i == -1 {
+		return nil, ErrConntrackNotFound
+	}
+	t.remove(i)
+	return t.Bytes(), nil
+}
+
+// updateConntrackBuff replaces the entry matching u.old with u.new, in the
+// layout of the ?FORMAT in effect at that line, leaving the other lines of
+// buff untouched.
+func updateConntrackBuff(buff []byte, u entryUpdate[Conntrack]) ([]byte, error) {
+	if !u.new.Equals(u.old) && slices.ContainsFunc(parseConntracks(buff), u.new.Equals) {
+		return nil, ErrConntrackAlreadyExists
+	}
+
+	t := parseCST(buff)
+	i, state := t.find(func(l logicalLine, state directiveState) bool {
+		c, ok := parseConntrackLine(l, &state, nil)
+		return ok && c.Equals(u.old)
+	})
+	if i == -1 {
+		return nil, ErrConntrackNotFound
+	}
+	conntrack := u.new
+	conntrack.Comment = t.entryComment(i, state, conntrack.Comment)
+	t.replace(i, conntrack.formatAs(state.format))
+	return t.Bytes(), nil
+}
+
+func parseConntracks(data []byte) []Conntrack {
+	return parseConntracksLines(tokenize(data), nil)
+}
+
+func parseConntracksLines(lines []logicalLine, issues *parseIssues) (conntracks []Conntrack) {
+	var state directiveState
+	for _, l := range lines {
+		if c, ok := parseConntrackLine(l, &state, issues); ok {
+			conntracks = append(conntracks, c)
+		}
+	}
+	return
+}
+
+// parseConntrackLine parses the entry on l in the layout selected by the
+// ?FORMAT of state, updating state if l is a directive.
+func parseConntrackLine(l logicalLine, state *directiveState, issues *parseIssues) (Conntrack, bool) {
+	if state.format < 2 {
+		parts, ok := entryColumns(l, state, conntrackFormat1Columns, 2, issues)
+		if !ok {
+			return Conntrack{}, false
+		}
+		parts = conntrackParts(parts, conntrackFormat1Columns)
+		return Conntrack{
+			Action:   conntrackNoTrack,
+			Source:   parts[0],
+			Dest:     parts[1],
+			Proto:    parts[2],
+			DPort:    parts[3],
+			SPort:    parts[4],
+			User:     parts[5],
+			Comment:  state.comment,
+			Location: l.location(),
+		}, true
+	}
+	parts, ok := entryColumns(l, state, conntrackColumns, 3, issues)
+	if !ok {
+		return Conntrack{}, false
+	}
+	parts = conntrackParts(parts, conntrackColumns)
+	return Conntrack{
+		Action:   parts[0],
+		Source:   parts[1],
+		Dest:     parts[2],
+		Proto:    parts[3],
+		DPort:    parts[4],
+		SPort:    parts[5],
+		User:     parts[6],
+		Switch:   parts[7],
+		Comment:  state.comment,
+		Location: l.location(),
+	}, true
+}
+
+// conntrackParts pads parts to the columns of names, with the "-"
+// placeholders read as empty columns.
+func conntrackParts(parts, names []string) []string {
+	parts = append(parts, make([]string, max(len(names)-len(parts), 0))...)
+	for i, p := range parts {
+		if p == "-" {
+			parts[i] = ""
+		}
+	}
+	return parts
+}
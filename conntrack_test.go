@@ -0,0 +1,83 @@
+package goshorewall
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+const conntrackFormats01 = `#SOURCE	DEST	PROTO	DPORT	SPORT	USER
+net:P	fw	udp	53
+?FORMAT 3
+#ACTION	SOURCE	DEST	PROTO	DPORT	SPORT	USER	SWITCH
+CT:helper:ftp:P	net	-	tcp	21
+DROP:P	net:10.0.0.1	-	-	-	-	-	blacklist
+`
+
+func TestParseConntracks_Format(t *testing.T) {
+	conntracks := parseConntracks([]byte(conntrackFormats01))
+	assert.Equal(t, []Conntrack{
+		{Action: "NOTRACK", Source: "net:P", Dest: "fw", Proto: "udp", DPort: "53"},
+		{Action: "CT:helper:ftp:P", Source: "net", Proto: "tcp", DPort: "21"},
+		{Action: "DROP:P", Source: "net:10.0.0.1", Switch: "blacklist"},
+	}, conntracks)
+
+	assert.Equal(t, "NOTRACK\tnet:P\tfw\tudp\t53", conntracks[0].Format())
+	assert.Equal(t, "net:P\tfw\tudp\t53", conntracks[0].formatAs(1))
+	assert.Equal(t, "DROP:P\tnet:10.0.0.1\t-\t-\t-\t-\t-\tblacklist", conntracks[2].Format())
+}
+
+func TestAddConntrackBuff_Format(t *testing.T) {
+	conntrack := Conntrack{Action: "CT:notrack", Source: "loc", Dest: "fw", Proto: "udp", DPort: "123"}
+	buff, err := addConntrackBuff([]byte(conntrackFormats01), conntrack)
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, conntrackFormats01+"CT:notrack\tloc\tfw\tudp\t123\n", string(buff))
+
+	_, err = addConntrackBuff(buff, conntrack)
+	assert.ErrorIs(t, err, ErrConntrackAlreadyExists)
+
+	buff, err = addConntrackBuff([]byte("net\tfw\tudp\t53\n"), conntrack)
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, "net\tfw\tudp\t53\nloc\tfw\tudp\t123\n", string(buff))
+	assert.Equal(t, "NOTRACK", parseConntracks(buff)[1].Action)
+}
+
+func TestRemoveConntrackBuff(t *testing.T) {
+	buff, err := removeConntrackBuff([]byte(conntrackFormats01), Conntrack{Action: "CT:helper:ftp:P", Source: "net", Proto: "tcp", DPort: "21"})
+	assert.NoError(t, err, "expected no error")
+	assert.Len(t, parseConntracks(buff), 2)
+
+	_, err = removeConntrackBuff(buff, Conntrack{Action: "CT:helper:ftp:P", Source: "net", Proto: "tcp", DPort: "21"})
+	assert.ErrorIs(t, err, ErrConntrackNotFound)
+}
+
+func TestUpdateConntrackBuff_Format(t *testing.T) {
+	from := Conntrack{Action: "NOTRACK", Source: "net:P", Dest: "fw", Proto: "udp", DPort: "53"}
+	to := from
+	to.DPort = "53,123"
+	buff, err := updateConntrackBuff([]byte(conntrackFormats01), entryUpdate[Conntrack]{old: from, new: to})
+	assert.NoError(t, err, "expected no error")
+	assert.Contains(t, string(buff), "\nnet:P\tfw\tudp\t53,123\n?FORMAT 3\n")
+}
+
+func TestAppFormatUpdateBuff_Conntrack(t *testing.T) {
+	id := uuid.NewString()
+	conntrack := Conntrack{Action: "CT:notrack:P", Source: "net", Proto: "udp", DPort: "53"}
+	buff, err := appFormatUpdateBuff(id, []byte("net\tfw\tudp\t123\n"), 3, addConntrackBuff, conntrack)
+	assert.NoError(t, err, "adding conntrack entry")
+	assert.Equal(t, "net\tfw\tudp\t123\n"+
+		string(commentIdentifierLineStart(id))+
+		"?FORMAT 3\nCT:notrack:P\tnet\t-\tudp\t53\n?FORMAT 1\n"+
+		string(commentIdentifierEnd(id)), string(buff))
+	assert.Equal(t, []Conntrack{
+		{Action: "NOTRACK", Source: "net", Dest: "fw", Proto: "udp", DPort: "123"},
+		conntrack,
+	}, parseConntracks(buff))
+
+	buff, err = appFormatUpdateBuff(id, buff, 3, removeConntrackBuff, conntrack)
+	assert.NoError(t, err, "removing conntrack entry")
+	assert.Equal(t, "net\tfw\tudp\t123\n"+
+		string(commentIdentifierLineStart(id))+
+		string(commentIdentifierEnd(id)), string(buff))
+}
@@ -0,0 +1,131 @@
+package goshorewall
+
+import (
+	"bytes"
+	"slices"
+)
+
+// cstNode is a node of the concrete syntax tree of a columnar file.
+type cstNode struct {
+	// raw holds the exact bytes of the node, including the trailing newline.
+	raw []byte
+	// line is the logical line of an entry or a directive. It is nil for
+	// trivia: blank lines and comment only lines.
+	line *logicalLine
+}
+
+// cst is the concrete syntax tree of a columnar file: the concatenation of
+// the raw bytes of its nodes is the original buffer, so editing a node
+// leaves every other byte of the file untouched.
+type cst struct {
+	nodes []cstNode
+}
+
+// entryUpdate is the item of the update functions: the entry matching old is
+// replaced by new.
+type entryUpdate[T any] struct {
+	old T
+	new T
+}
+
+func parseCST(buff []byte) *cst {
+	t := &cst{}
+	offset := 0
+	for _, l := range tokenize(buff) {
+		if l.start > offset {
+			t.nodes = append(t.nodes, cstNode{raw: buff[offset:l.start]})
+		}
+		t.nodes = append(t.nodes, cstNode{raw: buff[l.start:l.end], line: &l})
+		offset = l.end
+	}
+	if offset < len(buff) {
+		t.nodes = append(t.nodes, cstNode{raw: buff[offset:]})
+	}
+	return t
+}
+
+func (t *cst) Bytes() []byte {
+	var b bytes.Buffer
+	for _, n := range t.nodes {
+		b.Write(n.raw)
+	}
+	return b.Bytes()
+}
+
+// find returns the index of the first entry node for which match returns
+// true, and the directive state in effect at that node, or -1.
+func (t *cst) find(match func(l logicalLine, state directiveState) bool) (int, directiveState) {
+	var state directiveState
+	for i, n := range t.nodes {
+		if n.line == nil || state.update(*n.line) {
+			continue
+		}
+		if match(*n.line, state) {
+			return i, state
+		}
+	}
+	return -1, state
+}
+
+// scoped reports whether entry node i is wrapped in ?COMMENT directives that
+// only apply to it, as written by formatWithComment.
+func (t *cst) scoped(i int) bool {
+	return i > 0 && i+1 < len(t.nodes) &&
+		t.nodes[i-1].line != nil && t.nodes[i-1].line.directiveName() == "COMMENT" && t.nodes[i-1].line.directiveArgs() != "" &&
+		t.nodes[i+1].line != nil && t.nodes[i+1].line.directiveName() == "COMMENT" && t.nodes[i+1].line.directiveArgs() == ""
+}
+
+// span returns the range of nodes making up entry node i, including its
+// scoped ?COMMENT directives.
+func (t *cst) span(i int) (int, int) {
+	if t.scoped(i) {
+		return i - 1, i + 2
+	}
+	return i, i + 1
+}
+
+// remove removes entry node i and its scoped ?COMMENT directives.
+func (t *cst) remove(i int) {
+	start, end := t.span(i)
+	t.nodes = slices.Delete(t.nodes, start, end)
+}
+
+// replace replaces entry node i and its scoped ?COMMENT directives with
+// text, which is the output of Format.
+func (t *cst) replace(i int, text string) {
+	start, end := t.span(i)
+	raw := []byte(text)
+	if bytes.HasSuffix(t.nodes[end-1].raw, []byte("\n")) {
+		raw = append(raw, '\n')
+	}
+	t.nodes = slices.Replace(t.nodes, start, end, cstNode{raw: raw})
+}
+
+// entryComment returns the comment to format the replacement of entry node i
+// with. A comment inherited from a ?COMMENT directive covering several
+// entries is not repeated.
+func (t *cst) entryComment(i int, state directiveState, comment string) string {
+	if !t.scoped(i) && comment == state.comment {
+		return ""
+	}
+	return comment
+}
+
+// removeAllBuff removes every entry of buff matching match, leaving the other
+// lines untouched, and returns the entries removed. parse is the parser of
+// the lines of the file.
+func removeAllBuff[T any](buff []byte, parse func([]logicalLine, *parseIssues) []T, match func(T) bool) ([]byte, []T) {
+	removed := slices.DeleteFunc(parse(tokenize(buff), nil), func(e T) bool {
+		return !match(e)
+	})
+	t := parseCST(buff)
+	for {
+		i, _ := t.find(func(l logicalLine, _ directiveState) bool {
+			return slices.ContainsFunc(parse([]logicalLine{l}, nil), match)
+		})
+		if i == -1 {
+			return t.Bytes(), removed
+		}
+		t.remove(i)
+	}
+}
@@ -0,0 +1,88 @@
+package goshorewall
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+const zonesForeign01 = `#ZONE   TYPE      OPTIONS
+fw      firewall
+
+# The internet
+net     ipv4      # uplink
+?COMMENT lan
+loc     ipv4
+?COMMENT
+dmz     ipv4`
+
+func TestCST_RoundTrip(t *testing.T) {
+	for _, data := range []string{zonesForeign01, rulesSections01, rulesConditional01, ""} {
+		assert.Equal(t, data, string(parseCST([]byte(data)).Bytes()))
+	}
+}
+
+func TestRemoveZoneBuff_Lossless(t *testing.T) {
+	b, err := removeZoneBuff([]byte(zonesForeign01), "fw")
+	assert.NoError(t, err)
+	assert.Equal(t, "#ZONE   TYPE      OPTIONS\n\n# The internet\nnet     ipv4      # uplink\n?COMMENT lan\nloc     ipv4\n?COMMENT\ndmz     ipv4", string(b))
+
+	b, err = removeZoneBuff([]byte(zonesForeign01), "loc")
+	assert.NoError(t, err)
+	assert.Equal(t, "#ZONE   TYPE      OPTIONS\nfw      firewall\n\n# The internet\nnet     ipv4      # uplink\ndmz     ipv4", string(b))
+
+	_, err = removeZoneBuff([]byte(zonesForeign01), "vpn")
+	assert.ErrorIs(t, err, ErrZoneNotFound)
+}
+
+func TestUpdateZoneBuff_Lossless(t *testing.T) {
+	b, err := updateZoneBuff([]byte(zonesForeign01), entryUpdate[Zone]{old: Zone{Name: "dmz"}, new: Zone{Name: "dmz", Type: "ipv6"}})
+	assert.NoError(t, err)
+	assert.Equal(t, zonesForeign01[:len(zonesForeign01)-len("dmz     ipv4")]+"dmz\tipv6", string(b))
+
+	b, err = updateZoneBuff([]byte(zonesForeign01), entryUpdate[Zone]{old: Zone{Name: "loc"}, new: Zone{Name: "lan", Type: "ipv4", Comment: "lan"}})
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "?COMMENT lan\nlan\tipv4\n?COMMENT\n")
+	assert.Contains(t, string(b), "# The internet\nnet     ipv4      # uplink\n")
+
+	_, err = updateZoneBuff([]byte(zonesForeign01), entryUpdate[Zone]{old: Zone{Name: "loc"}, new: Zone{Name: "net", Type: "ipv4"}})
+	assert.ErrorIs(t, err, ErrZoneAlreadyExists)
+}
+
+func TestUpdateRuleBuff_Lossless(t *testing.T) {
+	old := Rule{Action: "ACCEPT", Source: "net", Destination: "fw", Protocol: "tcp", Dport: "22", Section: SectionNew}
+	updated := old
+	updated.Dport = "2222"
+
+	b, err := updateRuleBuff([]byte(rulesSections01), entryUpdate[Rule]{old: old, new: updated})
+	assert.NoError(t, err)
+	assert.Equal(t, rulesSections01[:len(rulesSections01)-len("ACCEPT\tnet\tfw\ttcp\t22\n")]+updated.Format()+"\n", string(b))
+
+	updated.Section = SectionEstablished
+	b, err = updateRuleBuff([]byte(rulesSections01), entryUpdate[Rule]{old: old, new: updated})
+	assert.NoError(t, err)
+	rules := parseRules(b)
+	assert.Len(t, rules, 3)
+	assert.Equal(t, SectionEstablished, rules[2].Section)
+}
+
+func TestAppUpdateRule(t *testing.T) {
+	rules := "ACCEPT\tall\tall\ticmp\n"
+	app := &App{identifier: uuid.New()}
+	rule := Rule{Action: "ACCEPT", Source: "net", Destination: "fw", Protocol: "tcp", Dport: "80"}
+
+	b, err := addAppRuleBuff(app.ID(), []byte(rules), rule)
+	assert.NoError(t, err)
+
+	updated := rule
+	updated.Dport = "443"
+	b, err = updateAppRuleBuff(app.ID(), b, entryUpdate[Rule]{old: rule, new: updated})
+	assert.NoError(t, err)
+
+	appRules, err := getAppRulesBuff(app.ID(), b)
+	assert.NoError(t, err)
+	assert.Len(t, appRules, 1)
+	assert.Equal(t, "443", appRules[0].Dport)
+	assert.True(t, len(b) > len(rules) && string(b[:len(rules)]) == rules)
+}
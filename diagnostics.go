@@ -0,0 +1,131 @@
+package goshorewall
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ParseError describes an entry or a directive that could not be parsed, or
+// that is semantically invalid.
+type ParseError struct {
+	// File is empty for entries parsed from a buffer.
+	File string
+	Line int
+	// Column is the 1-based index of the offending column, or 0 if the
+	// problem concerns the whole line.
+	Column int
+	Reason string
+}
+
+func (e ParseError) Error() string {
+	var b strings.Builder
+	if e.File != "" {
+		b.WriteString(e.File + ":")
+	}
+	fmt.Fprintf(&b, "%d:", e.Line)
+	if e.Column > 0 {
+		fmt.Fprintf(&b, " column %d:", e.Column)
+	}
+	b.WriteString(" " + e.Reason)
+	return b.String()
+}
+
+// ParseErrors is the error returned in strict mode, listing every problem
+// found in the files read.
+type ParseErrors []ParseError
+
+func (e ParseErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, pe := range e {
+		msgs[i] = pe.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e ParseErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, pe := range e {
+		errs[i] = pe
+	}
+	return errs
+}
+
+// parseIssues collects the problems found by the parsers. A nil *parseIssues
+// discards them.
+type parseIssues struct {
+	errs []ParseError
+	// file is the file of the problems found on lines tokenized from a
+	// buffer, such as the block of an App.
+	file string
+}
+
+func (p *parseIssues) add(l logicalLine, column int, format string, args ...any) {
+	if p == nil {
+		return
+	}
+	file := l.file
+	if file == "" {
+		file = p.file
+	}
+	p.errs = append(p.errs, ParseError{
+		File:   file,
+		Line:   l.line,
+		Column: column,
+		Reason: fmt.Sprintf(format, args...),
+	})
+}
+
+// result returns the entries and the warnings of a read in lenient mode, or
+// the problems as a ParseErrors error in strict mode.
+func result[T any](entries []T, issues *parseIssues, strict bool) ([]T, []ParseError, error) {
+	if strict && len(issues.errs) > 0 {
+		return nil, nil, ParseErrors(issues.errs)
+	}
+	return entries, issues.errs, nil
+}
+
+// knownDirectives are the compiler directives understood by Shorewall.
+var knownDirectives = []string{
+	"INCLUDE", "SECTION", "COMMENT", "FORMAT",
+	"IF", "ELSIF", "ELSE", "ENDIF",
+	"SET", "RESET", "REQUIRE", "WARNING", "INFO", "ERROR",
+	"BEGIN", "END", "PERL", "SHELL",
+}
+
+// entryColumns updates state with l and returns the columns of the entry l.
+// It reports false if l is a directive or if the entry has less than
+// minColumns columns. Problems are recorded in issues.
+func entryColumns(l logicalLine, state *directiveState, names []string, minColumns int, issues *parseIssues) ([]string, bool) {
+	if state.update(l) {
+		switch name := l.directiveName(); {
+		case !slices.Contains(knownDirectives, name):
+			issues.add(l, 1, "unknown directive %q", l.fields[0])
+		case name == "SECTION":
+			if err := validateSection(state.section); err != nil {
+				issues.add(l, 2, "%v", err)
+			}
+		case name == "FORMAT":
+			if state.format < 1 {
+				issues.add(l, 2, "invalid format %q", l.directiveArgs())
+			}
+		}
+		return nil, false
+	}
+
+	parts, err := expandColumns(l.fields, names)
+	if err != nil {
+		issues.add(l, 0, "%v", err)
+	}
+	if len(parts) < minColumns {
+		issues.add(l, len(parts)+1, "expected at least %d columns, found %d", minColumns, len(parts))
+		return nil, false
+	}
+	for i := 0; i < minColumns; i++ {
+		if parts[i] == "" {
+			issues.add(l, i+1, "missing %s column", strings.ToUpper(names[i]))
+			return nil, false
+		}
+	}
+	return parts, true
+}
@@ -0,0 +1,92 @@
+package goshorewall
+
+import (
+	"errors"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const rulesMalformed01 = `ACCEPT	net	fw	tcp	22
+ACCEPT	net
+?SECTION BOGUS
+?FROB
+ACCEPT	{ source=net, dest=fw, frob=1 }
+ACCEPT	{ dest=fw, proto=tcp }
+`
+
+func TestParseRules_Issues(t *testing.T) {
+	issues := &parseIssues{}
+	rules := parseRuleLines(tokenize([]byte(rulesMalformed01)), 3, directiveState{}, issues)
+	assert.Len(t, rules, 2)
+
+	assert.Equal(t, []ParseError{
+		{Line: 2, Column: 3, Reason: "expected at least 3 columns, found 2"},
+		{Line: 3, Column: 2, Reason: `invalid rules section: "BOGUS"`},
+		{Line: 4, Column: 1, Reason: `unknown directive "?FROB"`},
+		{Line: 5, Column: 0, Reason: `unknown column: "frob=1"`},
+		{Line: 6, Column: 2, Reason: "missing SOURCE column"},
+	}, issues.errs)
+}
+
+func TestParsePolicies_Issues(t *testing.T) {
+	issues := &parseIssues{}
+	lines := tokenize([]byte("net\tall\tDROP:Drop\tinfo\nloc\tnet\tALLOW\n"))
+	for i := range lines {
+		lines[i].file = "/etc/shorewall/policy"
+	}
+	policies := parsePoliciesLines(lines, issues)
+	assert.Len(t, policies, 1)
+
+	_, warnings, err := result(policies, issues, false)
+	assert.NoError(t, err)
+	assert.Len(t, warnings, 1)
+
+	_, _, err = result(policies, issues, true)
+	var perrs ParseErrors
+	assert.True(t, errors.As(err, &perrs))
+	assert.Len(t, perrs, 1)
+	assert.EqualError(t, err, `/etc/shorewall/policy:2: column 3: invalid policy "ALLOW"`)
+}
+
+func TestApp_ReadWithOptions(t *testing.T) {
+	lockDirPath = "/tmp/.goshorewall"
+	dir := t.TempDir()
+	app, err := NewAppWithBasePath(dir)
+	assert.NoError(t, err)
+
+	zonesPath := path.Join(dir, zonesFile)
+	writeFile(t, zonesPath, "fw\tfirewall\nbroken\n"+string(wrapBuffWithAppIdentifier([]byte("net\tipv4\ndmz\n"), app.ID())))
+	rulesPath := path.Join(dir, rulesFile)
+	writeFile(t, rulesPath, "?SECTION NEW\n"+string(wrapBuffWithAppIdentifier([]byte("ACCEPT\tnet\tfw\tssh\nACCEPT\tnet\n"), sectionBlockID(app.ID(), SectionNew))))
+
+	zones, warnings, err := app.ZonesWithOptions(ReadOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []Zone{{Name: "net", Type: "ipv4"}}, zones)
+	assert.Equal(t, []ParseError{{File: zonesPath, Line: 5, Column: 2, Reason: "expected at least 2 columns, found 1"}}, warnings)
+
+	_, _, err = app.ZonesWithOptions(ReadOptions{Strict: true})
+	var perrs ParseErrors
+	assert.True(t, errors.As(err, &perrs))
+	assert.EqualError(t, err, zonesPath+":5: column 2: expected at least 2 columns, found 1")
+
+	zones, err = app.Zones()
+	assert.NoError(t, err)
+	assert.Len(t, zones, 1)
+
+	rules, warnings, err := app.RulesWithOptions(ReadOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, rules, 1)
+	assert.Equal(t, []ParseError{{File: rulesPath, Line: 4, Column: 3, Reason: "expected at least 3 columns, found 2"}}, warnings)
+
+	_, _, err = app.RulesWithOptions(ReadOptions{Strict: true})
+	assert.True(t, errors.As(err, &perrs))
+}
+
+func TestParseActions_Issues(t *testing.T) {
+	issues := &parseIssues{}
+	actions := parseActionsLines(tokenize([]byte("Limit\tnoinline\n1Bad\n?FROB\n")), issues)
+	assert.Equal(t, []Action{{Name: "Limit", Options: []string{"noinline"}}}, actions)
+	assert.Len(t, issues.errs, 2)
+}
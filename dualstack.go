@@ -0,0 +1,99 @@
+package goshorewall
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrDualStackFamily       = errors.New("dual-stack apps must manage IPv4 and IPv6 respectively")
+	ErrRuleNotFamilyAgnostic = errors.New("rule contains family specific addresses")
+)
+
+// DualStackApp applies family-agnostic configuration to both the shorewall
+// and the shorewall6 configuration trees. A family-agnostic entry references
+// zones, interfaces and ports but no IP address literal.
+type DualStackApp struct {
+	v4 *App
+	v6 *App
+}
+
+// NewDualStackApp creates a DualStackApp from an IPv4 and an IPv6 App.
+func NewDualStackApp(v4, v6 *App) (*DualStackApp, error) {
+	if v4 == nil || v6 == nil || v4.Family() != IPv4 || v6.Family() != IPv6 {
+		return nil, ErrDualStackFamily
+	}
+	return &DualStackApp{v4: v4, v6: v6}, nil
+}
+
+// IPv4 returns the App managing the shorewall configuration.
+func (d *DualStackApp) IPv4() *App {
+	return d.v4
+}
+
+// IPv6 returns the App managing the shorewall6 configuration.
+func (d *DualStackApp) IPv6() *App {
+	return d.v6
+}
+
+// AddRule adds a family-agnostic rule to both configurations. If the rule
+// cannot be added to the IPv6 configuration it is removed from the IPv4 one.
+func (d *DualStackApp) AddRule(rule Rule) error {
+	if err := checkRuleFamilyAgnostic(rule); err != nil {
+		return err
+	}
+	if err := d.v4.AddRule(rule); err != nil {
+		return fmt.Errorf("failed to add rule to %s: %w", IPv4, err)
+	}
+	if err := d.v6.AddRule(rule); err != nil {
+		err = fmt.Errorf("failed to add rule to %s: %w", IPv6, err)
+		if rerr := d.v4.RemoveRule(rule); rerr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to roll back rule in %s: %w", IPv4, rerr))
+		}
+		return err
+	}
+	return nil
+}
+
+// RemoveRule removes a rule from both configurations.
+func (d *DualStackApp) RemoveRule(rule Rule) error {
+	return errors.Join(d.v4.RemoveRule(rule), d.v6.RemoveRule(rule))
+}
+
+// AddPolicy adds a policy to both configurations. If the policy cannot be
+// added to the IPv6 configuration it is removed from the IPv4 one.
+func (d *DualStackApp) AddPolicy(policy Policy) error {
+	if err := d.v4.AddPolicy(policy); err != nil {
+		return fmt.Errorf("failed to add policy to %s: %w", IPv4, err)
+	}
+	if err := d.v6.AddPolicy(policy); err != nil {
+		err = fmt.Errorf("failed to add policy to %s: %w", IPv6, err)
+		if rerr := d.v4.RemovePolicy(policy); rerr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to roll back policy in %s: %w", IPv4, rerr))
+		}
+		return err
+	}
+	return nil
+}
+
+// RemovePolicy removes a policy from both configurations.
+func (d *DualStackApp) RemovePolicy(policy Policy) error {
+	return errors.Join(d.v4.RemovePolicy(policy), d.v6.RemovePolicy(policy))
+}
+
+// Reload reloads both shorewall and shorewall6.
+func (d *DualStackApp) Reload() error {
+	if err := d.v4.Reload(); err != nil {
+		return err
+	}
+	return d.v6.Reload()
+}
+
+func checkRuleFamilyAgnostic(rule Rule) error {
+	for _, addr := range []string{rule.Source, rule.Destination, rule.Origdest} {
+		if containsIPLiteral(addr) {
+			return fmt.Errorf("%w: %q", ErrRuleNotFamilyAgnostic, addr)
+		}
+	}
+	return nil
+}
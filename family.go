@@ -0,0 +1,46 @@
+package goshorewall
+
+// Family is the address family managed by a Shorewall configuration tree:
+// IPv4 is managed by shorewall, IPv6 by shorewall6.
+type Family int
+
+const (
+	IPv4 Family = iota
+	IPv6
+)
+
+func (f Family) String() string {
+	if f == IPv6 {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+// program returns the name of the Shorewall program managing the family.
+func (f Family) program() string {
+	if f == IPv6 {
+		return "shorewall6"
+	}
+	return "shorewall"
+}
+
+func (f Family) configPath() string {
+	if f == IPv6 {
+		return shorewall6ConfigPath
+	}
+	return shorewallConfigPath
+}
+
+func (f Family) binary() string {
+	if f == IPv6 {
+		return shorewall6Binary
+	}
+	return shorewallBinary
+}
+
+func (f Family) confFile() string {
+	if f == IPv6 {
+		return conf6File
+	}
+	return confFile
+}
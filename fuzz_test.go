@@ -0,0 +1,204 @@
+package goshorewall
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sampleFiles returns the contents of the sample configuration files of
+// testdata/shorewall whose name matches the pattern name.
+func sampleFiles(t testing.TB, name string) [][]byte {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join("testdata", "shorewall", "*", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files [][]byte
+	for _, p := range paths {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, b)
+	}
+	return files
+}
+
+func addSeeds(f *testing.F, names ...string) {
+	for _, name := range names {
+		for _, b := range sampleFiles(f, name) {
+			f.Add(b)
+		}
+	}
+}
+
+// checkRoundTrip checks that every entry survives a Format and parse cycle,
+// and that formatting is stable.
+func checkRoundTrip[T any](t *testing.T, entries []T, format func(T) string, parse func([]byte) []T, equals func(T, T) bool) {
+	t.Helper()
+	for _, e := range entries {
+		text := format(e)
+		parsed := parse([]byte(text + "\n"))
+		if !assert.Len(t, parsed, 1, "%q", text) {
+			continue
+		}
+		assert.True(t, equals(e, parsed[0]), "%q: %+v != %+v", text, e, parsed[0])
+		assert.Equal(t, text, format(parsed[0]))
+	}
+}
+
+func FuzzParseRules(f *testing.F) {
+	addSeeds(f, "rules")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		rules := parseRules(data)
+		for i := range rules {
+			rules[i] = rules[i].fillEmpty()
+		}
+		checkRoundTrip(t, rules, Rule.Format, parseRules, func(a, b Rule) bool {
+			return a.formatColumns() == b.formatColumns()
+		})
+	})
+}
+
+func FuzzParseZones(f *testing.F) {
+	addSeeds(f, "zones")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkRoundTrip(t, parseZones(data), Zone.Format, parseZones, func(a, b Zone) bool {
+			return a.Name == b.Name && a.Type == b.Type
+		})
+	})
+}
+
+func FuzzParseInterfaces(f *testing.F) {
+	addSeeds(f, "interfaces")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkRoundTrip(t, parseInterfaces(data), Interface.Format, parseInterfaces, Interface.Equals)
+	})
+}
+
+func FuzzParsePolicies(f *testing.F) {
+	addSeeds(f, "policy")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkRoundTrip(t, parsePolicies(data), Policy.Format, parsePolicies, Policy.Equals)
+	})
+}
+
+func FuzzParseSnats(f *testing.F) {
+	addSeeds(f, "snat")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkRoundTrip(t, parseSnats(data), Snat.Format, parseSnats, Snat.Equals)
+	})
+}
+
+func FuzzParseProxyArps(f *testing.F) {
+	addSeeds(f, "proxyarp")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkRoundTrip(t, parseProxyArps(data), ProxyArp.Format, parseProxyArps, ProxyArp.Equals)
+	})
+}
+
+func FuzzParseHosts(f *testing.F) {
+	addSeeds(f, "hosts")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkRoundTrip(t, parseHosts(data), Host.Format, parseHosts, Host.Equals)
+	})
+}
+
+func FuzzParseActions(f *testing.F) {
+	addSeeds(f, "actions")
+	f.Add([]byte("#ACTION\tOPTIONS\nA_Drop\tinline,noinline\nSSHKnock\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		checkRoundTrip(t, parseActions(data), Action.Format, parseActions, func(a, b Action) bool {
+			return a.Name == b.Name && slices.Equal(a.Options, b.Options)
+		})
+	})
+}
+
+// FuzzParseMacroBody checks that a macro body survives formatMacroBody and
+// parseMacroRules.
+func FuzzParseMacroBody(f *testing.F) {
+	addSeeds(f, "macro.*")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		body := parseMacroRules(data)
+		text := formatMacroBody(body)
+		parsed := parseMacroRules(text)
+		if !assert.Len(t, parsed, len(body), "%q", text) {
+			return
+		}
+		for i := range body {
+			a, b := fillEmptyMacroRule(body[i]).formatColumns(), fillEmptyMacroRule(parsed[i]).formatColumns()
+			assert.Equal(t, a, b, "%q", text)
+		}
+		assert.Equal(t, text, formatMacroBody(parsed))
+	})
+}
+
+func FuzzParseConfig(f *testing.F) {
+	f.Add([]byte("STARTUP_ENABLED=Yes\nLOGFORMAT=\"%s %s \"\nCONFIG_PATH=${CONFDIR}/shorewall:${SHAREDIR}/shorewall\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		assert.True(t, bytes.Equal(data, ParseConfig(data).Bytes()))
+	})
+}
+
+func FuzzCST(f *testing.F) {
+	addSeeds(f, "rules", "zones", "interfaces", "hosts", "policy", "snat", "proxyarp", "actions", "macro.*")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		assert.True(t, bytes.Equal(data, parseCST(data).Bytes()))
+		for _, l := range tokenize(data) {
+			if l.start < 0 || l.start > l.end || l.end > len(data) {
+				t.Fatalf("invalid span %d-%d for %d bytes", l.start, l.end, len(data))
+			}
+		}
+	})
+}
+
+func FuzzExtractApplicationSubsetBufferIndexes(f *testing.F) {
+	const id = "9b2f0c0e-7b1e-4a57-9f0c-1f6f1d5c9a11"
+	f.Add([]byte(""))
+	f.Add(wrapBuffWithAppIdentifier([]byte("net\teth0\n"), id))
+	f.Add(append([]byte("fw\tfirewall\n"), wrapBuffWithAppIdentifier([]byte("net\tipv4\n"), id)...))
+	f.Add(commentIdentifierLineStart(id))
+	f.Add(append(commentIdentifierEnd(id), commentIdentifierLineStart(id)...))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		is, ie, found, err := extractApplicationSubsetBufferIndexes(id, data)
+		if err != nil {
+			return
+		}
+		if is > ie || ie > uint(len(data)) {
+			t.Fatalf("invalid indexes %d-%d for %d bytes", is, ie, len(data))
+		}
+		if found {
+			assert.True(t, bytes.HasSuffix(data[:is], commentIdentifierLineStart(id)))
+		}
+	})
+}
+
+func TestRoundTrip_Samples(t *testing.T) {
+	for _, b := range sampleFiles(t, "rules") {
+		rules := parseRules(b)
+		assert.NotEmpty(t, rules)
+		for _, r := range rules {
+			parsed := parseRules([]byte(r.fillEmpty().Format() + "\n"))
+			assert.Len(t, parsed, 1)
+			r.Section, r.Location = "", Location{}
+			assert.True(t, r.Equals(parsed[0]), "%+v != %+v", r, parsed[0])
+		}
+	}
+	for _, b := range sampleFiles(t, "zones") {
+		zones := parseZones(b)
+		assert.NotEmpty(t, zones)
+		for _, z := range zones {
+			assert.Equal(t, []Zone{z}, parseZones([]byte(z.Format()+"\n")))
+		}
+	}
+	for _, b := range sampleFiles(t, "snat") {
+		snats := parseSnats(b)
+		assert.Equal(t, "10.0.0.0/8,169.254.0.0/16,172.16.0.0/12,192.168.0.0/16", snats[0].Source)
+		assert.Equal(t, "eth0", snats[0].Destination)
+	}
+}
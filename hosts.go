@@ -0,0 +1,92 @@
+package goshorewall
+
+import (
+	"strings"
+)
+
+// Host is an entry of the hosts file, adding the hosts reached through an
+// interface to a zone.
+type Host struct {
+	Zone string
+	// Hosts is the HOSTS column, interface:address[,...].
+	Hosts   string
+	Options string
+	// Comment is the ?COMMENT annotation of the entry. It is not considered
+	// when comparing entries.
+	Comment string
+	// Location is the file and line the entry was read from.
+	Location Location
+}
+
+func (h Host) Compare(other Host) int {
+	if cmp := strings.Compare(h.Zone, other.Zone); cmp != 0 {
+		return cmp
+	}
+	return strings.Compare(h.Hosts, other.Hosts)
+}
+
+func (h Host) Equals(other Host) bool {
+	return h.Zone == other.Zone && h.Hosts == other.Hosts && h.Options == other.Options
+}
+
+func (h Host) Format() string {
+	return formatWithComment(h.Comment, formatColumns(hostColumns, h.Zone, h.Hosts, h.Options))
+}
+
+// FormatNamed formats the host in the column=value form.
+func (h Host) FormatNamed() string {
+	return formatWithComment(h.Comment, formatNamedColumns(hostColumns, h.Zone, h.Hosts, h.Options))
+}
+
+// Interface returns the interface of the HOSTS column.
+func (h Host) Interface() string {
+	iface, _, _ := strings.Cut(h.Hosts, ":")
+	return iface
+}
+
+func Hosts() ([]Host, error) {
+	entries, _, err := HostsWithOptions(ReadOptions{})
+	return entries, err
+}
+
+// HostsWithOptions reads the hosts file, following includes and evaluating
+// conditional directives as configured by opts. In lenient mode the lines
+// that could not be parsed are returned as warnings, in strict mode they
+// are returned as a ParseErrors error.
+func HostsWithOptions(opts ReadOptions) ([]Host, []ParseError, error) {
+	lines, err := loadConfigFile(hostsFile, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	issues := &parseIssues{}
+	return result(parseHostsLines(lines, issues), issues, opts.Strict)
+}
+
+func getHostsBuff(buff []byte) ([]Host, error) {
+	return parseHosts(buff), nil
+}
+
+func parseHosts(data []byte) []Host {
+	return parseHostsLines(tokenize(data), nil)
+}
+
+func parseHostsLines(lines []logicalLine, issues *parseIssues) (hosts []Host) {
+	var state directiveState
+	for _, l := range lines {
+		parts, ok := entryColumns(l, &state, hostColumns, 2, issues)
+		if !ok {
+			continue
+		}
+		host := Host{
+			Zone:     parts[0],
+			Hosts:    parts[1],
+			Comment:  state.comment,
+			Location: l.location(),
+		}
+		if len(parts) > 2 {
+			host.Options = parts[2]
+		}
+		hosts = append(hosts, host)
+	}
+	return
+}
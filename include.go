@@ -0,0 +1,171 @@
+package goshorewall
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+const (
+	shorewallConfDir  = "/etc"
+	shorewallShareDir = "/usr/share"
+)
+
+var (
+	ErrIncludeCycle    = errors.New("include cycle")
+	ErrIncludeNotFound = errors.New("included file not found")
+)
+
+// Location is the position of an entry in the Shorewall configuration files.
+// It is only set on entries read through the top-level functions, such as
+// Rules(), which follow INCLUDE directives.
+type Location struct {
+	File string
+	Line int
+}
+
+func (l Location) String() string {
+	return fmt.Sprintf("%s:%d", l.File, l.Line)
+}
+
+// location returns the location of a logical line read from a file, or the
+// zero Location for lines tokenized from a buffer.
+func (l logicalLine) location() Location {
+	if l.file == "" {
+		return Location{}
+	}
+	return Location{File: l.file, Line: l.line}
+}
+
+// fileLoader reads configuration files, replacing ?INCLUDE and INCLUDE
+// directives with the lines of the included files.
+type fileLoader struct {
+	configDir string
+	// configPath are the directories of the CONFIG_PATH option, searched
+	// after configDir for relative includes.
+	configPath []string
+	// stack holds the files being read, to detect include cycles.
+	stack []string
+	opts  ReadOptions
+}
+
+// newFileLoader creates a fileLoader for the configuration stored in
+// configDir, reading CONFIG_PATH from confFile if it exists. Unless opts.Raw
+// is set or opts.Params is given, the params file is read as well.
+func newFileLoader(configDir, confFile string, opts ReadOptions) (*fileLoader, error) {
+	ld := &fileLoader{configDir: configDir, opts: opts}
+
+	if !opts.Raw && opts.Params == nil {
+		params, err := readParams(path.Join(configDir, paramsFile))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		ld.opts.Params = params
+	}
+
+	buff, err := os.ReadFile(path.Join(configDir, confFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return ld, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, dir := range ParseConfig(buff).ConfigPath() {
+		dir = strings.NewReplacer("${CONFDIR}", shorewallConfDir, "$CONFDIR", shorewallConfDir,
+			"${SHAREDIR}", shorewallShareDir, "$SHAREDIR", shorewallShareDir).Replace(dir)
+		ld.configPath = append(ld.configPath, dir)
+	}
+	return ld, nil
+}
+
+// load reads the file at p and returns its logical lines, with included
+// files expanded in place. Unless the raw view is requested, the lines of
+// inactive conditional branches and the conditional directives are dropped.
+func (ld *fileLoader) load(p string) ([]logicalLine, error) {
+	if slices.Contains(ld.stack, p) {
+		return nil, fmt.Errorf("%w: %s", ErrIncludeCycle, strings.Join(append(ld.stack, p), " -> "))
+	}
+
+	buff, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	return ld.loadBuff(p, buff)
+}
+
+// loadBuff is load for the content buff of the file at p.
+func (ld *fileLoader) loadBuff(p string, buff []byte) ([]logicalLine, error) {
+	ld.stack = append(ld.stack, p)
+	defer func() {
+		ld.stack = ld.stack[:len(ld.stack)-1]
+	}()
+
+	cond := conditionals{opts: ld.opts}
+	var lines []logicalLine
+	for _, l := range tokenize(buff) {
+		l.file = p
+		if !ld.opts.Raw {
+			isCond, err := cond.update(l)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", l.location(), err)
+			}
+			if isCond || !cond.active() {
+				continue
+			}
+		}
+		if l.directiveName() != "INCLUDE" {
+			lines = append(lines, l)
+			continue
+		}
+
+		included, err := ld.resolve(l.directiveArgs())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", l.location(), err)
+		}
+		inc, err := ld.load(included)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, inc...)
+	}
+	if !ld.opts.Raw {
+		if err := cond.end(); err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	return lines, nil
+}
+
+// resolve returns the path of an included file. Relative names are searched
+// in the configuration directory and then in the CONFIG_PATH directories.
+func (ld *fileLoader) resolve(name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("%w: missing file name", ErrIncludeNotFound)
+	}
+	if path.IsAbs(name) {
+		if _, err := os.Stat(name); err != nil {
+			return "", fmt.Errorf("%w: %s", ErrIncludeNotFound, name)
+		}
+		return name, nil
+	}
+	for _, dir := range append([]string{ld.configDir}, ld.configPath...) {
+		p := path.Join(dir, name)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrIncludeNotFound, name)
+}
+
+// loadConfigFile reads the file called name of the configuration of
+// opts.Family, following includes.
+func loadConfigFile(name string, opts ReadOptions) ([]logicalLine, error) {
+	ld, err := newFileLoader(opts.Family.configPath(), opts.Family.confFile(), opts)
+	if err != nil {
+		return nil, err
+	}
+	return ld.load(path.Join(opts.Family.configPath(), name))
+}
@@ -0,0 +1,55 @@
+package goshorewall
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileLoader_Include(t *testing.T) {
+	dir, shared := t.TempDir(), t.TempDir()
+
+	writeFile(t, path.Join(dir, confFile), "CONFIG_PATH="+shared+"\n")
+	writeFile(t, path.Join(dir, rulesFile), "ACCEPT\tnet\tfw\ttcp\t22\n?INCLUDE rules.web\nINCLUDE rules.shared\nDROP\tnet\tfw\n")
+	writeFile(t, path.Join(dir, "rules.web"), "# web rules\nACCEPT\tnet\tfw\ttcp\t80\n")
+	writeFile(t, path.Join(shared, "rules.shared"), "ACCEPT\tnet\tfw\ttcp\t443\n")
+
+	ld, err := newFileLoader(dir, confFile, ReadOptions{})
+	assert.NoError(t, err)
+	lines, err := ld.load(path.Join(dir, rulesFile))
+	assert.NoError(t, err)
+
+	rules := parseRuleLines(lines, 3, directiveState{}, nil)
+	assert.Len(t, rules, 4)
+	assert.Equal(t, "80", rules[1].Dport)
+	assert.Equal(t, Location{File: path.Join(dir, "rules.web"), Line: 2}, rules[1].Location)
+	assert.Equal(t, Location{File: path.Join(shared, "rules.shared"), Line: 1}, rules[2].Location)
+	assert.Equal(t, Location{File: path.Join(dir, rulesFile), Line: 4}, rules[3].Location)
+	assert.True(t, rules[1].Equals(Rule{Action: "ACCEPT", Source: "net", Destination: "fw", Protocol: "tcp", Dport: "80"}))
+}
+
+func TestFileLoader_IncludeErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFile(t, path.Join(dir, rulesFile), "?INCLUDE rules.a\n")
+	writeFile(t, path.Join(dir, "rules.a"), "?INCLUDE rules.b\n")
+	writeFile(t, path.Join(dir, "rules.b"), "?INCLUDE rules.a\n")
+
+	ld, err := newFileLoader(dir, confFile, ReadOptions{})
+	assert.NoError(t, err)
+	_, err = ld.load(path.Join(dir, rulesFile))
+	assert.ErrorIs(t, err, ErrIncludeCycle)
+
+	writeFile(t, path.Join(dir, "rules.b"), "?INCLUDE rules.missing\n")
+	_, err = ld.load(path.Join(dir, rulesFile))
+	assert.ErrorIs(t, err, ErrIncludeNotFound)
+}
+
+func writeFile(t *testing.T, p, data string) {
+	t.Helper()
+	if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
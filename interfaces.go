@@ -1,10 +1,8 @@
 package goshorewall
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
-	"os"
 	"slices"
 	"strings"
 )
@@ -17,6 +15,15 @@ var (
 type Interface struct {
 	Zone string
 	Name string
+	// Broadcast is only present in the format 1 layout of the interfaces
+	// file and is dropped when writing in format 2.
+	Broadcast string
+	Options   string
+	// Comment is the ?COMMENT annotation of the interface. It is not
+	// considered when comparing interfaces.
+	Comment string
+	// Location is the file and line the interface was read from.
+	Location Location
 }
 
 func (i Interface) Compare(other Interface) int {
@@ -31,26 +38,57 @@ func (i Interface) Equals(other Interface) bool {
 	return i.Zone == other.Zone && i.Name == other.Name
 }
 
+// Format formats the interface in the default layout of the interfaces file,
+// format 1.
 func (i Interface) Format() string {
-	return fmt.Sprintf("%s\t%s", i.Zone, i.Name)
+	return i.formatAs(1)
+}
+
+// formatAs formats the interface in the layout selected by ?FORMAT format.
+func (i Interface) formatAs(format int) string {
+	if format == 2 {
+		return formatWithComment(i.Comment, formatColumns(interfaceFormat2Columns, i.Zone, i.Name, i.Options))
+	}
+	return formatWithComment(i.Comment, formatColumns(interfaceColumns, i.Zone, i.Name, i.Broadcast, i.Options))
+}
+
+// FormatNamed formats the interface in the column=value form, with the
+// column names of format 1.
+func (i Interface) FormatNamed() string {
+	return formatWithComment(i.Comment, formatNamedColumns(interfaceColumns, i.Zone, i.Name, i.Broadcast, i.Options))
 }
 
 func Interfaces() ([]Interface, error) {
-	buff, err := os.ReadFile(fullInterfacesFile)
+	entries, _, err := InterfacesWithOptions(ReadOptions{})
+	return entries, err
+}
+
+// InterfacesWithOptions reads the interfaces file, following includes and evaluating
+// conditional directives as configured by opts. In lenient mode the lines
+// that could not be parsed are returned as warnings, in strict mode they
+// are returned as a ParseErrors error.
+func InterfacesWithOptions(opts ReadOptions) ([]Interface, []ParseError, error) {
+	lines, err := loadConfigFile(interfacesFile, opts)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return getInterfacesBuff(buff)
+	issues := &parseIssues{}
+	return result(parseInterfacesLines(lines, issues), issues, opts.Strict)
 }
 
 func AddInterface(iface Interface) error {
 	return readWriteFile(fullInterfacesFile, addInterfaceBuff, iface)
 }
 
+// RemoveInterfaceByZone removes every interface of zone.
 func RemoveInterfaceByZone(zone string) error {
 	return readWriteFile(fullInterfacesFile, removeInterfaceByZoneBuff, zone)
 }
 
+func UpdateInterface(from, to Interface) error {
+	return readWriteFile(fullInterfacesFile, updateInterfaceBuff, entryUpdate[Interface]{old: from, new: to})
+}
+
 func getInterfacesBuff(buff []byte) ([]Interface, error) {
 	return parseInterfaces(buff), nil
 }
@@ -67,46 +105,79 @@ func addInterfaceBuff(buff []byte, iface Interface) ([]byte, error) {
 		return nil, ErrInterfaceAlreadyExists
 	}
 
-	return fmt.Appendf(buff, "%s\n", iface.Format()), nil
+	return fmt.Appendf(buff, "%s\n", iface.formatAs(formatAt(buff))), nil
 }
 
+// removeInterfaceByZoneBuff removes every interface of zone, leaving the
+// other lines of buff untouched.
 func removeInterfaceByZoneBuff(buff []byte, zone string) ([]byte, error) {
+	buff, removed := removeAllBuff(buff, parseInterfacesLines, func(i Interface) bool {
+		return i.Zone == zone
+	})
+	if len(removed) == 0 {
+		return nil, ErrInterfaceNotFound
+	}
+	return buff, nil
+}
+
+// updateInterfaceBuff replaces the interface matching u.old with u.new,
+// leaving the other lines of buff untouched.
+func updateInterfaceBuff(buff []byte, u entryUpdate[Interface]) ([]byte, error) {
 	interfaces, err := getInterfacesBuff(buff)
 	if err != nil {
 		return nil, err
 	}
+	if u.new.Name != u.old.Name && slices.ContainsFunc(interfaces, func(i Interface) bool {
+		return i.Name == u.new.Name
+	}) {
+		return nil, ErrInterfaceAlreadyExists
+	}
 
-	index := slices.IndexFunc(interfaces, func(z Interface) bool {
-		return z.Zone == zone
+	t := parseCST(buff)
+	i, state := t.find(func(l logicalLine, _ directiveState) bool {
+		return slices.ContainsFunc(parseInterfacesLines([]logicalLine{l}, nil), u.old.Equals)
 	})
-	if index == -1 {
+	if i == -1 {
 		return nil, ErrInterfaceNotFound
 	}
+	iface := u.new
+	iface.Comment = t.entryComment(i, state, iface.Comment)
+	t.replace(i, iface.formatAs(state.format))
+	return t.Bytes(), nil
+}
 
-	interfaces = slices.Delete(interfaces, index, index+1)
-
-	var b bytes.Buffer
-	for _, z := range interfaces {
-		b.WriteString(fmt.Sprintf("%s\n", z.Format()))
-	}
-
-	return b.Bytes(), nil
+func parseInterfaces(data []byte) []Interface {
+	return parseInterfacesLines(tokenize(data), nil)
 }
 
-func parseInterfaces(data []byte) (interfaces []Interface) {
-	for z := range bytes.Lines(data) {
-		z = bytes.TrimSpace(z)
-		if len(z) == 0 || z[0] == '#' {
-			continue
+func parseInterfacesLines(lines []logicalLine, issues *parseIssues) (interfaces []Interface) {
+	var state directiveState
+	for _, l := range lines {
+		names := interfaceColumns
+		if state.format == 2 {
+			names = interfaceFormat2Columns
 		}
-		z = bytes.ReplaceAll(z, []byte("\t"), []byte(" "))
-		parts := bytes.Fields(z)
-		if len(parts) < 2 {
+		parts, ok := entryColumns(l, &state, names, 2, issues)
+		if !ok {
 			continue
 		}
 		iface := Interface{
-			Zone: string(parts[0]),
-			Name: string(parts[1]),
+			Zone:     parts[0],
+			Name:     parts[1],
+			Comment:  state.comment,
+			Location: l.location(),
+		}
+		if state.format == 2 {
+			if len(parts) > 2 {
+				iface.Options = parts[2]
+			}
+		} else {
+			if len(parts) > 2 {
+				iface.Broadcast = parts[2]
+			}
+			if len(parts) > 3 {
+				iface.Options = parts[3]
+			}
 		}
 		interfaces = append(interfaces, iface)
 	}
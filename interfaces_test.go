@@ -86,3 +86,42 @@ func TestRemoveInterfaceByZoneBuff_NotFound(t *testing.T) {
 	_, err := removeInterfaceByZoneBuff([]byte(interfaces01), "dmz")
 	assert.ErrorIs(t, err, ErrInterfaceNotFound, "expected ErrInterfaceNotFound")
 }
+
+const interfacesFormats01 = `#ZONE	INTERFACE	BROADCAST	OPTIONS
+net	eth0	detect	dhcp,tcpflags
+?FORMAT 2
+#ZONE	INTERFACE	OPTIONS
+loc	eth1	routefilter,physical=eth1
+dmz	eth2
+`
+
+func TestParseInterfaces_Format(t *testing.T) {
+	interfaces := parseInterfaces([]byte(interfacesFormats01))
+	assert.Equal(t, []Interface{
+		{Zone: "net", Name: "eth0", Broadcast: "detect", Options: "dhcp,tcpflags"},
+		{Zone: "loc", Name: "eth1", Options: "routefilter,physical=eth1"},
+		{Zone: "dmz", Name: "eth2"},
+	}, interfaces)
+
+	assert.Equal(t, "net\teth0\tdetect\tdhcp,tcpflags", interfaces[0].Format())
+	assert.Equal(t, "loc\teth1\t-\troutefilter,physical=eth1", interfaces[1].Format())
+	assert.Equal(t, "loc\teth1\troutefilter,physical=eth1", interfaces[1].formatAs(2))
+}
+
+func TestAddInterfaceBuff_Format(t *testing.T) {
+	iface := Interface{Zone: "vpn", Name: "wg0", Options: "optional"}
+	buff, err := addInterfaceBuff([]byte(interfacesFormats01), iface)
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, interfacesFormats01+"vpn\twg0\toptional\n", string(buff))
+
+	buff, err = addInterfaceBuff([]byte("net\teth0\n"), iface)
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, "net\teth0\nvpn\twg0\t-\toptional\n", string(buff))
+	assert.Equal(t, "optional", parseInterfaces(buff)[1].Options)
+}
+
+func TestRemoveInterfaceByZoneBuff_All(t *testing.T) {
+	buff, err := removeInterfaceByZoneBuff([]byte("net\teth0\nloc\teth1\n# lan\nloc\teth2\n"), "loc")
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, "net\teth0\n# lan\n", string(buff))
+}
@@ -0,0 +1,316 @@
+package goshorewall
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	shorewallSharePath = "/usr/share/shorewall"
+
+	macroFilePrefix = "macro."
+	// macroParam is the placeholder replaced by the macro parameter when a
+	// macro is expanded.
+	macroParam = "PARAM"
+	// maxMacroDepth bounds the nesting of macros invoking other macros.
+	maxMacroDepth = 16
+)
+
+var (
+	ErrMacroAlreadyExists = errors.New("macro already exists")
+	ErrMacroNotFound      = errors.New("macro not found")
+	ErrMacroInvalidName   = errors.New("invalid macro name")
+	ErrMacroNotOwned      = errors.New("macro is not managed by this application")
+	ErrMacroMissingParam  = errors.New("macro requires a parameter")
+	ErrMacroTooDeep       = errors.New("macro nesting too deep")
+)
+
+// Macros returns the names of the macros defined in the Shorewall
+// configuration directory.
+func Macros() ([]string, error) {
+	return listMacros(shorewallConfigPath)
+}
+
+// Macro returns the body of the macro called name from the Shorewall
+// configuration directory.
+func Macro(name string) ([]Rule, error) {
+	return readMacro([]string{shorewallConfigPath}, name)
+}
+
+// CreateMacro creates a new macro file in the Shorewall configuration directory.
+func CreateMacro(name string, body []Rule) error {
+	return createMacro(shorewallConfigPath, name, formatMacroBody(body))
+}
+
+// DeleteMacro deletes a macro file from the Shorewall configuration directory.
+func DeleteMacro(name string) error {
+	return deleteMacro(shorewallConfigPath, name, nil)
+}
+
+// ExpandMacroRule expands a rule invoking a macro, such as
+// "OurMonitoring(ACCEPT)", into the rules of the macro body. Macros are
+// searched in the Shorewall configuration directory and then in the
+// Shorewall share directory.
+func ExpandMacroRule(rule Rule) ([]Rule, error) {
+	return expandMacroRule([]string{shorewallConfigPath, shorewallSharePath}, rule, 0)
+}
+
+func macroFileName(name string) string {
+	return macroFilePrefix + name
+}
+
+func validateMacroName(name string) error {
+	if name == "" {
+		return fmt.Errorf("%w: empty name", ErrMacroInvalidName)
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '_', c == '-':
+		default:
+			return fmt.Errorf("%w: %q", ErrMacroInvalidName, name)
+		}
+	}
+	return nil
+}
+
+func listMacros(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), macroFilePrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(e.Name(), macroFilePrefix)
+		if validateMacroName(name) == nil {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func readMacro(dirs []string, name string) ([]Rule, error) {
+	if err := validateMacroName(name); err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		buff, err := os.ReadFile(path.Join(dir, macroFileName(name)))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return getMacroBuff(buff)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrMacroNotFound, name)
+}
+
+func getMacroBuff(buff []byte) ([]Rule, error) {
+	return parseMacroRules(buff), nil
+}
+
+// parseMacroRules parses a macro or action body. Unlike in the rules file,
+// only the ACTION column is mandatory, and bodies are in format 1 unless
+// they start with ?FORMAT 2.
+func parseMacroRules(data []byte) []Rule {
+	var rules []Rule
+	for _, r := range parseRuleColumns(data, 1, 1) {
+		// DEFAULTS declares the default parameters of an action
+		if r.Action == "DEFAULTS" {
+			continue
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+func formatMacroBody(body []Rule) []byte {
+	var b bytes.Buffer
+	b.WriteString("?FORMAT 2\n")
+	b.WriteString("#ACTION\tSOURCE\tDEST\tPROTO\tDPORT\tSPORT\tORIGDEST\n")
+	for _, r := range body {
+		b.WriteString(fmt.Sprintf("%s\n", fillEmptyMacroRule(r).Format()))
+	}
+	return b.Bytes()
+}
+
+// fillEmptyMacroRule fills empty fields with "-" where necessary. Unlike in
+// the rules file, SOURCE and DEST are commonly empty in a macro body.
+func fillEmptyMacroRule(r Rule) Rule {
+	r = r.fillEmpty()
+	if r.Protocol == "" && r.Dport != "" {
+		r.Protocol = "-"
+	}
+	if r.Destination == "" && r.Protocol != "" {
+		r.Destination = "-"
+	}
+	if r.Source == "" && r.Destination != "" {
+		r.Source = "-"
+	}
+	return r
+}
+
+func createMacro(dir, name string, content []byte) error {
+	if err := validateMacroName(name); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path.Join(dir, macroFileName(name)), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%w: %s", ErrMacroAlreadyExists, name)
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	n, err := file.Write(content)
+	if err != nil {
+		return err
+	}
+	if n < len(content) {
+		return fmt.Errorf("failed to write complete data to macro file")
+	}
+	return nil
+}
+
+// deleteMacro removes the macro file called name from dir. If owned is not
+// nil it is called with the content of the file and the file is removed
+// only when it returns true.
+func deleteMacro(dir, name string, owned func([]byte) bool) error {
+	if err := validateMacroName(name); err != nil {
+		return err
+	}
+	p := path.Join(dir, macroFileName(name))
+	if owned != nil {
+		buff, err := os.ReadFile(p)
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %s", ErrMacroNotFound, name)
+		} else if err != nil {
+			return err
+		}
+		if !owned(buff) {
+			return fmt.Errorf("%w: %s", ErrMacroNotOwned, name)
+		}
+	}
+	err := os.Remove(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrMacroNotFound, name)
+	}
+	return err
+}
+
+// splitMacroInvocation splits an action such as "SSH(ACCEPT):info" or
+// "SSH/ACCEPT" into the macro name, its parameter and the log suffix
+// (including the leading colon).
+func splitMacroInvocation(action string) (name, param, log string) {
+	if i := strings.IndexByte(action, '('); i != -1 {
+		if j := strings.IndexByte(action[i:], ')'); j != -1 {
+			name, param, log = action[:i], action[i+1:i+j], action[i+j+1:]
+			return
+		}
+	}
+	name = action
+	if i := strings.IndexByte(name, ':'); i != -1 {
+		name, log = name[:i], name[i:]
+	}
+	if i := strings.IndexByte(name, '/'); i != -1 {
+		name, param = name[:i], name[i+1:]
+	}
+	return
+}
+
+func expandMacroRule(dirs []string, rule Rule, depth int) ([]Rule, error) {
+	if depth >= maxMacroDepth {
+		return nil, fmt.Errorf("%w: %s", ErrMacroTooDeep, rule.Action)
+	}
+
+	name, param, log := splitMacroInvocation(rule.Action)
+	body, err := readMacro(dirs, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []Rule
+	for _, b := range body {
+		r, err := mergeMacroRule(b, rule, param, log)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand macro %s: %w", name, err)
+		}
+
+		// Macros may invoke other macros
+		nested, err := expandMacroRule(dirs, r, depth+1)
+		if errors.Is(err, ErrMacroNotFound) || errors.Is(err, ErrMacroInvalidName) {
+			rules = append(rules, r)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		rules = append(rules, nested...)
+	}
+	return rules, nil
+}
+
+// mergeMacroRule merges a rule of a macro body with the rule invoking the
+// macro, following the rules used by the Shorewall compiler.
+func mergeMacroRule(body, invocation Rule, param, log string) (Rule, error) {
+	r := body
+
+	if r.Action == macroParam || strings.HasPrefix(r.Action, macroParam+":") {
+		if param == "" {
+			return Rule{}, ErrMacroMissingParam
+		}
+		r.Action = param + strings.TrimPrefix(r.Action, macroParam)
+	} else if strings.Contains(r.Action, macroParam) && param != "" {
+		// Nested invocations such as "Other(PARAM)"
+		r.Action = strings.ReplaceAll(r.Action, macroParam, param)
+	}
+	if log != "" && !strings.Contains(r.Action, ":") {
+		r.Action += log
+	}
+
+	r.Source = mergeMacroSourceDest(body.Source, invocation.Source, invocation)
+	r.Destination = mergeMacroSourceDest(body.Destination, invocation.Destination, invocation)
+	r.Protocol = mergeMacroColumn(body.Protocol, invocation.Protocol)
+	r.Dport = mergeMacroColumn(body.Dport, invocation.Dport)
+	r.Sport = mergeMacroColumn(body.Sport, invocation.Sport)
+	r.Origdest = mergeMacroColumn(body.Origdest, invocation.Origdest)
+	r.limited = body.limited || invocation.limited
+	return r, nil
+}
+
+// mergeMacroSourceDest merges the SOURCE or DEST column of a macro body with
+// the matching column of the invoking rule. The SOURCE and DEST keywords in
+// the body refer to the respective columns of the invoking rule.
+func mergeMacroSourceDest(body, invocation string, rule Rule) string {
+	switch {
+	case body == "" || body == "-":
+		return invocation
+	case strings.HasPrefix(body, "SOURCE"):
+		return rule.Source + strings.TrimPrefix(body, "SOURCE")
+	case strings.HasPrefix(body, "DEST"):
+		return rule.Destination + strings.TrimPrefix(body, "DEST")
+	}
+	if invocation == "" || invocation == "-" {
+		return body
+	}
+	return invocation + ":" + body
+}
+
+func mergeMacroColumn(body, invocation string) string {
+	if invocation != "" && invocation != "-" {
+		return invocation
+	}
+	return body
+}
+
+func isMacroBodyOwnedBy(id string) func([]byte) bool {
+	return func(buff []byte) bool {
+		_, _, found, err := extractApplicationSubsetBufferIndexes(id, buff)
+		return err == nil && found
+	}
+}
@@ -0,0 +1,116 @@
+package goshorewall
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+const macroOurMonitoring = `?FORMAT 2
+#ACTION	SOURCE	DEST	PROTO	DPORT	SPORT	ORIGDEST
+PARAM	-	-	tcp	9100
+PARAM	-	-	udp	161:162
+PARAM:info	DEST	SOURCE	icmp	8
+Web(PARAM)	-	10.0.0.5
+`
+
+const macroWeb = `?FORMAT 2
+#ACTION	SOURCE	DEST	PROTO	DPORT
+PARAM	-	-	tcp	80
+PARAM	-	-	tcp	443
+`
+
+func TestSplitMacroInvocation(t *testing.T) {
+	testCases := []struct {
+		action string
+		name   string
+		param  string
+		log    string
+	}{
+		{"SSH(ACCEPT)", "SSH", "ACCEPT", ""},
+		{"SSH(ACCEPT):info", "SSH", "ACCEPT", ":info"},
+		{"SSH/ACCEPT", "SSH", "ACCEPT", ""},
+		{"SSH/ACCEPT:info:tag", "SSH", "ACCEPT", ":info:tag"},
+		{"Ping", "Ping", "", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.action, func(t *testing.T) {
+			name, param, log := splitMacroInvocation(tc.action)
+			assert.Equal(t, tc.name, name)
+			assert.Equal(t, tc.param, param)
+			assert.Equal(t, tc.log, log)
+		})
+	}
+}
+
+func TestMacroCreateReadDelete(t *testing.T) {
+	dir := t.TempDir()
+	id := uuid.NewString()
+
+	body := []Rule{
+		{Action: "PARAM", Protocol: "tcp", Dport: "9100"},
+		{Action: "PARAM", Protocol: "udp", Sport: "161"},
+	}
+	content := wrapBuffWithAppIdentifier(formatMacroBody(body), id)
+	assert.NoError(t, createMacro(dir, "OurMonitoring", content), "creating macro")
+	assert.ErrorIs(t, createMacro(dir, "OurMonitoring", content), ErrMacroAlreadyExists)
+	assert.ErrorIs(t, createMacro(dir, "../evil", content), ErrMacroInvalidName)
+
+	names, err := listMacros(dir)
+	assert.NoError(t, err, "listing macros")
+	assert.Equal(t, []string{"OurMonitoring"}, names)
+
+	rules, err := readMacro([]string{dir}, "OurMonitoring")
+	assert.NoError(t, err, "reading macro")
+	assert.Equal(t, 2, len(rules), "expected 2 rules")
+	assert.Equal(t, "PARAM", rules[0].Action)
+	assert.Equal(t, "9100", rules[0].Dport)
+	assert.Equal(t, "-", rules[1].Dport)
+	assert.Equal(t, "161", rules[1].Sport)
+
+	err = deleteMacro(dir, "OurMonitoring", isMacroBodyOwnedBy(uuid.NewString()))
+	assert.ErrorIs(t, err, ErrMacroNotOwned)
+	assert.NoError(t, deleteMacro(dir, "OurMonitoring", isMacroBodyOwnedBy(id)), "deleting macro")
+	assert.ErrorIs(t, deleteMacro(dir, "OurMonitoring", nil), ErrMacroNotFound)
+}
+
+func TestExpandMacroRule(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(path.Join(dir, "macro.OurMonitoring"), []byte(macroOurMonitoring), 0600))
+	assert.NoError(t, os.WriteFile(path.Join(dir, "macro.Web"), []byte(macroWeb), 0600))
+
+	rules, err := expandMacroRule([]string{dir}, Rule{Action: "OurMonitoring(ACCEPT)", Source: "mon", Destination: "fw"}, 0)
+	assert.NoError(t, err, "expanding macro")
+	assert.Equal(t, []Rule{
+		{Action: "ACCEPT", Source: "mon", Destination: "fw", Protocol: "tcp", Dport: "9100"},
+		{Action: "ACCEPT", Source: "mon", Destination: "fw", Protocol: "udp", Dport: "161:162"},
+		{Action: "ACCEPT:info", Source: "fw", Destination: "mon", Protocol: "icmp", Dport: "8"},
+		{Action: "ACCEPT", Source: "mon", Destination: "fw:10.0.0.5", Protocol: "tcp", Dport: "80"},
+		{Action: "ACCEPT", Source: "mon", Destination: "fw:10.0.0.5", Protocol: "tcp", Dport: "443"},
+	}, rules)
+
+	rules, err = expandMacroRule([]string{dir}, Rule{Action: "Web/DROP:info", Source: "net", Destination: "dmz", Protocol: "tcp", Dport: "8080"}, 0)
+	assert.NoError(t, err, "expanding macro")
+	assert.Equal(t, []Rule{
+		{Action: "DROP:info", Source: "net", Destination: "dmz", Protocol: "tcp", Dport: "8080"},
+		{Action: "DROP:info", Source: "net", Destination: "dmz", Protocol: "tcp", Dport: "8080"},
+	}, rules)
+
+	_, err = expandMacroRule([]string{dir}, Rule{Action: "Web", Source: "net", Destination: "dmz"}, 0)
+	assert.ErrorIs(t, err, ErrMacroMissingParam)
+
+	_, err = expandMacroRule([]string{dir}, Rule{Action: "Missing(ACCEPT)"}, 0)
+	assert.ErrorIs(t, err, ErrMacroNotFound)
+}
+
+func TestParseMacroRules_Format(t *testing.T) {
+	rules := parseMacroRules([]byte("#ACTION\tSOURCE\tDEST\tPROTO\tDPORT\tSPORT\tRATE\nPARAM\t-\t-\ttcp\t22\t-\t3/min\n?FORMAT 2\nPARAM\t-\t-\ttcp\t2222\t-\t10.0.0.1\n"))
+	assert.Equal(t, []Rule{
+		{Action: "PARAM", Source: "-", Destination: "-", Protocol: "tcp", Dport: "22", Sport: "-", limited: true},
+		{Action: "PARAM", Source: "-", Destination: "-", Protocol: "tcp", Dport: "2222", Sport: "-", Origdest: "10.0.0.1"},
+	}, rules)
+}
@@ -0,0 +1,160 @@
+package goshorewall
+
+import (
+	"cmp"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// protocolNames maps the protocol numbers to the names Shorewall accepts in
+// the PROTO column.
+var protocolNames = map[int]string{
+	1:   "icmp",
+	6:   "tcp",
+	17:  "udp",
+	33:  "dccp",
+	47:  "gre",
+	50:  "esp",
+	51:  "ah",
+	58:  "ipv6-icmp",
+	132: "sctp",
+	136: "udplite",
+}
+
+// Normalize returns r with its columns in canonical form, so that rules
+// matching the same traffic compare equal:
+//
+//   - "-" columns are empty;
+//   - protocols are lower case names, "6" becoming "tcp", and "all" is empty;
+//   - port lists are sorted, duplicates removed and, for protocols with
+//     ports, overlapping and adjacent ranges merged;
+//   - zone and address lists are sorted and deduplicated, host networks such
+//     as 1.2.3.4/32 become addresses and networks are masked;
+//   - an empty section is the NEW section.
+//
+// Columns that cannot be parsed are left as they are. Named services are not
+// resolved, "ssh" and "22" are different ports.
+func (r Rule) Normalize() Rule {
+	r.Source = normalizeAddress(r.Source)
+	r.Destination = normalizeAddress(r.Destination)
+	r.Protocol = normalizeProtocol(r.Protocol)
+	r.Dport = normalizePorts(r.Dport, r.Protocol)
+	r.Sport = normalizePorts(r.Sport, r.Protocol)
+	r.Origdest = normalizeAddress(r.Origdest)
+	r.Section = effectiveSection(strings.ToUpper(r.Section))
+	return r
+}
+
+// CompareNormalized is Compare on the normalized forms of the rules.
+func (r Rule) CompareNormalized(other Rule) int {
+	return r.Normalize().Compare(other.Normalize())
+}
+
+// EqualsNormalized reports whether r and other are equal once normalized,
+// that is if they match the same traffic even if written differently, as
+// "tcp 80,443" and "6 443,80".
+func (r Rule) EqualsNormalized(other Rule) bool {
+	return r.CompareNormalized(other) == 0
+}
+
+func normalizeProtocol(proto string) string {
+	if proto == "-" || strings.EqualFold(proto, "all") {
+		return ""
+	}
+	list, negated := strings.CutPrefix(proto, "!")
+	names := strings.Split(strings.ToLower(list), ",")
+	for i, name := range names {
+		if n, err := strconv.Atoi(name); err == nil && protocolNames[n] != "" {
+			names[i] = protocolNames[n]
+		}
+	}
+	slices.Sort(names)
+	list = strings.Join(slices.Compact(names), ",")
+	if negated {
+		return "!" + list
+	}
+	return list
+}
+
+// hasPorts reports whether the ports of proto are numbers that can be
+// merged into ranges, unlike ICMP types.
+func hasPorts(proto string) bool {
+	switch proto {
+	case "tcp", "udp", "sctp", "dccp", "udplite":
+		return true
+	}
+	return false
+}
+
+func normalizePorts(ports, proto string) string {
+	p, err := ParsePorts(ports)
+	if err != nil {
+		return ports
+	}
+	slices.SortFunc(p.Ranges, func(a, b PortRange) int {
+		return cmp.Or(
+			strings.Compare(a.Name, b.Name),
+			cmp.Compare(a.First, b.First),
+			cmp.Compare(a.Last, b.Last),
+		)
+	})
+	p.Ranges = slices.Compact(p.Ranges)
+	if !hasPorts(proto) {
+		return p.String()
+	}
+
+	var merged []PortRange
+	for _, r := range p.Ranges {
+		if n := len(merged); n > 0 && r.Name == "" && merged[n-1].Name == "" && int(r.First) <= int(merged[n-1].Last)+1 {
+			merged[n-1].Last = max(merged[n-1].Last, r.Last)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	p.Ranges = merged
+	return p.String()
+}
+
+// normalizeAddress returns the canonical form of an address specification,
+// trying the IPv4 syntax first.
+func normalizeAddress(addr string) string {
+	spec, err := ParseAddress(IPv4, addr)
+	if err != nil {
+		if spec, err = ParseAddress(IPv6, addr); err != nil {
+			return addr
+		}
+	}
+	slices.Sort(spec.Zones)
+	spec.Zones = slices.Compact(spec.Zones)
+	slices.Sort(spec.ExcludedZones)
+	spec.ExcludedZones = slices.Compact(spec.ExcludedZones)
+	spec.Addresses.Include = normalizeAddressItems(spec.Addresses.Include)
+	spec.Addresses.Exclude = normalizeAddressItems(spec.Addresses.Exclude)
+	return spec.String()
+}
+
+func normalizeAddressItems(items []AddressItem) []AddressItem {
+	for i, item := range items {
+		switch {
+		case item.Kind == AddressNetwork && item.Prefix.IsSingleIP():
+			items[i].Kind = AddressHost
+		case item.Kind == AddressNetwork:
+			items[i].Prefix = item.Prefix.Masked()
+		case item.Kind == AddressRange && item.From == item.To:
+			items[i] = AddressItem{Kind: AddressHost, Prefix: netip.PrefixFrom(item.From, item.From.BitLen())}
+		}
+	}
+	slices.SortFunc(items, func(a, b AddressItem) int {
+		return cmp.Or(
+			cmp.Compare(a.Kind, b.Kind),
+			a.Prefix.Addr().Compare(b.Prefix.Addr()),
+			cmp.Compare(a.Prefix.Bits(), b.Prefix.Bits()),
+			a.From.Compare(b.From),
+			a.To.Compare(b.To),
+			strings.Compare(a.Name, b.Name),
+		)
+	})
+	return slices.Compact(items)
+}
@@ -0,0 +1,88 @@
+package goshorewall
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRule_Normalize(t *testing.T) {
+	testCases := []struct {
+		name     string
+		rule     Rule
+		expected Rule
+	}{
+		{
+			name:     "Ports",
+			rule:     Rule{Action: "ACCEPT", Source: "net", Destination: "fw", Protocol: "tcp", Dport: "443,80,80,8000-8100,8050:8200,8201"},
+			expected: Rule{Action: "ACCEPT", Source: "net", Destination: "fw", Protocol: "tcp", Dport: "80,443,8000:8201", Section: SectionNew},
+		},
+		{
+			name:     "ICMP types are not merged",
+			rule:     Rule{Action: "ACCEPT", Source: "net", Destination: "fw", Protocol: "1", Dport: "8,echo-reply,0,1"},
+			expected: Rule{Action: "ACCEPT", Source: "net", Destination: "fw", Protocol: "icmp", Dport: "0,1,8,echo-reply", Section: SectionNew},
+		},
+		{
+			name:     "Protocols",
+			rule:     Rule{Action: "ACCEPT", Source: "net", Destination: "fw", Protocol: "UDP,6"},
+			expected: Rule{Action: "ACCEPT", Source: "net", Destination: "fw", Protocol: "tcp,udp", Section: SectionNew},
+		},
+		{
+			name:     "Dashes",
+			rule:     Rule{Action: "ACCEPT", Source: "net", Destination: "fw", Protocol: "-", Dport: "-", Sport: "-", Origdest: "-"},
+			expected: Rule{Action: "ACCEPT", Source: "net", Destination: "fw", Section: SectionNew},
+		},
+		{
+			name:     "Addresses",
+			rule:     Rule{Action: "ACCEPT", Source: "net:10.0.0.9/8,1.2.3.4/32,1.2.3.4", Destination: "loc,dmz", Origdest: "5.6.7.8-5.6.7.8"},
+			expected: Rule{Action: "ACCEPT", Source: "net:1.2.3.4,10.0.0.0/8", Destination: "dmz,loc", Origdest: "5.6.7.8", Section: SectionNew},
+		},
+		{
+			name:     "IPv6",
+			rule:     Rule{Action: "ACCEPT", Source: "net:[2001:db8::1/128,2001:db8::/32]", Destination: "$FW"},
+			expected: Rule{Action: "ACCEPT", Source: "net:<2001:db8::1,2001:db8::/32>", Destination: "$FW", Section: SectionNew},
+		},
+		{
+			name:     "Unparsable columns are kept",
+			rule:     Rule{Action: "ACCEPT", Source: "net:1.2.3.4:5.6.7.8", Destination: "fw", Protocol: "tcp", Dport: "99999"},
+			expected: Rule{Action: "ACCEPT", Source: "net:1.2.3.4:5.6.7.8", Destination: "fw", Protocol: "tcp", Dport: "99999", Section: SectionNew},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.rule.Normalize())
+		})
+	}
+}
+
+func TestRule_EqualsNormalized(t *testing.T) {
+	r1 := Rule{Action: "ACCEPT", Source: "net:1.2.3.4", Destination: "fw", Protocol: "tcp", Dport: "80,443"}
+	r2 := Rule{Action: "ACCEPT", Source: "net:1.2.3.4/32", Destination: "fw", Protocol: "6", Dport: "443,80"}
+	assert.False(t, r1.Equals(r2))
+	assert.True(t, r1.EqualsNormalized(r2))
+	assert.Equal(t, 0, r1.CompareNormalized(r2))
+
+	r2.Dport = "443"
+	assert.False(t, r1.EqualsNormalized(r2))
+
+	r2 = r1
+	r2.Section = SectionNew
+	assert.True(t, r1.EqualsNormalized(r2))
+	r2.Section = "new"
+	assert.True(t, r1.EqualsNormalized(r2))
+	r2.Section = SectionAll
+	assert.False(t, r1.EqualsNormalized(r2))
+}
+
+func TestAddRuleBuff_NormalizedDuplicate(t *testing.T) {
+	buff := []byte("ACCEPT\tnet:1.2.3.4\tfw\ttcp\t80,443\n")
+	_, err := addRuleBuff(buff, Rule{Action: "ACCEPT", Source: "net:1.2.3.4/32", Destination: "fw", Protocol: "6", Dport: "443,80"})
+	assert.ErrorIs(t, err, ErrRuleAlreadyExists)
+
+	_, err = addRuleBuff(buff, Rule{Action: "ACCEPT", Source: "net:1.2.3.5", Destination: "fw", Protocol: "tcp", Dport: "80,443"})
+	assert.NoError(t, err)
+
+	_, err = addRuleBuff(buff, Rule{Action: "ACCEPT", Source: "net:1.2.3.4", Destination: "fw", Protocol: "tcp", Dport: "443,80", Section: SectionNew})
+	assert.ErrorIs(t, err, ErrRuleAlreadyExists)
+}
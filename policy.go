@@ -1,10 +1,8 @@
 package goshorewall
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
-	"os"
 	"slices"
 	"strings"
 )
@@ -19,6 +17,11 @@ type Policy struct {
 	Destination string
 	Policy      string
 	Log         string
+	// Comment is the ?COMMENT annotation of the policy. It is not considered
+	// when comparing policies.
+	Comment string
+	// Location is the file and line the policy was read from.
+	Location Location
 }
 
 func (p Policy) Compare(other Policy) int {
@@ -36,15 +39,30 @@ func (p Policy) Equals(other Policy) bool {
 }
 
 func (p Policy) Format() string {
-	return fmt.Sprintf("%s\t%s\t%s\t%s", p.Source, p.Destination, p.Policy, p.Log)
+	return formatWithComment(p.Comment, formatColumns(policyColumns, p.Source, p.Destination, p.Policy, p.Log))
+}
+
+// FormatNamed formats the policy in the column=value form.
+func (p Policy) FormatNamed() string {
+	return formatWithComment(p.Comment, formatNamedColumns(policyColumns, p.Source, p.Destination, p.Policy, p.Log))
 }
 
 func Policies() ([]Policy, error) {
-	buff, err := os.ReadFile(fullPolicyFile)
+	entries, _, err := PoliciesWithOptions(ReadOptions{})
+	return entries, err
+}
+
+// PoliciesWithOptions reads the policy file, following includes and evaluating
+// conditional directives as configured by opts. In lenient mode the lines
+// that could not be parsed are returned as warnings, in strict mode they
+// are returned as a ParseErrors error.
+func PoliciesWithOptions(opts ReadOptions) ([]Policy, []ParseError, error) {
+	lines, err := loadConfigFile(policyFile, opts)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return getPoliciesBuff(buff)
+	issues := &parseIssues{}
+	return result(parsePoliciesLines(lines, issues), issues, opts.Strict)
 }
 
 func AddPolicy(policy Policy) error {
@@ -59,6 +77,10 @@ func RemovePolicy(policy Policy) error {
 	return readWriteFile(fullPolicyFile, removePolicyBuff, policy)
 }
 
+func UpdatePolicy(from, to Policy) error {
+	return readWriteFile(fullPolicyFile, updatePolicyBuff, entryUpdate[Policy]{old: from, new: to})
+}
+
 func addPolicyBuff(buff []byte, policy Policy) ([]byte, error) {
 	policies, err := getPoliciesBuff(buff)
 	if err != nil {
@@ -74,50 +96,80 @@ func addPolicyBuff(buff []byte, policy Policy) ([]byte, error) {
 	return fmt.Appendf(buff, "%s\n", policy.Format()), nil
 }
 
+// removePolicyBuff removes the first line matching policy, leaving the other
+// lines of buff untouched.
 func removePolicyBuff(buff []byte, policy Policy) ([]byte, error) {
+	t := parseCST(buff)
+	i, _ := t.find(func(l logicalLine, _ directiveState) bool {
+		return slices.ContainsFunc(parsePoliciesLines([]logicalLine{l}, nil), policy.Equals)
+	})
+	if i == -1 {
+		return nil, ErrPolicyNotFound
+	}
+	t.remove(i)
+	return t.Bytes(), nil
+}
+
+// updatePolicyBuff replaces the policy matching u.old with u.new,
+// leaving the other lines of buff untouched.
+func updatePolicyBuff(buff []byte, u entryUpdate[Policy]) ([]byte, error) {
 	policies, err := getPoliciesBuff(buff)
 	if err != nil {
 		return nil, err
 	}
+	if !u.new.Equals(u.old) && slices.ContainsFunc(policies, u.new.Equals) {
+		return nil, ErrPolicyAlreadyExists
+	}
 
-	index := slices.IndexFunc(policies, func(p Policy) bool {
-		return p.Equals(policy)
+	t := parseCST(buff)
+	i, state := t.find(func(l logicalLine, _ directiveState) bool {
+		return slices.ContainsFunc(parsePoliciesLines([]logicalLine{l}, nil), u.old.Equals)
 	})
-	if index == -1 {
+	if i == -1 {
 		return nil, ErrPolicyNotFound
 	}
+	policy := u.new
+	policy.Comment = t.entryComment(i, state, policy.Comment)
+	t.replace(i, policy.Format())
+	return t.Bytes(), nil
+}
 
-	policies = slices.Delete(policies, index, index+1)
-
-	var b bytes.Buffer
-	for _, p := range policies {
-		b.WriteString(fmt.Sprintf("%s\n", p.Format()))
-	}
-
-	return b.Bytes(), nil
+func parsePolicies(data []byte) []Policy {
+	return parsePoliciesLines(tokenize(data), nil)
 }
 
-func parsePolicies(data []byte) (policies []Policy) {
-	iter := bytes.Lines(data)
-	for z := range iter {
-		z = bytes.TrimSpace(z)
-		if len(z) == 0 || z[0] == '#' {
+func parsePoliciesLines(lines []logicalLine, issues *parseIssues) (policies []Policy) {
+	var state directiveState
+	for _, l := range lines {
+		parts, ok := entryColumns(l, &state, policyColumns, 3, issues)
+		if !ok {
 			continue
 		}
-		z = bytes.ReplaceAll(z, []byte("\t"), []byte(" "))
-		parts := bytes.Fields(z)
-		if len(parts) < 3 {
+		if !isPolicyName(parts[2]) {
+			issues.add(l, 3, "invalid policy %q", parts[2])
 			continue
 		}
 		policy := Policy{
-			Source:      string(parts[0]),
-			Destination: string(parts[1]),
-			Policy:      string(parts[2]),
+			Source:      parts[0],
+			Destination: parts[1],
+			Policy:      parts[2],
+			Comment:     state.comment,
+			Location:    l.location(),
 		}
 		if len(parts) > 3 {
-			policy.Log = string(parts[3])
+			policy.Log = parts[3]
 		}
 		policies = append(policies, policy)
 	}
 	return
 }
+
+// policyNames are the values of the POLICY column, which may be followed by
+// a default action as in "DROP:Drop" or a queue number as in "NFQUEUE(1)".
+var policyNames = []string{"ACCEPT", "DROP", "REJECT", "BLACKLIST", "CONTINUE", "QUEUE", "NFQUEUE", "NONE"}
+
+func isPolicyName(p string) bool {
+	name, _, _ := strings.Cut(p, ":")
+	name, _, _ = strings.Cut(name, "(")
+	return slices.Contains(policyNames, name)
+}
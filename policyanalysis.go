@@ -0,0 +1,314 @@
+package goshorewall
+
+import (
+	"slices"
+	"strings"
+)
+
+// ZonePair is the source and destination zone of a connection.
+type ZonePair struct {
+	Source      string
+	Destination string
+}
+
+// ShadowedPolicy is a policy that never applies, as every zone pair it
+// matches is decided by an earlier policy.
+type ShadowedPolicy struct {
+	Policy Policy
+	// ShadowedBy lists the earlier policies deciding the zone pairs of
+	// Policy. It is empty for policies matching no zone pair, such as a
+	// policy between undefined zones.
+	ShadowedBy []Policy
+}
+
+// RedundantRule is a rule of the NEW section with the same outcome as the
+// policies governing its zone pairs, and that no later rule overrides. Rules
+// and policies that log are never redundant.
+type RedundantRule struct {
+	Rule     Rule
+	Policies []Policy
+}
+
+// PolicyReport is the result of AnalyzePolicies.
+type PolicyReport struct {
+	Shadowed []ShadowedPolicy
+	// MissingPolicies are the pairs of distinct zones without an applicable
+	// policy, which the Shorewall compiler rejects.
+	MissingPolicies []ZonePair
+	RedundantRules  []RedundantRule
+}
+
+// AnalyzePolicies reads the zones, policy and rules files of the
+// configuration of opts.Family and walks the policies in the order Shorewall
+// evaluates them, the first matching entry deciding a zone pair. It reports the
+// policies that never apply, the zone pairs without a policy and the rules
+// made redundant by the policies.
+func AnalyzePolicies(opts ReadOptions) (PolicyReport, error) {
+	s, err := loadReferenceSet(opts.Family.configPath(), opts.Family.confFile(), opts, nil)
+	if err != nil {
+		return PolicyReport{}, err
+	}
+	return analyzePolicies(s), nil
+}
+
+// AnalyzePolicies is AnalyzePolicies for the configuration managed by the
+// App instance. It analyses the whole files, not only the blocks of the App
+// instance.
+func (a *App) AnalyzePolicies(opts ReadOptions) (PolicyReport, error) {
+	opts.Family = a.family
+	s, err := loadReferenceSet(a.basePath, a.family.confFile(), opts, nil)
+	if err != nil {
+		return PolicyReport{}, err
+	}
+	return analyzePolicies(s), nil
+}
+
+func analyzePolicies(s referenceSet) PolicyReport {
+	var report PolicyReport
+	zt := newZoneTable(s.zones)
+	pt := newPolicyTable(zt, s.policies)
+
+	// A policy using a shell variable may decide any pair
+	unknown := slices.ContainsFunc(pt.matchers, zoneMatcher.unknown)
+	decided := make([]bool, len(s.policies))
+	for _, pair := range zt.pairs() {
+		if i := pt.lookup(pair); i != -1 {
+			decided[i] = true
+		} else if pair.Source != pair.Destination && !unknown {
+			report.MissingPolicies = append(report.MissingPolicies, pair)
+		}
+	}
+	for i, p := range s.policies {
+		if decided[i] || pt.matchers[i].unknown() {
+			continue
+		}
+		shadowed := ShadowedPolicy{Policy: p}
+		for _, pair := range zt.pairs() {
+			if pt.matchers[i].matches(pair) {
+				shadowed.ShadowedBy = appendPolicy(shadowed.ShadowedBy, s.policies[pt.lookup(pair)])
+			}
+		}
+		report.Shadowed = append(report.Shadowed, shadowed)
+	}
+
+	for i, r := range s.rules {
+		if policies, ok := redundantRule(zt, pt, s.rules[i+1:], r); ok {
+			report.RedundantRules = append(report.RedundantRules, RedundantRule{Rule: r, Policies: policies})
+		}
+	}
+	return report
+}
+
+// redundantRule returns the policies governing r if r has their outcome and
+// the later rules of the NEW section never override it.
+func redundantRule(zt zoneTable, pt policyTable, later []Rule, r Rule) ([]Policy, bool) {
+	a, err := ParseRuleAction(r.Action)
+	if err != nil || a.Bang || a.LogLevel != "" || !isNewSection(r.Section) {
+		return nil, false
+	}
+	base := a.Base()
+	if base != "ACCEPT" && base != "DROP" && base != "REJECT" {
+		return nil, false
+	}
+	m := zt.ruleMatcher(r)
+	if m.unknown() {
+		return nil, false
+	}
+	var policies []Policy
+	for _, pair := range zt.pairs() {
+		if !m.matches(pair) {
+			continue
+		}
+		i := pt.lookup(pair)
+		// A logging policy differs from a rule that does not log
+		if i == -1 || policyVerdict(pt.policies[i]) != base || pt.policies[i].Log != "" && pt.policies[i].Log != "-" {
+			return nil, false
+		}
+		policies = appendPolicy(policies, pt.policies[i])
+	}
+	if len(policies) == 0 {
+		return nil, false
+	}
+	for _, l := range later {
+		if lm := zt.ruleMatcher(l); isNewSection(l.Section) && ruleVerdict(l) != base && (lm.unknown() || lm.overlaps(m, zt)) {
+			return nil, false
+		}
+	}
+	return policies, true
+}
+
+// ruleVerdict returns the built-in action of a rule, or an empty string if
+// its action cannot be parsed.
+func ruleVerdict(r Rule) string {
+	a, err := ParseRuleAction(r.Action)
+	if err != nil {
+		return ""
+	}
+	return a.Base()
+}
+
+// policyVerdict returns the POLICY column without its default action or
+// parameters.
+func policyVerdict(p Policy) string {
+	name, _, _ := strings.Cut(p.Policy, ":")
+	name, _, _ = strings.Cut(name, "(")
+	return name
+}
+
+func isNewSection(section string) bool {
+	return section == "" || section == SectionNew
+}
+
+func appendPolicy(policies []Policy, p Policy) []Policy {
+	if slices.ContainsFunc(policies, func(q Policy) bool { return q.Location == p.Location && q.Equals(p) }) {
+		return policies
+	}
+	return append(policies, p)
+}
+
+// zoneTable holds the zones of a configuration.
+type zoneTable struct {
+	names    []string
+	firewall string
+}
+
+func newZoneTable(zones []Zone) zoneTable {
+	var zt zoneTable
+	for _, z := range zones {
+		name := zoneName(z)
+		if !slices.Contains(zt.names, name) {
+			zt.names = append(zt.names, name)
+		}
+		if z.Type == "firewall" {
+			zt.firewall = name
+		}
+	}
+	return zt
+}
+
+// pairs returns every ordered pair of zones, the firewall to itself
+// excepted.
+func (zt zoneTable) pairs() []ZonePair {
+	var pairs []ZonePair
+	for _, s := range zt.names {
+		for _, d := range zt.names {
+			if s != zt.firewall || d != zt.firewall {
+				pairs = append(pairs, ZonePair{Source: s, Destination: d})
+			}
+		}
+	}
+	return pairs
+}
+
+// zoneSet is the set of zones matched by a SOURCE or DEST column.
+type zoneSet struct {
+	zones []string
+	// all is set for "all" and "any", which only match intra-zone traffic
+	// when followed by "+", setting intra.
+	all, intra bool
+	// unknown is set when the column uses a shell variable other than $FW,
+	// whose zones cannot be known: zones is then incomplete.
+	unknown bool
+}
+
+// zoneSet returns the zones matched by spec. "all-" leaves out the firewall
+// zone, zones following "!" are excluded. Shell variables other than $FW are
+// left unresolved, as in zoneReferences, and set unknown.
+func (zt zoneTable) zoneSet(spec string) zoneSet {
+	var set zoneSet
+	zonePart, _, _ := strings.Cut(spec, ":")
+	include, exclude, _ := strings.Cut(zonePart, "!")
+	resolve := func(name string) string {
+		if isFirewallReference(name) {
+			return zt.firewall
+		}
+		if strings.HasPrefix(name, "$") {
+			set.unknown = true
+		}
+		return name
+	}
+	for _, item := range strings.Split(include, ",") {
+		name := strings.TrimRight(item, "+-")
+		switch name {
+		case "all", "any":
+			set.all = true
+			set.intra = set.intra || strings.Contains(item[len(name):], "+")
+			for _, z := range zt.names {
+				if z != zt.firewall || !strings.Contains(item[len(name):], "-") {
+					set.zones = append(set.zones, z)
+				}
+			}
+		default:
+			if name = resolve(name); slices.Contains(zt.names, name) {
+				set.zones = append(set.zones, name)
+			}
+		}
+	}
+	for _, name := range strings.Split(exclude, ",") {
+		name = resolve(name)
+		set.zones = slices.DeleteFunc(set.zones, func(z string) bool { return z == name })
+	}
+	slices.Sort(set.zones)
+	set.zones = slices.Compact(set.zones)
+	return set
+}
+
+// zoneMatcher matches the zone pairs of the SOURCE and DEST columns of a
+// policy or rule.
+type zoneMatcher struct {
+	source, dest zoneSet
+}
+
+func (zt zoneTable) matcher(source, dest string) zoneMatcher {
+	return zoneMatcher{source: zt.zoneSet(source), dest: zt.zoneSet(dest)}
+}
+
+// unknown reports whether the zone pairs matched by m cannot be known, see
+// zoneSet.
+func (m zoneMatcher) unknown() bool {
+	return m.source.unknown || m.dest.unknown
+}
+
+// ruleMatcher is matcher for a rule. The DEST column of REDIRECT rules is a
+// port of the firewall.
+func (zt zoneTable) ruleMatcher(r Rule) zoneMatcher {
+	if actionBaseName(r.Action) == "REDIRECT" {
+		return zt.matcher(r.Source, "$FW")
+	}
+	return zt.matcher(r.Source, r.Destination)
+}
+
+// matches reports whether the columns match pair. Traffic within a zone is
+// only matched by explicit zones or by "all+".
+func (m zoneMatcher) matches(pair ZonePair) bool {
+	if !slices.Contains(m.source.zones, pair.Source) || !slices.Contains(m.dest.zones, pair.Destination) {
+		return false
+	}
+	return pair.Source != pair.Destination || m.source.intra || m.dest.intra || !m.source.all && !m.dest.all
+}
+
+// overlaps reports whether m and other match a common zone pair.
+func (m zoneMatcher) overlaps(other zoneMatcher, zt zoneTable) bool {
+	return slices.ContainsFunc(zt.pairs(), func(pair ZonePair) bool {
+		return m.matches(pair) && other.matches(pair)
+	})
+}
+
+// policyTable finds the policy deciding a zone pair.
+type policyTable struct {
+	policies []Policy
+	matchers []zoneMatcher
+}
+
+func newPolicyTable(zt zoneTable, policies []Policy) policyTable {
+	pt := policyTable{policies: policies}
+	for _, p := range policies {
+		pt.matchers = append(pt.matchers, zt.matcher(p.Source, p.Destination))
+	}
+	return pt
+}
+
+// lookup returns the index of the first policy matching pair, or -1.
+func (pt policyTable) lookup(pair ZonePair) int {
+	return slices.IndexFunc(pt.matchers, func(m zoneMatcher) bool { return m.matches(pair) })
+}
@@ -0,0 +1,97 @@
+package goshorewall
+
+import (
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyzePolicies(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, path.Join(dir, zonesFile), "fw\tfirewall\nnet\tipv4\nloc\tipv4\ndmz\tipv4\n")
+	writeFile(t, path.Join(dir, policyFile), "$FW\tall\tACCEPT\n"+
+		"loc\tnet\tACCEPT\n"+
+		"net\t$FW\tDROP\tinfo\n"+
+		"all\tall\tREJECT\n"+
+		"dmz\tnet\tDROP\n"+
+		"ghost\tnet\tDROP\n")
+	writeFile(t, path.Join(dir, rulesFile), "ACCEPT\tloc\tnet\ttcp\t80\n"+
+		"REJECT\tnet\tdmz\ttcp\t22\n"+
+		"DROP\tnet\t$FW\ttcp\t23\n"+
+		"DROP\tloc:192.168.1.4\tnet\n"+
+		"SSH(ACCEPT)\t$FW\tnet\n"+
+		"ACCEPT!\t$FW\tnet\ttcp\t25\n"+
+		"ACCEPT:info\t$FW\tnet\ttcp\t26\n")
+	app, err := NewAppWithBasePath(dir)
+	assert.NoError(t, err)
+
+	report, err := app.AnalyzePolicies(ReadOptions{})
+	assert.NoError(t, err)
+
+	policies := parsePolicies([]byte("$FW\tall\tACCEPT\nloc\tnet\tACCEPT\nnet\t$FW\tDROP\tinfo\nall\tall\tREJECT\ndmz\tnet\tDROP\nghost\tnet\tDROP\n"))
+	for i := range policies {
+		policies[i].Location = Location{File: path.Join(dir, policyFile), Line: i + 1}
+	}
+	assert.Equal(t, []ShadowedPolicy{
+		{Policy: policies[4], ShadowedBy: []Policy{policies[3]}},
+		{Policy: policies[5]},
+	}, report.Shadowed)
+	assert.Empty(t, report.MissingPolicies)
+
+	if assert.Len(t, report.RedundantRules, 2) {
+		assert.Equal(t, "REJECT", report.RedundantRules[0].Rule.Action)
+		assert.Equal(t, 2, report.RedundantRules[0].Rule.Location.Line)
+		assert.Equal(t, []Policy{policies[3]}, report.RedundantRules[0].Policies)
+		assert.Equal(t, "SSH(ACCEPT)", report.RedundantRules[1].Rule.Action)
+		assert.Equal(t, []Policy{policies[0]}, report.RedundantRules[1].Policies)
+	}
+}
+
+func TestAnalyzePolicies_Missing(t *testing.T) {
+	s := referenceSet{
+		zones:    parseZones([]byte("fw\tfirewall\nnet\tipv4\nloc\tipv4\n")),
+		policies: parsePolicies([]byte("$FW\tnet\tACCEPT\nloc\tnet\tACCEPT\nnet\tall\tDROP\n")),
+	}
+	report := analyzePolicies(s)
+	assert.Equal(t, []ZonePair{{Source: "fw", Destination: "loc"}, {Source: "loc", Destination: "fw"}}, report.MissingPolicies)
+	assert.Empty(t, report.Shadowed)
+}
+
+func TestZoneMatcher(t *testing.T) {
+	zt := newZoneTable(parseZones([]byte("fw\tfirewall\nnet\tipv4\nloc\tipv4\n")))
+
+	testCases := []struct {
+		source, dest string
+		pair         ZonePair
+		expected     bool
+	}{
+		{"all", "all", ZonePair{"net", "loc"}, true},
+		{"all", "all", ZonePair{"loc", "loc"}, false},
+		{"all+", "all", ZonePair{"loc", "loc"}, true},
+		{"loc", "loc", ZonePair{"loc", "loc"}, true},
+		{"all-", "net", ZonePair{"fw", "net"}, false},
+		{"$FW", "net", ZonePair{"fw", "net"}, true},
+		{"all!loc", "net", ZonePair{"loc", "net"}, false},
+		{"net:1.2.3.4", "loc,fw", ZonePair{"net", "fw"}, true},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, zt.matcher(tc.source, tc.dest).matches(tc.pair), "%s %s %v", tc.source, tc.dest, tc.pair)
+	}
+}
+
+func TestAnalyzePolicies_ShellVariables(t *testing.T) {
+	s := referenceSet{
+		zones:    parseZones([]byte("fw\tfirewall\nnet\tipv4\nloc\tipv4\n")),
+		policies: parsePolicies([]byte("$FW\tall\tACCEPT\n$LAN\tnet\tACCEPT\nnet\tall\tDROP\n")),
+		rules:    parseRules([]byte("DROP\tnet\tloc\nACCEPT\t$LAN\tloc\n")),
+	}
+	report := analyzePolicies(s)
+	assert.Empty(t, report.MissingPolicies)
+	assert.Empty(t, report.Shadowed)
+	assert.Empty(t, report.RedundantRules)
+
+	zt := newZoneTable(s.zones)
+	assert.False(t, zt.matcher("net", "$FW").unknown())
+	assert.True(t, zt.matcher("net,$DMZ", "loc").unknown())
+}
@@ -0,0 +1,159 @@
+package goshorewall
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidPort = errors.New("invalid port")
+
+// maxPortListItems is the limit of the multiport match on the number of
+// ports of a list, a range counting as two.
+const maxPortListItems = 15
+
+// PortRange is an item of a port list: a port, a range of ports or a named
+// service. For ICMP the item is an ICMP type, possibly with a code as in
+// "3/4", kept in Name.
+type PortRange struct {
+	First, Last uint16
+	// Name is the service name, or the ICMP type, of an item that is not a
+	// number. First and Last are then zero.
+	Name string
+}
+
+func (r PortRange) String() string {
+	switch {
+	case r.Name != "":
+		return r.Name
+	case r.First == r.Last:
+		return strconv.Itoa(int(r.First))
+	}
+	return strconv.Itoa(int(r.First)) + ":" + strconv.Itoa(int(r.Last))
+}
+
+// PortSpec is the parsed form of the DPORT and SPORT columns: a comma
+// separated list of ports and ranges, such as "80,443,8000:8100",
+// optionally negated with a leading "!".
+type PortSpec struct {
+	Negated bool
+	Ranges  []PortRange
+}
+
+func (p PortSpec) String() string {
+	s := make([]string, len(p.Ranges))
+	for i, r := range p.Ranges {
+		s[i] = r.String()
+	}
+	if p.Negated {
+		return "!" + strings.Join(s, ",")
+	}
+	return strings.Join(s, ",")
+}
+
+// IsAny reports whether the specification matches every port, that is if
+// the column is empty or "-".
+func (p PortSpec) IsAny() bool {
+	return len(p.Ranges) == 0
+}
+
+// Contains reports whether port matches the specification. Named items are
+// not resolved and never match.
+func (p PortSpec) Contains(port uint16) bool {
+	if p.IsAny() {
+		return true
+	}
+	for _, r := range p.Ranges {
+		if r.Name == "" && r.First <= port && port <= r.Last {
+			return !p.Negated
+		}
+	}
+	return p.Negated
+}
+
+// ParsePorts parses the DPORT or SPORT column of a rule. An empty column or
+// "-" gives the zero PortSpec, matching any port. Ranges are written
+// first:last, an omitted bound standing for 0 or 65535; for compatibility
+// first-last is accepted as well.
+func ParsePorts(ports string) (PortSpec, error) {
+	var p PortSpec
+	if ports == "" || ports == "-" {
+		return p, nil
+	}
+	list, negated := strings.CutPrefix(ports, "!")
+	p.Negated = negated
+
+	items := 0
+	for item := range strings.SplitSeq(list, ",") {
+		r, err := parsePortRange(item)
+		if err != nil {
+			return PortSpec{}, fmt.Errorf("%w: %q: %w", ErrInvalidPort, ports, err)
+		}
+		p.Ranges = append(p.Ranges, r)
+		if items++; r.First != r.Last {
+			items++
+		}
+	}
+	if len(p.Ranges) > 1 && items > maxPortListItems {
+		return PortSpec{}, fmt.Errorf("%w: %q: more than %d ports in a list", ErrInvalidPort, ports, maxPortListItems)
+	}
+	return p, nil
+}
+
+func parsePortRange(item string) (PortRange, error) {
+	if item == "" {
+		return PortRange{}, errors.New("empty port")
+	}
+	first, last, isRange := strings.Cut(item, ":")
+	if f, l, ok := strings.Cut(item, "-"); !isRange && ok {
+		// A dash also appears in service names such as echo-request
+		_, errFirst := parsePortNumber(f)
+		_, errLast := parsePortNumber(l)
+		first, last, isRange = f, l, errFirst == nil && errLast == nil
+	}
+	if !isRange {
+		if n, err := parsePortNumber(item); err == nil {
+			return PortRange{First: n, Last: n}, nil
+		}
+		if isServiceName(item) && strings.Trim(item, "0123456789") != "" {
+			return PortRange{Name: item}, nil
+		}
+		return PortRange{}, fmt.Errorf("invalid port %q", item)
+	}
+
+	r := PortRange{First: 0, Last: 65535}
+	var err error
+	if first != "" {
+		if r.First, err = parsePortNumber(first); err != nil {
+			return PortRange{}, fmt.Errorf("invalid range %q", item)
+		}
+	}
+	if last != "" {
+		if r.Last, err = parsePortNumber(last); err != nil {
+			return PortRange{}, fmt.Errorf("invalid range %q", item)
+		}
+	}
+	if r.First > r.Last {
+		return PortRange{}, fmt.Errorf("invalid range %q", item)
+	}
+	return r, nil
+}
+
+func parsePortNumber(s string) (uint16, error) {
+	n, err := strconv.ParseUint(s, 10, 16)
+	return uint16(n), err
+}
+
+// isServiceName reports whether s can be a service name of /etc/services or
+// an ICMP type, such as "echo-request" or "3/4".
+func isServiceName(s string) bool {
+	for _, c := range s {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '_', c == '-', c == '.', c == '/':
+		default:
+			return false
+		}
+	}
+	return true
+}
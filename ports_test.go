@@ -0,0 +1,52 @@
+package goshorewall
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePorts(t *testing.T) {
+	testCases := []struct {
+		ports    string
+		expected PortSpec
+		str      string
+	}{
+		{"-", PortSpec{}, ""},
+		{"22", PortSpec{Ranges: []PortRange{{First: 22, Last: 22}}}, "22"},
+		{"80,443,8000:8100", PortSpec{Ranges: []PortRange{{First: 80, Last: 80}, {First: 443, Last: 443}, {First: 8000, Last: 8100}}}, "80,443,8000:8100"},
+		{"1024:", PortSpec{Ranges: []PortRange{{First: 1024, Last: 65535}}}, "1024:65535"},
+		{"6000-6010", PortSpec{Ranges: []PortRange{{First: 6000, Last: 6010}}}, "6000:6010"},
+		{"!ssh,smtp", PortSpec{Negated: true, Ranges: []PortRange{{Name: "ssh"}, {Name: "smtp"}}}, "!ssh,smtp"},
+		{"echo-request", PortSpec{Ranges: []PortRange{{Name: "echo-request"}}}, "echo-request"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.ports, func(t *testing.T) {
+			p, err := ParsePorts(tc.ports)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, p)
+			assert.Equal(t, tc.str, p.String())
+		})
+	}
+
+	for _, ports := range []string{"65536", "100:90", "80,,443", "1,2,3,4,5,6,7,8,9,10,11,12,13,14,15:16", "a b"} {
+		_, err := ParsePorts(ports)
+		assert.ErrorIs(t, err, ErrInvalidPort, ports)
+	}
+}
+
+func TestPortSpec_Contains(t *testing.T) {
+	p, err := ParsePorts("80,443,8000:8100")
+	assert.NoError(t, err)
+	assert.True(t, p.Contains(443))
+	assert.True(t, p.Contains(8050))
+	assert.False(t, p.Contains(22))
+
+	p, err = ParsePorts("!22")
+	assert.NoError(t, err)
+	assert.False(t, p.Contains(22))
+	assert.True(t, p.Contains(80))
+
+	assert.True(t, PortSpec{}.Contains(22))
+}
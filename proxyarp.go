@@ -0,0 +1,217 @@
+package goshorewall
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrProxyArpAlreadyExists     = errors.New("proxyarp entry already exists")
+	ErrProxyArpNotFound          = errors.New("proxyarp entry not found")
+	ErrProxyArpInterfaceNotFound = errors.New("proxyarp interface not found in interfaces file")
+	ErrProxyArpExternalNotFound  = errors.New("proxyarp external interface not found in interfaces file")
+)
+
+// ProxyArp represents an entry of the Shorewall proxyarp file.
+type ProxyArp struct {
+	Address    string
+	Interface  string
+	External   string
+	HaveRoute  string
+	Persistent string
+	// Comment is the ?COMMENT annotation of the entry. It is not considered
+	// when comparing entries.
+	Comment string
+	// Location is the file and line the entry was read from.
+	Location Location
+}
+
+func (p ProxyArp) Compare(other ProxyArp) int {
+	if cmp := strings.Compare(p.Address, other.Address); cmp != 0 {
+		return cmp
+	}
+	if cmp := strings.Compare(p.Interface, other.Interface); cmp != 0 {
+		return cmp
+	}
+	return strings.Compare(p.External, other.External)
+}
+
+func (p ProxyArp) Equals(other ProxyArp) bool {
+	return p.Address == other.Address && p.Interface == other.Interface && p.External == other.External
+}
+
+func (p ProxyArp) Format() string {
+	p = p.fillEmpty()
+	line := strings.TrimRight(formatColumns(proxyArpColumns, p.Address, p.Interface, p.External, p.HaveRoute, p.Persistent), "\t")
+	return formatWithComment(p.Comment, line)
+}
+
+// FormatNamed formats the proxyarp entry in the column=value form.
+func (p ProxyArp) FormatNamed() string {
+	return formatWithComment(p.Comment, formatNamedColumns(proxyArpColumns, p.Address, p.Interface, p.External, p.HaveRoute, p.Persistent))
+}
+
+// Validate checks that the INTERFACE and EXTERNAL columns reference
+// interfaces declared in the interfaces file.
+func (p ProxyArp) Validate(interfaces []Interface) error {
+	if !containsInterfaceName(interfaces, p.Interface) {
+		return fmt.Errorf("%w: %s", ErrProxyArpInterfaceNotFound, p.Interface)
+	}
+	if !containsInterfaceName(interfaces, p.External) {
+		return fmt.Errorf("%w: %s", ErrProxyArpExternalNotFound, p.External)
+	}
+	return nil
+}
+
+// fillEmpty fills empty fields with "-" where necessary
+func (p ProxyArp) fillEmpty() ProxyArp {
+	if p.HaveRoute == "" && p.Persistent != "" {
+		p.HaveRoute = "-"
+	}
+	return p
+}
+
+func ProxyArps() ([]ProxyArp, error) {
+	entries, _, err := ProxyArpsWithOptions(ReadOptions{})
+	return entries, err
+}
+
+// ProxyArpsWithOptions reads the proxyarp file, following includes and evaluating
+// conditional directives as configured by opts. In lenient mode the lines
+// that could not be parsed are returned as warnings, in strict mode they
+// are returned as a ParseErrors error.
+func ProxyArpsWithOptions(opts ReadOptions) ([]ProxyArp, []ParseError, error) {
+	lines, err := loadConfigFile(proxyArpFile, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	issues := &parseIssues{}
+	return result(parseProxyArpsLines(lines, issues), issues, opts.Strict)
+}
+
+func AddProxyArp(proxyArp ProxyArp) error {
+	if err := validateProxyArpInterfaces(fullInterfacesFile, proxyArp); err != nil {
+		return err
+	}
+	return readWriteFile(fullProxyArpFile, addProxyArpBuff, proxyArp)
+}
+
+func RemoveProxyArp(proxyArp ProxyArp) error {
+	return readWriteFile(fullProxyArpFile, removeProxyArpBuff, proxyArp)
+}
+
+func UpdateProxyArp(from, to ProxyArp) error {
+	if err := validateProxyArpInterfaces(fullInterfacesFile, to); err != nil {
+		return err
+	}
+	return readWriteFile(fullProxyArpFile, updateProxyArpBuff, entryUpdate[ProxyArp]{old: from, new: to})
+}
+
+func getProxyArpsBuff(buff []byte) ([]ProxyArp, error) {
+	return parseProxyArps(buff), nil
+}
+
+func addProxyArpBuff(buff []byte, proxyArp ProxyArp) ([]byte, error) {
+	proxyArps, err := getProxyArpsBuff(buff)
+	if err != nil {
+		return nil, err
+	}
+
+	if slices.ContainsFunc(proxyArps, func(p ProxyArp) bool {
+		return p.Equals(proxyArp)
+	}) {
+		return nil, ErrProxyArpAlreadyExists
+	}
+
+	return fmt.Appendf(buff, "%s\n", proxyArp.Format()), nil
+}
+
+// removeProxyArpBuff removes the first line matching proxyArp, leaving the
+// other lines of buff untouched.
+func removeProxyArpBuff(buff []byte, proxyArp ProxyArp) ([]byte, error) {
+	t := parseCST(buff)
+	i, _ := t.find(func(l logicalLine, _ directiveState) bool {
+		return slices.ContainsFunc(parseProxyArpsLines([]logicalLine{l}, nil), proxyArp.Equals)
+	})
+	if i == -1 {
+		return nil, ErrProxyArpNotFound
+	}
+	t.remove(i)
+	return t.Bytes(), nil
+}
+
+// updateProxyArpBuff replaces the proxyarp entry matching u.old with u.new,
+// leaving the other lines of buff untouched.
+func updateProxyArpBuff(buff []byte, u entryUpdate[ProxyArp]) ([]byte, error) {
+	proxyArps, err := getProxyArpsBuff(buff)
+	if err != nil {
+		return nil, err
+	}
+	if !u.new.Equals(u.old) && slices.ContainsFunc(proxyArps, u.new.Equals) {
+		return nil, ErrProxyArpAlreadyExists
+	}
+
+	t := parseCST(buff)
+	i, state := t.find(func(l logicalLine, _ directiveState) bool {
+		return slices.ContainsFunc(parseProxyArpsLines([]logicalLine{l}, nil), u.old.Equals)
+	})
+	if i == -1 {
+		return nil, ErrProxyArpNotFound
+	}
+	proxyArp := u.new
+	proxyArp.Comment = t.entryComment(i, state, proxyArp.Comment)
+	t.replace(i, proxyArp.Format())
+	return t.Bytes(), nil
+}
+
+// validateProxyArpInterfaces reads the interfaces file at interfacesPath and
+// validates the proxyarp entry against it.
+func validateProxyArpInterfaces(interfacesPath string, proxyArp ProxyArp) error {
+	buff, err := os.ReadFile(interfacesPath)
+	if err != nil {
+		return fmt.Errorf("failed to read interfaces file: %w", err)
+	}
+	interfaces, err := getInterfacesBuff(buff)
+	if err != nil {
+		return err
+	}
+	return proxyArp.Validate(interfaces)
+}
+
+func containsInterfaceName(interfaces []Interface, name string) bool {
+	return slices.ContainsFunc(interfaces, func(i Interface) bool {
+		return i.Name == name
+	})
+}
+
+func parseProxyArps(data []byte) []ProxyArp {
+	return parseProxyArpsLines(tokenize(data), nil)
+}
+
+func parseProxyArpsLines(lines []logicalLine, issues *parseIssues) (proxyArps []ProxyArp) {
+	var state directiveState
+	for _, l := range lines {
+		parts, ok := entryColumns(l, &state, proxyArpColumns, 3, issues)
+		if !ok {
+			continue
+		}
+		proxyArp := ProxyArp{
+			Address:   parts[0],
+			Interface: parts[1],
+			External:  parts[2],
+			Comment:   state.comment,
+			Location:  l.location(),
+		}
+		if len(parts) > 3 {
+			proxyArp.HaveRoute = parts[3]
+		}
+		if len(parts) > 4 {
+			proxyArp.Persistent = parts[4]
+		}
+		proxyArps = append(proxyArps, proxyArp)
+	}
+	return
+}
@@ -0,0 +1,125 @@
+package goshorewall
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const proxyarp01 = `
+#ADDRESS	INTERFACE	EXTERNAL	HAVEROUTE	PERSISTENT
+155.186.235.6	eth1		eth0		no		yes
+155.186.235.7	eth1		eth0
+155.186.235.8	eth2		eth0		yes
+`
+
+func TestGetProxyArpsBuff(t *testing.T) {
+	proxyArps, err := getProxyArpsBuff([]byte(proxyarp01))
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, 3, len(proxyArps), "expected 3 proxyarp entries")
+
+	assert.Equal(t, "155.186.235.6", proxyArps[0].Address)
+	assert.Equal(t, "eth1", proxyArps[0].Interface)
+	assert.Equal(t, "eth0", proxyArps[0].External)
+	assert.Equal(t, "no", proxyArps[0].HaveRoute)
+	assert.Equal(t, "yes", proxyArps[0].Persistent)
+
+	assert.Equal(t, "155.186.235.7", proxyArps[1].Address)
+	assert.Equal(t, "", proxyArps[1].HaveRoute)
+	assert.Equal(t, "", proxyArps[1].Persistent)
+
+	assert.Equal(t, "eth2", proxyArps[2].Interface)
+	assert.Equal(t, "yes", proxyArps[2].HaveRoute)
+}
+
+func TestAddProxyArpBuff(t *testing.T) {
+	newProxyArp := ProxyArp{Address: "155.186.235.9", Interface: "eth1", External: "eth0", Persistent: "yes"}
+	buff, err := addProxyArpBuff([]byte(proxyarp01), newProxyArp)
+	assert.NoError(t, err, "expected no error")
+
+	proxyArps, err := getProxyArpsBuff(buff)
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, 4, len(proxyArps), "expected 4 proxyarp entries")
+	assert.Equal(t, "155.186.235.9", proxyArps[3].Address)
+	assert.Equal(t, "-", proxyArps[3].HaveRoute)
+	assert.Equal(t, "yes", proxyArps[3].Persistent)
+}
+
+func TestAddProxyArpBuff_AlreadyExists(t *testing.T) {
+	_, err := addProxyArpBuff([]byte(proxyarp01), ProxyArp{Address: "155.186.235.7", Interface: "eth1", External: "eth0"})
+	assert.ErrorIs(t, err, ErrProxyArpAlreadyExists, "expected ErrProxyArpAlreadyExists")
+}
+
+func TestRemoveProxyArpBuff(t *testing.T) {
+	buff, err := removeProxyArpBuff([]byte(proxyarp01), ProxyArp{Address: "155.186.235.7", Interface: "eth1", External: "eth0"})
+	assert.NoError(t, err, "expected no error")
+
+	proxyArps, err := getProxyArpsBuff(buff)
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, 2, len(proxyArps), "expected 2 proxyarp entries")
+	assert.Equal(t, "155.186.235.6", proxyArps[0].Address)
+	assert.Equal(t, "155.186.235.8", proxyArps[1].Address)
+}
+
+func TestRemoveProxyArpBuff_NotFound(t *testing.T) {
+	_, err := removeProxyArpBuff([]byte(proxyarp01), ProxyArp{Address: "10.0.0.1", Interface: "eth1", External: "eth0"})
+	assert.ErrorIs(t, err, ErrProxyArpNotFound, "expected ErrProxyArpNotFound")
+}
+
+func TestProxyArpValidate(t *testing.T) {
+	interfaces := parseInterfaces([]byte(interfaces01))
+
+	err := ProxyArp{Address: "155.186.235.6", Interface: "eth5", External: "eth0"}.Validate(interfaces)
+	assert.NoError(t, err, "expected no error")
+
+	err = ProxyArp{Address: "155.186.235.6", Interface: "eth9", External: "eth0"}.Validate(interfaces)
+	assert.ErrorIs(t, err, ErrProxyArpInterfaceNotFound, "expected ErrProxyArpInterfaceNotFound")
+
+	err = ProxyArp{Address: "155.186.235.6", Interface: "eth5", External: "eth9"}.Validate(interfaces)
+	assert.ErrorIs(t, err, ErrProxyArpExternalNotFound, "expected ErrProxyArpExternalNotFound")
+
+	err = ProxyNdp{Address: "2001:db8::1", Interface: "eth9", External: "eth0"}.Validate(interfaces)
+	assert.ErrorIs(t, err, ErrProxyNdpInterfaceNotFound, "expected ErrProxyNdpInterfaceNotFound")
+}
+
+func TestParseProxyNdps(t *testing.T) {
+	data := []byte(`
+#ADDRESS	INTERFACE	EXTERNAL	HAVEROUTE	PERSISTENT
+2001:db8::10	eth1		eth0		no		yes
+`)
+	proxyNdps := parseProxyNdps(data)
+	assert.Equal(t, 1, len(proxyNdps), "expected 1 proxyndp entry")
+	assert.Equal(t, "2001:db8::10", proxyNdps[0].Address)
+	assert.Equal(t, "2001:db8::10\teth1\teth0\tno\tyes", proxyNdps[0].Format())
+}
+
+func TestApp_AddProxyArp_HoldsInterfacesLock(t *testing.T) {
+	lockDirPath = "/tmp/.goshorewall"
+
+	dir := t.TempDir()
+	writeFile(t, path.Join(dir, interfacesFile), "net\teth0\nloc\teth1\n")
+	writeFile(t, path.Join(dir, proxyArpFile), "")
+	app, err := NewAppWithBasePath(dir)
+	assert.NoError(t, err)
+
+	lock, err := takeLock(app.lockComponent("interfaces"))
+	assert.NoError(t, err)
+	assert.NoError(t, lock.Lock())
+	done := make(chan error, 1)
+	go func() {
+		done <- app.AddProxyArp(ProxyArp{Address: "155.186.235.6", Interface: "eth1", External: "eth0"})
+	}()
+	select {
+	case <-done:
+		t.Fatal("the proxyarp file was written without the interfaces lock")
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.NoError(t, lock.Unlock())
+	assert.NoError(t, <-done)
+
+	entries, err := app.ProxyArps()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
@@ -0,0 +1,174 @@
+package goshorewall
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+)
+
+var (
+	ErrProxyNdpAlreadyExists     = errors.New("proxyndp entry already exists")
+	ErrProxyNdpNotFound          = errors.New("proxyndp entry not found")
+	ErrProxyNdpInterfaceNotFound = errors.New("proxyndp interface not found in interfaces file")
+	ErrProxyNdpExternalNotFound  = errors.New("proxyndp external interface not found in interfaces file")
+)
+
+// ProxyNdp represents an entry of the Shorewall proxyndp file. It shares the
+// column layout of the proxyarp file, with IPv6 addresses in ADDRESS.
+type ProxyNdp ProxyArp
+
+func (p ProxyNdp) Compare(other ProxyNdp) int {
+	return ProxyArp(p).Compare(ProxyArp(other))
+}
+
+func (p ProxyNdp) Equals(other ProxyNdp) bool {
+	return ProxyArp(p).Equals(ProxyArp(other))
+}
+
+func (p ProxyNdp) Format() string {
+	return ProxyArp(p).Format()
+}
+
+// FormatNamed formats the proxyndp entry in the column=value form.
+func (p ProxyNdp) FormatNamed() string {
+	return ProxyArp(p).FormatNamed()
+}
+
+// Validate checks that the INTERFACE and EXTERNAL columns reference
+// interfaces declared in the interfaces file.
+func (p ProxyNdp) Validate(interfaces []Interface) error {
+	if !containsInterfaceName(interfaces, p.Interface) {
+		return fmt.Errorf("%w: %s", ErrProxyNdpInterfaceNotFound, p.Interface)
+	}
+	if !containsInterfaceName(interfaces, p.External) {
+		return fmt.Errorf("%w: %s", ErrProxyNdpExternalNotFound, p.External)
+	}
+	return nil
+}
+
+// ProxyNdps returns the entries of the proxyndp file of the shorewall6
+// configuration.
+func ProxyNdps() ([]ProxyNdp, error) {
+	entries, _, err := ProxyNdpsWithOptions(ReadOptions{})
+	return entries, err
+}
+
+// ProxyNdpsWithOptions reads the proxyndp file of the shorewall6
+// configuration, following includes and evaluating conditional directives as
+// configured by opts. opts.Family is ignored. In lenient mode the lines that
+// could not be parsed are returned as warnings, in strict mode they are
+// returned as a ParseErrors error.
+func ProxyNdpsWithOptions(opts ReadOptions) ([]ProxyNdp, []ParseError, error) {
+	opts.Family = IPv6
+	lines, err := loadConfigFile(proxyNdpFile, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	issues := &parseIssues{}
+	return result(parseProxyNdpsLines(lines, issues), issues, opts.Strict)
+}
+
+func AddProxyNdp(proxyNdp ProxyNdp) error {
+	if err := validateProxyNdpInterfaces(fullInterfaces6File, proxyNdp); err != nil {
+		return err
+	}
+	return readWriteFile(fullProxyNdpFile, addProxyNdpBuff, proxyNdp)
+}
+
+func RemoveProxyNdp(proxyNdp ProxyNdp) error {
+	return readWriteFile(fullProxyNdpFile, removeProxyNdpBuff, proxyNdp)
+}
+
+func UpdateProxyNdp(from, to ProxyNdp) error {
+	if err := validateProxyNdpInterfaces(fullInterfaces6File, to); err != nil {
+		return err
+	}
+	return readWriteFile(fullProxyNdpFile, updateProxyNdpBuff, entryUpdate[ProxyNdp]{old: from, new: to})
+}
+
+func getProxyNdpsBuff(buff []byte) ([]ProxyNdp, error) {
+	return parseProxyNdps(buff), nil
+}
+
+func addProxyNdpBuff(buff []byte, proxyNdp ProxyNdp) ([]byte, error) {
+	proxyNdps, err := getProxyNdpsBuff(buff)
+	if err != nil {
+		return nil, err
+	}
+
+	if slices.ContainsFunc(proxyNdps, func(p ProxyNdp) bool {
+		return p.Equals(proxyNdp)
+	}) {
+		return nil, ErrProxyNdpAlreadyExists
+	}
+
+	return fmt.Appendf(buff, "%s\n", proxyNdp.Format()), nil
+}
+
+// removeProxyNdpBuff removes the first line matching proxyNdp, leaving the
+// other lines of buff untouched.
+func removeProxyNdpBuff(buff []byte, proxyNdp ProxyNdp) ([]byte, error) {
+	t := parseCST(buff)
+	i, _ := t.find(func(l logicalLine, _ directiveState) bool {
+		return slices.ContainsFunc(parseProxyNdpsLines([]logicalLine{l}, nil), proxyNdp.Equals)
+	})
+	if i == -1 {
+		return nil, ErrProxyNdpNotFound
+	}
+	t.remove(i)
+	return t.Bytes(), nil
+}
+
+// updateProxyNdpBuff replaces the proxyndp entry matching u.old with u.new,
+// leaving the other lines of buff untouched.
+func updateProxyNdpBuff(buff []byte, u entryUpdate[ProxyNdp]) ([]byte, error) {
+	proxyNdps, err := getProxyNdpsBuff(buff)
+	if err != nil {
+		return nil, err
+	}
+	if !u.new.Equals(u.old) && slices.ContainsFunc(proxyNdps, u.new.Equals) {
+		return nil, ErrProxyNdpAlreadyExists
+	}
+
+	t := parseCST(buff)
+	i, state := t.find(func(l logicalLine, _ directiveState) bool {
+		return slices.ContainsFunc(parseProxyNdpsLines([]logicalLine{l}, nil), u.old.Equals)
+	})
+	if i == -1 {
+		return nil, ErrProxyNdpNotFound
+	}
+	proxyNdp := u.new
+	proxyNdp.Comment = t.entryComment(i, state, proxyNdp.Comment)
+	t.replace(i, proxyNdp.Format())
+	return t.Bytes(), nil
+}
+
+// validateProxyNdpInterfaces reads the interfaces file at interfacesPath and
+// validates the proxyndp entry against it.
+func validateProxyNdpInterfaces(interfacesPath string, proxyNdp ProxyNdp) error {
+	buff, err := os.ReadFile(interfacesPath)
+	if err != nil {
+		return fmt.Errorf("failed to read interfaces file: %w", err)
+	}
+	interfaces, err := getInterfacesBuff(buff)
+	if err != nil {
+		return err
+	}
+	return proxyNdp.Validate(interfaces)
+}
+
+func parseProxyNdps(data []byte) []ProxyNdp {
+	return toProxyNdps(parseProxyArps(data))
+}
+
+func parseProxyNdpsLines(lines []logicalLine, issues *parseIssues) []ProxyNdp {
+	return toProxyNdps(parseProxyArpsLines(lines, issues))
+}
+
+func toProxyNdps(proxyArps []ProxyArp) (proxyNdps []ProxyNdp) {
+	for _, p := range proxyArps {
+		proxyNdps = append(proxyNdps, ProxyNdp(p))
+	}
+	return
+}
@@ -0,0 +1,261 @@
+package goshorewall
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+var ErrDanglingReference = errors.New("dangling reference")
+
+// PreCommitHook is called by the mutations of the blocks of an App instance,
+// in the zones, interfaces, policy, rules, snat, proxyarp, proxyndp,
+// conntrack and actions files, with the new content of the files they write,
+// by path, before writing any of them. A mutation editing several files, such
+// as App.RemoveZoneCascade, calls it once with all of them. An error aborts
+// the mutation and is returned to the caller. The shorewall.conf file and the
+// bodies of macros and actions are written without calling it.
+type PreCommitHook func(files map[string][]byte) error
+
+// referenceSet holds the entries of the files checked for dangling
+// references, and analysed by AnalyzePolicies.
+type referenceSet struct {
+	zones      []Zone
+	interfaces []Interface
+	hosts      []Host
+	policies   []Policy
+	rules      []Rule
+	snats      []Snat
+}
+
+// loadReferenceSet reads the files of the configuration stored in configDir.
+// The content of the files listed in override is used instead of the one on
+// disk. Missing files are considered empty.
+func loadReferenceSet(configDir, confFile string, opts ReadOptions, override map[string][]byte) (referenceSet, error) {
+	ld, err := newFileLoader(configDir, confFile, opts)
+	if err != nil {
+		return referenceSet{}, err
+	}
+	load := func(name string) ([]logicalLine, error) {
+		p := path.Join(configDir, name)
+		if buff, ok := override[p]; ok {
+			return ld.loadBuff(p, buff)
+		}
+		lines, err := ld.load(p)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return lines, err
+	}
+
+	var s referenceSet
+	var errs []error
+	for _, f := range []struct {
+		name  string
+		parse func([]logicalLine)
+	}{
+		{zonesFile, func(l []logicalLine) { s.zones = parseZonesLines(l, nil) }},
+		{interfacesFile, func(l []logicalLine) { s.interfaces = parseInterfacesLines(l, nil) }},
+		{hostsFile, func(l []logicalLine) { s.hosts = parseHostsLines(l, nil) }},
+		{policyFile, func(l []logicalLine) { s.policies = parsePoliciesLines(l, nil) }},
+		{rulesFile, func(l []logicalLine) { s.rules = parseRuleLines(l, 3, directiveState{}, nil) }},
+		{snatFile, func(l []logicalLine) { s.snats = parseSnatsLines(l, nil) }},
+	} {
+		lines, err := load(f.name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		f.parse(lines)
+	}
+	return s, errors.Join(errs...)
+}
+
+// check returns the dangling references of the set: zones and interfaces
+// used by an entry but not declared in the zones and interfaces files.
+func (s referenceSet) check() []ParseError {
+	zones := make([]string, len(s.zones))
+	firewall := false
+	for i, z := range s.zones {
+		zones[i] = zoneName(z)
+		firewall = firewall || z.Type == "firewall"
+	}
+	var problems []ParseError
+	add := func(loc Location, column int, format string, args ...any) {
+		problems = append(problems, ParseError{
+			File:   loc.File,
+			Line:   loc.Line,
+			Column: column,
+			Reason: fmt.Sprintf(format, args...),
+		})
+	}
+	checkZones := func(loc Location, column int, spec string) {
+		for _, name := range zoneReferences(spec) {
+			if isFirewallReference(name) {
+				if !firewall {
+					add(loc, column, "no firewall zone for %s", name)
+				}
+			} else if !slices.Contains(zones, name) {
+				add(loc, column, "undefined zone %q", name)
+			}
+		}
+	}
+	checkInterface := func(loc Location, column int, name string) {
+		if !isInterfaceDeclared(s.interfaces, name) {
+			add(loc, column, "undefined interface %q", name)
+		}
+	}
+
+	for _, i := range s.interfaces {
+		if i.Zone != "-" {
+			checkZones(i.Location, 1, i.Zone)
+		}
+	}
+	for _, h := range s.hosts {
+		checkZones(h.Location, 1, h.Zone)
+		checkInterface(h.Location, 2, h.Interface())
+	}
+	for _, p := range s.policies {
+		checkZones(p.Location, 1, p.Source)
+		checkZones(p.Location, 2, p.Destination)
+	}
+	for _, r := range s.rules {
+		checkZones(r.Location, 2, r.Source)
+		// The DEST column of REDIRECT rules is a port of the firewall
+		if actionBaseName(r.Action) != "REDIRECT" {
+			checkZones(r.Location, 3, r.Destination)
+		}
+	}
+	for _, sn := range s.snats {
+		for _, name := range snatInterfaces(sn.Destination) {
+			checkInterface(sn.Location, 3, name)
+		}
+	}
+	return problems
+}
+
+// zoneReferences returns the zones referenced by a SOURCE or DEST column:
+// the comma separated zones before the first ':', including the zones
+// excluded with '!'. Keywords and shell variables other than $FW are left
+// out.
+func zoneReferences(spec string) []string {
+	zonePart, _, _ := strings.Cut(spec, ":")
+	var names []string
+	for _, name := range strings.FieldsFunc(zonePart, func(r rune) bool { return r == ',' || r == '!' }) {
+		name = strings.TrimRight(name, "+-")
+		switch {
+		case name == "", name == "all", name == "any", name == "none", name == "SOURCE", name == "DEST":
+		case strings.HasPrefix(name, "$") && !isFirewallReference(name):
+		default:
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func isFirewallReference(name string) bool {
+	return name == "$FW" || name == "${FW}"
+}
+
+// isInterfaceDeclared reports whether name is declared in interfaces, either
+// directly or through a wildcard such as "ppp+".
+func isInterfaceDeclared(interfaces []Interface, name string) bool {
+	return slices.ContainsFunc(interfaces, func(i Interface) bool {
+		if prefix, ok := strings.CutSuffix(i.Name, "+"); ok {
+			return strings.HasPrefix(name, prefix)
+		}
+		return i.Name == name
+	})
+}
+
+// snatInterfaces returns the interfaces of the DEST column of a snat entry.
+func snatInterfaces(dest string) []string {
+	if dest == "" || dest == "-" {
+		return nil
+	}
+	ifaces, _, _ := strings.Cut(dest, ":")
+	var names []string
+	for _, name := range strings.Split(ifaces, ",") {
+		if name = strings.TrimPrefix(name, "!"); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// ValidateReferences reads the zones, interfaces, hosts, policy, rules and
+// snat files of the configuration of opts.Family and returns the dangling
+// references they contain: zones and interfaces used by an entry but never
+// declared.
+func ValidateReferences(opts ReadOptions) ([]ParseError, error) {
+	s, err := loadReferenceSet(opts.Family.configPath(), opts.Family.confFile(), opts, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.check(), nil
+}
+
+// ValidateReferences is ValidateReferences for the configuration managed by
+// the App instance. It checks the whole files, not only the blocks of the
+// App instance.
+func (a *App) ValidateReferences(opts ReadOptions) ([]ParseError, error) {
+	opts.Family = a.family
+	s, err := loadReferenceSet(a.basePath, a.family.confFile(), opts, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.check(), nil
+}
+
+// ReferenceCheck returns a PreCommitHook rejecting the mutations that add
+// dangling references to the configuration managed by the App instance, with
+// an error wrapping ErrDanglingReference and the ParseErrors found. Dangling
+// references already present are ignored.
+//
+// The hook is only run once set with App.SetPreCommitHook. The mutations of
+// the App instance then hold the locks of the files it reads, so it does not
+// see a file half written by another mutation of an App instance.
+func (a *App) ReferenceCheck() PreCommitHook {
+	return func(files map[string][]byte) error {
+		opts := ReadOptions{Family: a.family}
+		before, err := loadReferenceSet(a.basePath, a.family.confFile(), opts, nil)
+		if err != nil {
+			return err
+		}
+		after, err := loadReferenceSet(a.basePath, a.family.confFile(), opts, files)
+		if err != nil {
+			return err
+		}
+		if added := newProblems(before.check(), after.check()); len(added) > 0 {
+			return fmt.Errorf("%w: %w", ErrDanglingReference, ParseErrors(added))
+		}
+		return nil
+	}
+}
+
+// newProblems returns the problems of after that are not in before. As
+// lines move when a file is edited, problems are compared without their
+// line.
+func newProblems(before, after []ParseError) []ParseError {
+	type key struct {
+		file, reason string
+		column       int
+	}
+	seen := map[key]int{}
+	for _, pe := range before {
+		seen[key{pe.File, pe.Reason, pe.Column}]++
+	}
+	var added []ParseError
+	for _, pe := range after {
+		k := key{pe.File, pe.Reason, pe.Column}
+		if seen[k] > 0 {
+			seen[k]--
+			continue
+		}
+		added = append(added, pe)
+	}
+	return added
+}
@@ -0,0 +1,141 @@
+package goshorewall
+
+import (
+	"errors"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeReferenceConfig(t *testing.T, dir string) {
+	t.Helper()
+	writeFile(t, path.Join(dir, zonesFile), "fw\tfirewall\nnet\tipv4\nloc\tipv4\n")
+	writeFile(t, path.Join(dir, interfacesFile), "?FORMAT 2\nnet\teth0\tdhcp\nloc\teth1\n-\tppp+\n")
+	writeFile(t, path.Join(dir, hostsFile), "loc\teth1:192.168.1.0/24\nvpn\twg0:10.8.0.0/24\n")
+	writeFile(t, path.Join(dir, policyFile), "$FW\tnet\tACCEPT\nloc\tall\tACCEPT\ndmz\tnet\tDROP\nall\tall\tREJECT\tinfo\n")
+	writeFile(t, path.Join(dir, rulesFile), "ACCEPT\tnet:1.2.3.4,5.6.7.8\t$FW\ttcp\t22\n"+
+		"DNAT\tnet\tdmz:10.0.0.2\ttcp\t80\n"+
+		"REDIRECT\tloc\t3128\ttcp\t80\n"+
+		"ACCEPT\tall!loc,guest\tnet\n")
+	writeFile(t, path.Join(dir, snatFile), "MASQUERADE\t192.168.1.0/24\teth0\nMASQUERADE\t10.0.0.0/8\tppp0\nSNAT(1.2.3.4)\t-\teth2\n")
+}
+
+func TestValidateReferences(t *testing.T) {
+	dir := t.TempDir()
+	writeReferenceConfig(t, dir)
+	app, err := NewAppWithBasePath(dir)
+	assert.NoError(t, err)
+
+	problems, err := app.ValidateReferences(ReadOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []ParseError{
+		{File: path.Join(dir, hostsFile), Line: 2, Column: 1, Reason: `undefined zone "vpn"`},
+		{File: path.Join(dir, hostsFile), Line: 2, Column: 2, Reason: `undefined interface "wg0"`},
+		{File: path.Join(dir, policyFile), Line: 3, Column: 1, Reason: `undefined zone "dmz"`},
+		{File: path.Join(dir, rulesFile), Line: 2, Column: 3, Reason: `undefined zone "dmz"`},
+		{File: path.Join(dir, rulesFile), Line: 4, Column: 2, Reason: `undefined zone "guest"`},
+		{File: path.Join(dir, snatFile), Line: 3, Column: 3, Reason: `undefined interface "eth2"`},
+	}, problems)
+}
+
+func TestReferenceCheck(t *testing.T) {
+	lockDirPath = "/tmp/.goshorewall"
+
+	dir := t.TempDir()
+	writeReferenceConfig(t, dir)
+	app, err := NewAppWithBasePath(dir)
+	assert.NoError(t, err)
+	app.SetPreCommitHook(app.ReferenceCheck())
+
+	err = app.AddRule(Rule{Action: "ACCEPT", Source: "web", Destination: "$FW", Protocol: "tcp", Dport: "443"})
+	assert.ErrorIs(t, err, ErrDanglingReference)
+	var perrs ParseErrors
+	assert.True(t, errors.As(err, &perrs))
+	assert.Equal(t, []ParseError{{File: path.Join(dir, rulesFile), Line: 6, Column: 2, Reason: `undefined zone "web"`}}, []ParseError(perrs))
+	rules, err := app.Rules()
+	assert.NoError(t, err)
+	assert.Empty(t, rules)
+
+	err = app.AddInterface(Interface{Zone: "web", Name: "eth3"})
+	assert.ErrorIs(t, err, ErrDanglingReference)
+
+	assert.NoError(t, app.AddZone(Zone{Name: "web", Type: "ipv4"}))
+	assert.NoError(t, app.AddInterface(Interface{Zone: "web", Name: "eth3"}))
+	assert.NoError(t, app.AddRule(Rule{Action: "ACCEPT", Source: "web", Destination: "$FW", Protocol: "tcp", Dport: "443"}))
+	// Problems already in the configuration do not block other changes
+	assert.NoError(t, app.AddPolicy(Policy{Source: "web", Destination: "net", Policy: "ACCEPT"}))
+
+	err = app.RemoveZone("web")
+	assert.ErrorIs(t, err, ErrDanglingReference)
+}
+
+func TestApp_WriteLocks(t *testing.T) {
+	app, err := NewAppWithFamily(IPv6, t.TempDir())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"rules6"}, app.writeLocks("rules6"))
+
+	app.SetPreCommitHook(app.ReferenceCheck())
+	refs := []string{"zones6", "interfaces6", "hosts6", "policies6", "rules6", "snats6"}
+	assert.Equal(t, refs, app.writeLocks("rules6"))
+	assert.Equal(t, append(refs, "proxyndp6"), app.writeLocks("proxyndp6"))
+}
+
+func TestReferenceCheck_Concurrent(t *testing.T) {
+	lockDirPath = "/tmp/.goshorewall"
+
+	dir := t.TempDir()
+	writeReferenceConfig(t, dir)
+	app, err := NewAppWithBasePath(dir)
+	assert.NoError(t, err)
+	app.SetPreCommitHook(app.ReferenceCheck())
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		errs <- app.AddZone(Zone{Name: "dmz", Type: "ipv4"})
+	}()
+	go func() {
+		defer wg.Done()
+		errs <- app.AddPolicy(Policy{Source: "loc", Destination: "net", Policy: "ACCEPT"})
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+}
+
+func TestApp_PreCommitHook_ProxyAndActions(t *testing.T) {
+	lockDirPath = "/tmp/.goshorewall"
+
+	dir := t.TempDir()
+	writeFile(t, path.Join(dir, interfacesFile), "net\teth0\nloc\teth1\n")
+	writeFile(t, path.Join(dir, proxyArpFile), "")
+	writeFile(t, path.Join(dir, actionsFile), "")
+	app, err := NewAppWithBasePath(dir)
+	assert.NoError(t, err)
+
+	var written []string
+	app.SetPreCommitHook(func(files map[string][]byte) error {
+		for p := range files {
+			written = append(written, path.Base(p))
+		}
+		return nil
+	})
+	proxyArp := ProxyArp{Address: "155.186.235.6", Interface: "eth1", External: "eth0"}
+	assert.NoError(t, app.AddProxyArp(proxyArp))
+	assert.NoError(t, app.RemoveProxyArp(proxyArp))
+	assert.NoError(t, app.AddAction(Action{Name: "Ours"}))
+	assert.NoError(t, app.RemoveAction("Ours"))
+	assert.Equal(t, []string{proxyArpFile, proxyArpFile, actionsFile, actionsFile}, written)
+
+	app.SetPreCommitHook(func(map[string][]byte) error { return errors.New("rejected") })
+	assert.Error(t, app.AddProxyArp(proxyArp))
+	entries, err := app.ProxyArps()
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
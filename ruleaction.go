@@ -0,0 +1,264 @@
+package goshorewall
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrInvalidRuleAction = errors.New("invalid rule action")
+	ErrInvalidLogLevel   = errors.New("invalid log level")
+)
+
+// logLevels are the syslog levels accepted in the log level of a rule
+// action.
+var logLevels = []string{
+	"emerg", "panic", "alert", "crit", "err", "error", "warning", "warn",
+	"notice", "info", "debug", "0", "1", "2", "3", "4", "5", "6", "7",
+}
+
+// logTargets are the log levels taking parameters, as in "NFLOG(1,0,1)".
+var logTargets = []string{"NFLOG", "ULOG", "LOGMARK"}
+
+// parameterizedActions are the built-in actions taking a parameter, as in
+// "MARK(1)" or "NFQUEUE(2)". The other built-in actions take none.
+var parameterizedActions = []string{
+	"ADD", "AUDIT", "CONMARK", "DEL", "DSCP", "HELPER", "INLINE", "IPTABLES",
+	"IP6TABLES", "MARK", "NFLOG", "NFQUEUE", "REJECT", "A_REJECT", "TARPIT",
+	"ULOG",
+}
+
+// RuleAction is the parsed form of the ACTION column of a rule,
+// name[+|-|!][(param)|/param][:level[:tag]], as in "SSH(ACCEPT):info:ssh",
+// "DNAT-" or "ACCEPT+". Pseudo actions such as ?COMMENT only have their
+// Directive set.
+type RuleAction struct {
+	// Name is the built-in action, the declared action or the macro invoked.
+	Name string
+	// Param is the parameter of a macro, "ACCEPT" in "SSH(ACCEPT)", or the
+	// comma separated parameters of an action, "3,60" in "Limit(3,60)".
+	Param string
+	// Slash reports whether the parameter is written in the legacy
+	// SSH/ACCEPT form.
+	Slash bool
+	// Plus is the "+" modifier, as in "ACCEPT+": the connection is exempted
+	// from the following DNAT and REDIRECT rules.
+	Plus bool
+	// Minus is the "-" modifier, as in "DNAT-": only the NAT rule is
+	// generated, without the matching ACCEPT rule.
+	Minus bool
+	// Bang is the "!" modifier, as in "ACCEPT!": the rule is not removed
+	// when it duplicates the policy.
+	Bang bool
+	// LogLevel and LogTag are the log suffix, "info" and "ssh" in
+	// "SSH(ACCEPT):info:ssh".
+	LogLevel string
+	LogTag   string
+	// Directive is the name of a pseudo action such as ?COMMENT, without
+	// the leading "?".
+	Directive string
+}
+
+// ParseRuleAction parses the ACTION column of a rule. Format returns the
+// parsed action unchanged.
+func ParseRuleAction(action string) (RuleAction, error) {
+	var a RuleAction
+	if d, ok := strings.CutPrefix(action, "?"); ok {
+		if d == "" {
+			return RuleAction{}, fmt.Errorf("%w: %q: empty directive", ErrInvalidRuleAction, action)
+		}
+		a.Directive = d
+		return a, nil
+	}
+
+	i := strings.IndexAny(action, "(/:")
+	if i == -1 {
+		i = len(action)
+	}
+	a.Name, action = action[:i], action[i:]
+	if name := strings.TrimRight(a.Name, "+-!"); len(a.Name)-len(name) > 1 {
+		return RuleAction{}, fmt.Errorf("%w: %q: more than one modifier", ErrInvalidRuleAction, a.Name)
+	} else if name != a.Name {
+		modifier := a.Name[len(name)]
+		a.Name, a.Plus, a.Minus, a.Bang = name, modifier == '+', modifier == '-', modifier == '!'
+	}
+	if err := validateActionName(a.Name); err != nil {
+		return RuleAction{}, fmt.Errorf("%w: %w", ErrInvalidRuleAction, err)
+	}
+
+	hasParam := strings.HasPrefix(action, "(") || strings.HasPrefix(action, "/")
+	switch {
+	case strings.HasPrefix(action, "("):
+		var err error
+		if a.Param, action, err = cutParenthesized(action); err != nil {
+			return RuleAction{}, fmt.Errorf("%w: %q: %w", ErrInvalidRuleAction, a.Name, err)
+		}
+	case strings.HasPrefix(action, "/"):
+		a.Slash = true
+		i := strings.IndexByte(action, ':')
+		if i == -1 {
+			i = len(action)
+		}
+		a.Param, action = action[1:i], action[i:]
+	}
+	if hasParam && a.Param == "" {
+		return RuleAction{}, fmt.Errorf("%w: %q: empty parameter", ErrInvalidRuleAction, a.Name)
+	}
+	if action == "" {
+		return a, nil
+	}
+
+	log, ok := strings.CutPrefix(action, ":")
+	if !ok {
+		return RuleAction{}, fmt.Errorf("%w: unexpected %q", ErrInvalidRuleAction, action)
+	}
+	a.LogLevel, a.LogTag, ok = cutLogLevel(log)
+	if a.LogLevel == "" || ok && a.LogTag == "" {
+		return RuleAction{}, fmt.Errorf("%w: %q: empty log level or tag", ErrInvalidRuleAction, log)
+	}
+	return a, nil
+}
+
+// cutParenthesized cuts the parenthesized prefix of s, allowing nested
+// parentheses, and returns its content and the remainder of s.
+func cutParenthesized(s string) (content, rest string, err error) {
+	depth := 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return s[1:i], s[i+1:], nil
+			}
+		}
+	}
+	return "", "", errors.New("unbalanced parentheses")
+}
+
+// cutLogLevel splits the log suffix of an action into the level and the
+// tag. The parameters of a level such as "NFLOG(1,0,1)" may contain colons.
+func cutLogLevel(log string) (level, tag string, found bool) {
+	if i := strings.IndexByte(log, '('); i != -1 && i < strings.IndexByte(log+":", ':') {
+		params, rest, err := cutParenthesized(log[i:])
+		if err == nil {
+			level = log[:i] + "(" + params + ")"
+			tag, found = strings.CutPrefix(rest, ":")
+			if !found && rest != "" {
+				return log, "", false
+			}
+			return level, tag, found
+		}
+	}
+	return strings.Cut(log, ":")
+}
+
+func (a RuleAction) String() string {
+	return a.Format()
+}
+
+// Format returns the action in the syntax of the ACTION column.
+func (a RuleAction) Format() string {
+	if a.Directive != "" {
+		return "?" + a.Directive
+	}
+	var b strings.Builder
+	b.WriteString(a.Name)
+	switch {
+	case a.Plus:
+		b.WriteByte('+')
+	case a.Minus:
+		b.WriteByte('-')
+	case a.Bang:
+		b.WriteByte('!')
+	}
+	switch {
+	case a.Slash:
+		b.WriteString("/" + a.Param)
+	case a.Param != "":
+		b.WriteString("(" + a.Param + ")")
+	}
+	if a.LogLevel != "" {
+		b.WriteString(":" + a.LogLevel)
+		if a.LogTag != "" {
+			b.WriteString(":" + a.LogTag)
+		}
+	}
+	return b.String()
+}
+
+// Base returns the built-in action deciding the fate of the packets matched
+// by a rule using the action: the parameter of a macro invocation such as
+// "SSH(ACCEPT)", or the name of the action. For actions that are neither
+// built-in nor macro invocations the name is returned.
+func (a RuleAction) Base() string {
+	if slices.Contains(builtinActions, a.Name) || a.Param == "" {
+		return a.Name
+	}
+	param, err := ParseRuleAction(a.Param)
+	if err != nil || !slices.Contains(builtinActions, param.Name) {
+		return a.Name
+	}
+	return param.Name
+}
+
+// Validate checks the action against the built-in actions, the declared
+// actions and the macros, and checks its modifiers and log level. The
+// parameter of a macro must be a valid action itself, or PARAM in macro
+// bodies.
+func (a RuleAction) Validate(actions []Action, macros []string) error {
+	if a.Directive != "" {
+		return nil
+	}
+	isMacro := slices.Contains(macros, a.Name)
+	if !isMacro && a.Name != macroParam && !slices.Contains(builtinActions, a.Name) &&
+		!slices.ContainsFunc(actions, func(d Action) bool { return d.Name == a.Name }) {
+		return fmt.Errorf("%w: %s", ErrActionNotDeclared, a.Format())
+	}
+	if a.Slash && !isMacro {
+		return fmt.Errorf("%w: %q: only macros take a parameter after /", ErrInvalidRuleAction, a.Format())
+	}
+	if a.Param != "" && slices.Contains(builtinActions, a.Name) && !slices.Contains(parameterizedActions, a.Name) {
+		return fmt.Errorf("%w: %q: %s takes no parameter", ErrInvalidRuleAction, a.Format(), a.Name)
+	}
+
+	switch {
+	case a.Plus && a.Name != "ACCEPT" && a.Name != "A_ACCEPT":
+		return fmt.Errorf("%w: %q: + only applies to ACCEPT", ErrInvalidRuleAction, a.Format())
+	case a.Minus && a.Name != "DNAT" && a.Name != "REDIRECT":
+		return fmt.Errorf("%w: %q: - only applies to DNAT and REDIRECT", ErrInvalidRuleAction, a.Format())
+	case a.Bang && !slices.Contains([]string{"ACCEPT", "DROP", "REJECT", "A_ACCEPT", "A_DROP", "A_REJECT", "QUEUE", "NFQUEUE"}, a.Name):
+		return fmt.Errorf("%w: %q: ! only applies to ACCEPT, DROP, REJECT, QUEUE and NFQUEUE", ErrInvalidRuleAction, a.Format())
+	}
+
+	if isMacro && a.Param != "" && a.Param != macroParam {
+		param, err := ParseRuleAction(a.Param)
+		if err != nil {
+			return err
+		}
+		if err := param.Validate(actions, macros); err != nil {
+			return err
+		}
+	}
+	if a.LogLevel != "" {
+		return validateLogLevel(a.LogLevel)
+	}
+	return nil
+}
+
+// validateLogLevel checks a syslog level, optionally followed by "!", a
+// log target such as "NFLOG(1,0,1)" or a shell variable.
+func validateLogLevel(level string) error {
+	if strings.HasPrefix(level, "$") {
+		return nil
+	}
+	if name, _, ok := strings.Cut(level, "("); ok && strings.HasSuffix(level, ")") && slices.Contains(logTargets, name) {
+		return nil
+	}
+	if slices.Contains(logTargets, level) || slices.Contains(logLevels, strings.TrimSuffix(level, "!")) {
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrInvalidLogLevel, level)
+}
@@ -0,0 +1,91 @@
+package goshorewall
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRuleAction(t *testing.T) {
+	testCases := []struct {
+		action   string
+		expected RuleAction
+	}{
+		{"ACCEPT", RuleAction{Name: "ACCEPT"}},
+		{"ACCEPT+", RuleAction{Name: "ACCEPT", Plus: true}},
+		{"DNAT-", RuleAction{Name: "DNAT", Minus: true}},
+		{"ACCEPT!", RuleAction{Name: "ACCEPT", Bang: true}},
+		{"REDIRECT", RuleAction{Name: "REDIRECT"}},
+		{"Ping(DROP)", RuleAction{Name: "Ping", Param: "DROP"}},
+		{"SSH(ACCEPT):info:ssh", RuleAction{Name: "SSH", Param: "ACCEPT", LogLevel: "info", LogTag: "ssh"}},
+		{"SSH/ACCEPT:info", RuleAction{Name: "SSH", Param: "ACCEPT", Slash: true, LogLevel: "info"}},
+		{"Limit(3,60)", RuleAction{Name: "Limit", Param: "3,60"}},
+		{"Web(DNAT-):$LOG", RuleAction{Name: "Web", Param: "DNAT-", LogLevel: "$LOG"}},
+		{"ACCEPT:NFLOG(1,0,1):web", RuleAction{Name: "ACCEPT", LogLevel: "NFLOG(1,0,1)", LogTag: "web"}},
+		{"LOG:info!", RuleAction{Name: "LOG", LogLevel: "info!"}},
+		{"My-Action", RuleAction{Name: "My-Action"}},
+		{"?COMMENT", RuleAction{Directive: "COMMENT"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.action, func(t *testing.T) {
+			a, err := ParseRuleAction(tc.action)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, a)
+			assert.Equal(t, tc.action, a.Format())
+		})
+	}
+
+	for _, action := range []string{"", "?", "ACCEPT+-", "SSH()", "SSH/", "SSH(ACCEPT", "ACCEPT:", "ACCEPT:info:", "SSH(ACCEPT)x", "1ACCEPT"} {
+		_, err := ParseRuleAction(action)
+		assert.ErrorIs(t, err, ErrInvalidRuleAction, action)
+	}
+}
+
+func TestRuleAction_Base(t *testing.T) {
+	for action, base := range map[string]string{
+		"ACCEPT":               "ACCEPT",
+		"DNAT-":                "DNAT",
+		"SSH(ACCEPT):info:ssh": "ACCEPT",
+		"Ping/DROP":            "DROP",
+		"Limit(3,60)":          "Limit",
+		"MyReject":             "MyReject",
+	} {
+		a, err := ParseRuleAction(action)
+		assert.NoError(t, err)
+		assert.Equal(t, base, a.Base(), action)
+	}
+}
+
+func TestRuleAction_Validate(t *testing.T) {
+	actions := []Action{{Name: "Limit"}}
+	macros := []string{"SSH", "Ping"}
+
+	for _, action := range []string{"ACCEPT", "ACCEPT+", "DNAT-", "DROP!", "SSH(ACCEPT):info:ssh", "Ping/DROP", "SSH(PARAM)", "Limit(3,60):debug", "LOG:NFLOG(1)", "REJECT:$LOG", "?COMMENT",
+		"NFQUEUE!", "QUEUE!", "A_ACCEPT+", "NFQUEUE(2)", "MARK(1)", "REJECT(icmp-host-prohibited)"} {
+		a, err := ParseRuleAction(action)
+		assert.NoError(t, err)
+		assert.NoError(t, a.Validate(actions, macros), action)
+	}
+
+	testCases := []struct {
+		action string
+		err    error
+	}{
+		{"Undeclared", ErrActionNotDeclared},
+		{"SSH(Undeclared)", ErrActionNotDeclared},
+		{"DROP+", ErrInvalidRuleAction},
+		{"ACCEPT-", ErrInvalidRuleAction},
+		{"DNAT!", ErrInvalidRuleAction},
+		{"Limit/3", ErrInvalidRuleAction},
+		{"ACCEPT:loud", ErrInvalidLogLevel},
+		{"DROP(x)", ErrInvalidRuleAction},
+		{"ACCEPT(x)", ErrInvalidRuleAction},
+		{"LOG!", ErrInvalidRuleAction},
+	}
+	for _, tc := range testCases {
+		a, err := ParseRuleAction(tc.action)
+		assert.NoError(t, err)
+		assert.ErrorIs(t, a.Validate(actions, macros), tc.err, tc.action)
+	}
+}
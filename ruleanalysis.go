@@ -0,0 +1,463 @@
+package goshorewall
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+)
+
+// RuleFindingKind is the kind of a problem found by AnalyzeRules.
+type RuleFindingKind int
+
+const (
+	// RuleConflict is a pair of rules matching common traffic with
+	// contradictory actions, one accepting and the other dropping or
+	// rejecting it. The earlier rule wins.
+	RuleConflict RuleFindingKind = iota
+	// RuleDuplicate is a rule equal, once normalized, to an earlier rule
+	// with the same action.
+	RuleDuplicate
+	// RuleSubset is a rule matching a subset of the traffic of an earlier
+	// rule with the same outcome.
+	RuleSubset
+	// RuleNeverMatches is a rule that can never match, as it matches no
+	// zone pair or its traffic is taken by an earlier rule with a different
+	// outcome.
+	RuleNeverMatches
+)
+
+func (k RuleFindingKind) String() string {
+	switch k {
+	case RuleConflict:
+		return "conflict"
+	case RuleDuplicate:
+		return "duplicate"
+	case RuleSubset:
+		return "subset"
+	case RuleNeverMatches:
+		return "never matches"
+	}
+	return fmt.Sprintf("RuleFindingKind(%d)", int(k))
+}
+
+// RuleRef is a rule involved in a finding, with the ID of the App whose
+// block holds it. AppID is empty for rules outside of any App block.
+type RuleRef struct {
+	Rule  Rule
+	AppID string
+}
+
+// RuleFinding is a problem found by AnalyzeRules.
+type RuleFinding struct {
+	Kind RuleFindingKind
+	// Rules are the rules involved, in file order. The last one is the
+	// rule that is duplicated, shadowed or never matches.
+	Rules  []RuleRef
+	Reason string
+}
+
+// AnalyzeRules reads the zones and rules files of the configuration of
+// opts.Family and compares the rules of each section with the earlier rules
+// of the same section. It reports the overlapping rules with contradictory
+// actions, the exact and subset duplicates, and the rules that can never
+// match. Address lists, ports and protocols are compared when they can be
+// parsed; ipsets, DNS names and other symbolic values are only compared as
+// text. The rules invoking a macro are compared through the rules of its
+// body, the rules invoking a user-defined action are not analysed. A rule
+// restricted by a column such as RATE or USER is never reported as taking
+// the traffic of a later rule.
+func AnalyzeRules(opts ReadOptions) ([]RuleFinding, error) {
+	s, err := loadReferenceSet(opts.Family.configPath(), opts.Family.confFile(), opts, nil)
+	if err != nil {
+		return nil, err
+	}
+	return analyzeRules(s, opts.Family, []string{opts.Family.configPath(), shorewallSharePath})
+}
+
+// AnalyzeRules is AnalyzeRules for the configuration managed by the App
+// instance. It analyses the whole files, so that the conflicts between the
+// rules of different Apps are found.
+func (a *App) AnalyzeRules(opts ReadOptions) ([]RuleFinding, error) {
+	opts.Family = a.family
+	s, err := loadReferenceSet(a.basePath, a.family.confFile(), opts, nil)
+	if err != nil {
+		return nil, err
+	}
+	return analyzeRules(s, a.family, []string{a.basePath, shorewallSharePath})
+}
+
+// analyzeRules compares the rules of s. The macros invoked by the rules are
+// read from macroDirs and their body rules are compared in place of the
+// invoking rule.
+func analyzeRules(s referenceSet, family Family, macroDirs []string) ([]RuleFinding, error) {
+	owners, err := ruleOwners(s.rules)
+	if err != nil {
+		return nil, err
+	}
+	zt := newZoneTable(s.zones)
+	ref := func(i int) RuleRef {
+		return RuleRef{Rule: s.rules[i], AppID: owners[i]}
+	}
+
+	matches := make([][]ruleMatch, len(s.rules))
+	for i, r := range s.rules {
+		matches[i] = newRuleMatches(zt, family, macroDirs, r)
+	}
+
+	var findings []RuleFinding
+	for i, r := range s.rules {
+		ms := deciding(matches[i])
+		if slices.ContainsFunc(matches[i], func(m ruleMatch) bool { return m.unknown }) {
+			continue
+		}
+		if len(zt.names) > 0 && len(matches[i]) > 0 && !slices.ContainsFunc(matches[i], func(m ruleMatch) bool { return len(m.pairs) > 0 }) {
+			findings = append(findings, RuleFinding{Kind: RuleNeverMatches, Rules: []RuleRef{ref(i)}, Reason: "matches no zone pair"})
+			continue
+		}
+		if len(ms) == 0 {
+			continue
+		}
+		for j := range i {
+			es := deciding(matches[j])
+			if len(es) == 0 || es[0].section != ms[0].section ||
+				slices.ContainsFunc(matches[j], func(e ruleMatch) bool { return e.unknown }) {
+				continue
+			}
+			covered, same, conflict := compareMatches(ms, es)
+			f := RuleFinding{Rules: []RuleRef{ref(j), ref(i)}}
+			switch {
+			case covered && same && s.rules[j].EqualsNormalized(r):
+				f.Kind, f.Reason = RuleDuplicate, fmt.Sprintf("duplicates %s", s.rules[j].Location)
+			case covered && same:
+				f.Kind, f.Reason = RuleSubset, fmt.Sprintf("matches a subset of %s", s.rules[j].Location)
+			case covered:
+				f.Kind, f.Reason = RuleNeverMatches, fmt.Sprintf("shadowed by %s %s", s.rules[j].Action, s.rules[j].Location)
+			case conflict:
+				f.Kind, f.Reason = RuleConflict, fmt.Sprintf("%s overlaps %s %s", r.Action, s.rules[j].Action, s.rules[j].Location)
+			default:
+				continue
+			}
+			findings = append(findings, f)
+			if f.Kind != RuleConflict {
+				break
+			}
+		}
+	}
+	return findings, nil
+}
+
+// deciding returns the matches of ms with a verdict.
+func deciding(ms []ruleMatch) []ruleMatch {
+	return slices.DeleteFunc(slices.Clone(ms), func(m ruleMatch) bool { return m.verdict == "" })
+}
+
+// compareMatches compares the matches of a rule with the matches of an
+// earlier rule. covered is set when the traffic of every match of ms is
+// taken by a match of es, same when each of them is taken by a match with
+// the same verdict, conflict when a match of ms overlaps a match of es with
+// a different verdict.
+func compareMatches(ms, es []ruleMatch) (covered, same, conflict bool) {
+	covered, same = true, true
+	for _, m := range ms {
+		for _, e := range es {
+			if e.overlaps(m) && e.verdict != m.verdict {
+				conflict = true
+			}
+		}
+		i := slices.IndexFunc(es, m.subsetOf)
+		if i == -1 {
+			covered = false
+		} else if es[i].verdict != m.verdict {
+			same = false
+		}
+	}
+	return covered, same, conflict
+}
+
+// ruleOwners returns the ID of the App owning each rule.
+func ruleOwners(rules []Rule) ([]string, error) {
+	locations := make([]Location, len(rules))
+	for i, r := range rules {
+		locations[i] = r.Location
+	}
+	return locationOwners(locations)
+}
+
+// locationOwners returns the ID of the App owning the entry at each
+// location, reading the files the entries come from.
+func locationOwners(locations []Location) ([]string, error) {
+	files := map[string][]string{}
+	owners := make([]string, len(locations))
+	for i, loc := range locations {
+		if loc.File == "" {
+			continue
+		}
+		lines, ok := files[loc.File]
+		if !ok {
+			buff, err := os.ReadFile(loc.File)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+			lines = appBlockOwners(buff)
+			files[loc.File] = lines
+		}
+		if loc.Line < len(lines) {
+			owners[i] = lines[loc.Line]
+		}
+	}
+	return owners, nil
+}
+
+// ruleMatch is the traffic matched by a rule, in the form compared by
+// AnalyzeRules.
+type ruleMatch struct {
+	section string
+	// verdict is ACCEPT, DROP or REJECT for the rules deciding the fate of
+	// the traffic they match, empty for the other rules.
+	verdict      string
+	pairs        []ZonePair
+	source, dest addressMatch
+	// protocol is nil for any protocol. Negated protocols cannot be
+	// compared, protocolParsed is then false.
+	protocol       []string
+	protocolParsed bool
+	dport, sport   portMatch
+	origdest       string
+	// limited is set for rules restricted by a column that is not compared,
+	// such as RATE or USER. Such rules never take the whole traffic of
+	// another rule.
+	limited bool
+	// unknown is set when the zone pairs of the rule cannot be known, see
+	// zoneSet, or when the rule invokes an action or a macro that cannot be
+	// expanded. Such rules are not analysed.
+	unknown bool
+}
+
+// newRuleMatches returns the traffic matched by r. The rules invoking a macro
+// are expanded from macroDirs and a match is returned for each rule of the
+// body.
+func newRuleMatches(zt zoneTable, family Family, macroDirs []string, r Rule) []ruleMatch {
+	a, err := ParseRuleAction(r.Action)
+	if err != nil || slices.Contains(builtinActions, a.Name) {
+		return []ruleMatch{newRuleMatch(zt, family, r)}
+	}
+	body, err := expandMacroRule(macroDirs, r, 0)
+	if err != nil {
+		// A user-defined action, or a macro that cannot be read
+		return []ruleMatch{{section: r.Section, unknown: true}}
+	}
+	var matches []ruleMatch
+	for _, b := range body {
+		b.Section = r.Section
+		if a, err := ParseRuleAction(b.Action); err == nil && !slices.Contains(builtinActions, a.Name) {
+			// A user-defined action invoked by the macro
+			return []ruleMatch{{section: r.Section, unknown: true}}
+		}
+		matches = append(matches, newRuleMatch(zt, family, b))
+	}
+	return matches
+}
+
+func newRuleMatch(zt zoneTable, family Family, r Rule) ruleMatch {
+	n := r.Normalize()
+	m := ruleMatch{
+		section:  r.Section,
+		origdest: n.Origdest,
+		source:   newAddressMatch(family, r.Source),
+		dest:     newAddressMatch(family, r.Destination),
+		dport:    newPortMatch(n.Dport),
+		sport:    newPortMatch(n.Sport),
+		limited:  r.limited,
+	}
+	if isNewSection(m.section) {
+		m.section = SectionNew
+	}
+	switch v := strings.TrimPrefix(ruleVerdict(r), "A_"); v {
+	case "ACCEPT", "DROP", "REJECT":
+		m.verdict = v
+	}
+	if actionBaseName(r.Action) == "REDIRECT" {
+		m.dest = addressMatch{}
+	}
+	if n.Protocol != "" && !strings.HasPrefix(n.Protocol, "!") {
+		m.protocol = strings.Split(n.Protocol, ",")
+	}
+	m.protocolParsed = !strings.HasPrefix(n.Protocol, "!")
+
+	matcher := zt.ruleMatcher(r)
+	m.unknown = matcher.unknown()
+	for _, pair := range zt.pairs() {
+		if matcher.matches(pair) {
+			m.pairs = append(m.pairs, pair)
+		}
+	}
+	return m
+}
+
+// overlaps reports whether m and other may match common traffic. Values
+// that cannot be compared are assumed to overlap.
+func (m ruleMatch) overlaps(other ruleMatch) bool {
+	if !slices.ContainsFunc(m.pairs, func(p ZonePair) bool { return slices.Contains(other.pairs, p) }) {
+		return false
+	}
+	if m.protocolParsed && other.protocolParsed && len(m.protocol) > 0 && len(other.protocol) > 0 &&
+		!slices.ContainsFunc(m.protocol, func(p string) bool { return slices.Contains(other.protocol, p) }) {
+		return false
+	}
+	return m.source.overlaps(other.source) && m.dest.overlaps(other.dest) &&
+		m.dport.overlaps(other.dport) && m.sport.overlaps(other.sport)
+}
+
+// subsetOf reports whether the traffic matched by m is provably matched by
+// other.
+func (m ruleMatch) subsetOf(other ruleMatch) bool {
+	if other.limited {
+		return false
+	}
+	for _, p := range m.pairs {
+		if !slices.Contains(other.pairs, p) {
+			return false
+		}
+	}
+	switch {
+	case !other.protocolParsed || !m.protocolParsed:
+		return false
+	case len(other.protocol) > 0 && (len(m.protocol) == 0 || slices.ContainsFunc(m.protocol, func(p string) bool { return !slices.Contains(other.protocol, p) })):
+		return false
+	case other.origdest != "" && m.origdest != other.origdest:
+		return false
+	}
+	return m.source.subsetOf(other.source) && m.dest.subsetOf(other.dest) &&
+		m.dport.subsetOf(other.dport) && m.sport.subsetOf(other.sport)
+}
+
+// addressMatch is the interface and the addresses of a SOURCE or DEST
+// column. The addresses are kept as ranges when every item is an address,
+// a network or a range, and as text otherwise.
+type addressMatch struct {
+	iface  string
+	ranges [][2]netip.Addr
+	// text holds the addresses that cannot be compared as ranges.
+	text string
+}
+
+func newAddressMatch(family Family, spec string) addressMatch {
+	a, err := ParseAddress(family, spec)
+	if err != nil {
+		_, text, _ := strings.Cut(spec, ":")
+		return addressMatch{text: text}
+	}
+	m := addressMatch{iface: a.Interface}
+	if len(a.Addresses.Exclude) > 0 {
+		m.text = a.Addresses.String()
+		return m
+	}
+	for _, item := range a.Addresses.Include {
+		switch item.Kind {
+		case AddressHost, AddressNetwork:
+			p := item.Prefix.Masked()
+			m.ranges = append(m.ranges, [2]netip.Addr{p.Addr(), lastAddr(p)})
+		case AddressRange:
+			m.ranges = append(m.ranges, [2]netip.Addr{item.From, item.To})
+		default:
+			return addressMatch{iface: a.Interface, text: a.Addresses.String()}
+		}
+	}
+	return m
+}
+
+// lastAddr returns the last address of the network p.
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 1 << (7 - i%8)
+	}
+	a, _ := netip.AddrFromSlice(b)
+	return a
+}
+
+func (m addressMatch) isAny() bool {
+	return m.text == "" && len(m.ranges) == 0
+}
+
+func (m addressMatch) overlaps(other addressMatch) bool {
+	if m.iface != "" && other.iface != "" && m.iface != other.iface {
+		return false
+	}
+	if m.isAny() || other.isAny() || m.text != "" || other.text != "" {
+		return true
+	}
+	return slices.ContainsFunc(m.ranges, func(r [2]netip.Addr) bool {
+		return slices.ContainsFunc(other.ranges, func(o [2]netip.Addr) bool {
+			return r[0].Compare(o[1]) <= 0 && o[0].Compare(r[1]) <= 0
+		})
+	})
+}
+
+func (m addressMatch) subsetOf(other addressMatch) bool {
+	if other.iface != "" && m.iface != other.iface {
+		return false
+	}
+	switch {
+	case other.isAny():
+		return true
+	case m.text != "" || other.text != "":
+		return m.text == other.text
+	case m.isAny():
+		return false
+	}
+	return !slices.ContainsFunc(m.ranges, func(r [2]netip.Addr) bool {
+		return !slices.ContainsFunc(other.ranges, func(o [2]netip.Addr) bool {
+			return o[0].Compare(r[0]) <= 0 && r[1].Compare(o[1]) <= 0
+		})
+	})
+}
+
+// portMatch is a DPORT or SPORT column. Lists with names or negated are kept
+// as text.
+type portMatch struct {
+	ranges []PortRange
+	text   string
+}
+
+func newPortMatch(ports string) portMatch {
+	p, err := ParsePorts(ports)
+	if err != nil || p.Negated || slices.ContainsFunc(p.Ranges, func(r PortRange) bool { return r.Name != "" }) {
+		return portMatch{text: ports}
+	}
+	return portMatch{ranges: p.Ranges}
+}
+
+func (m portMatch) isAny() bool {
+	return m.text == "" && len(m.ranges) == 0
+}
+
+func (m portMatch) overlaps(other portMatch) bool {
+	if m.isAny() || other.isAny() || m.text != "" || other.text != "" {
+		return true
+	}
+	return slices.ContainsFunc(m.ranges, func(r PortRange) bool {
+		return slices.ContainsFunc(other.ranges, func(o PortRange) bool {
+			return r.First <= o.Last && o.First <= r.Last
+		})
+	})
+}
+
+func (m portMatch) subsetOf(other portMatch) bool {
+	switch {
+	case other.isAny():
+		return true
+	case m.text != "" || other.text != "":
+		return m.text == other.text
+	case m.isAny():
+		return false
+	}
+	return !slices.ContainsFunc(m.ranges, func(r PortRange) bool {
+		return !slices.ContainsFunc(other.ranges, func(o PortRange) bool {
+			return o.First <= r.First && r.Last <= o.Last
+		})
+	})
+}
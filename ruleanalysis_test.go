@@ -0,0 +1,138 @@
+package goshorewall
+
+import (
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyzeRules(t *testing.T) {
+	const app1 = "4f3c1a52-8d1e-4c7b-9a36-2f5b0e7d9c01"
+	const app2 = "c2b7e8a4-1f0d-4e39-b6a5-7d8c9e0f1a22"
+
+	dir := t.TempDir()
+	writeFile(t, path.Join(dir, zonesFile), "fw\tfirewall\nnet\tipv4\nloc\tipv4\n")
+	writeFile(t, path.Join(dir, rulesFile), "ACCEPT\tnet\t$FW\ttcp\t22\n"+
+		string(wrapBuffWithAppIdentifier([]byte("ACCEPT\tnet:10.0.0.0/8\tloc\ttcp\t80,443\n"+
+			"DROP\tnet:1.2.3.4\t$FW\n"+
+			"ACCEPT\tghost\tloc\n"), app1))+
+		string(wrapBuffWithAppIdentifier([]byte("DROP\tnet:10.1.0.0/16\tloc\ttcp\t443\n"+
+			"REJECT\tnet:10.2.0.0/16\tloc\n"+
+			"ACCEPT\tnet:10.0.0.0/8\tloc\t6\t443,80\n"+
+			"ACCEPT:info\tnet:10.3.0.1\tloc\ttcp\t80\n"+
+			"DROP\tnet:192.168.0.0/16\tloc\ttcp\t80\n"), app2)))
+	app, err := NewAppWithBasePath(dir)
+	assert.NoError(t, err)
+
+	findings, err := app.AnalyzeRules(ReadOptions{})
+	assert.NoError(t, err)
+
+	type finding struct {
+		kind  RuleFindingKind
+		lines []int
+		apps  []string
+	}
+	var got []finding
+	for _, f := range findings {
+		var lines []int
+		var apps []string
+		for _, r := range f.Rules {
+			lines = append(lines, r.Rule.Location.Line)
+			apps = append(apps, r.AppID)
+		}
+		got = append(got, finding{f.Kind, lines, apps})
+	}
+	assert.Equal(t, []finding{
+		{RuleConflict, []int{1, 4}, []string{"", app1}},
+		{RuleNeverMatches, []int{5}, []string{app1}},
+		{RuleNeverMatches, []int{3, 8}, []string{app1, app2}},
+		{RuleConflict, []int{3, 9}, []string{app1, app2}},
+		{RuleDuplicate, []int{3, 10}, []string{app1, app2}},
+		{RuleSubset, []int{3, 11}, []string{app1, app2}},
+	}, got)
+	assert.Equal(t, RuleNeverMatches, findings[2].Kind)
+	assert.Equal(t, "never matches", findings[2].Kind.String())
+}
+
+func TestAppBlockOwners(t *testing.T) {
+	const id = "9b2f0c0e-7b1e-4a57-9f0c-1f6f1d5c9a11"
+	buff := append([]byte("ACCEPT\tnet\tfw\n"), wrapBuffWithAppIdentifier([]byte("DROP\tnet\tloc\n"), id)...)
+	buff = append(buff, wrapBuffWithAppIdentifier([]byte("?SECTION NEW\n"), sectionBlockID(id, SectionNew))...)
+	assert.Equal(t, []string{"", "", "", id, "", "", id, "", ""}, appBlockOwners(buff))
+}
+
+func TestAnalyzeRules_ShellVariables(t *testing.T) {
+	s := referenceSet{
+		zones: parseZones([]byte("fw\tfirewall\nnet\tipv4\nloc\tipv4\n")),
+		rules: parseRules([]byte("ACCEPT\t$NET\t$FW\ttcp\t22\nDROP\tnet\t$FW\ttcp\t22\nACCEPT\t${FW}\t$LAN:10.0.0.1\n")),
+	}
+	findings, err := analyzeRules(s, IPv4, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, findings)
+}
+
+// numberedRules parses data as the rules file, numbering the rules from 1.
+func numberedRules(data string) []Rule {
+	rules := parseRules([]byte(data))
+	for i := range rules {
+		rules[i].Location.Line = i + 1
+	}
+	return rules
+}
+
+// ruleFindingLines returns the kind and the lines of the rules of each
+// finding.
+func ruleFindingLines(findings []RuleFinding) [][]int {
+	var got [][]int
+	for _, f := range findings {
+		lines := []int{int(f.Kind)}
+		for _, r := range f.Rules {
+			lines = append(lines, r.Rule.Location.Line)
+		}
+		got = append(got, lines)
+	}
+	return got
+}
+
+func TestAnalyzeRules_Macros(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, path.Join(dir, "macro.Web"), macroWeb)
+	s := referenceSet{
+		zones: parseZones([]byte("fw\tfirewall\nnet\tipv4\nloc\tipv4\n")),
+		rules: numberedRules("Web(ACCEPT)\tnet\t$FW\n" +
+			"DROP\tnet\t$FW\ttcp\t22\n" +
+			"DROP\tnet\t$FW\ttcp\t443\n" +
+			"ACCEPT\tnet:10.0.0.1\t$FW\ttcp\t80\n" +
+			"Custom\tnet\t$FW\n" +
+			"ACCEPT\tnet\t$FW\ttcp\t22\n"),
+	}
+	findings, err := analyzeRules(s, IPv4, []string{dir})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]int{
+		{int(RuleNeverMatches), 1, 3},
+		{int(RuleSubset), 1, 4},
+		{int(RuleNeverMatches), 2, 6},
+	}, ruleFindingLines(findings))
+}
+
+func TestAnalyzeRules_Limited(t *testing.T) {
+	s := referenceSet{
+		zones: parseZones([]byte("fw\tfirewall\nnet\tipv4\nloc\tipv4\n")),
+		rules: numberedRules("ACCEPT\tnet\t$FW\ttcp\t22\t-\t-\t3/min\n" +
+			"ACCEPT\tnet\t$FW\ttcp\t80\t-\t-\t-\tjoe\n" +
+			"DROP\tnet\t$FW\ttcp\t22\n" +
+			"DROP\tnet\t$FW\ttcp\t80\n" +
+			"ACCEPT\tnet\t$FW\ttcp\t22\n" +
+			"ACCEPT\t{ source=net, dest=$FW, proto=tcp, dport=443, rate=1/sec }\n" +
+			"DROP\tnet\t$FW\ttcp\t443\n"),
+	}
+	findings, err := analyzeRules(s, IPv4, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]int{
+		{int(RuleConflict), 1, 3},
+		{int(RuleConflict), 2, 4},
+		{int(RuleNeverMatches), 3, 5},
+		{int(RuleConflict), 6, 7},
+	}, ruleFindingLines(findings))
+}
@@ -1,10 +1,8 @@
 package goshorewall
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
-	"os"
 	"slices"
 	"strings"
 )
@@ -22,6 +20,19 @@ type Rule struct {
 	Dport       string
 	Sport       string
 	Origdest    string
+	// Section is the ?SECTION of the rules file the rule belongs to. Rules
+	// preceding any ?SECTION directive have an empty Section, Shorewall
+	// treats them as part of the NEW section.
+	Section string
+	// Comment is the ?COMMENT annotation of the rule. It is not considered
+	// when comparing rules.
+	Comment string
+	// Location is the file and line the rule was read from.
+	Location Location
+	// limited is set for rules read with a column following ORIGDEST, such
+	// as RATE or USER. These columns are not kept, but they restrict the
+	// traffic matched by the rule.
+	limited bool
 }
 
 func (r Rule) Compare(other Rule) int {
@@ -45,7 +56,10 @@ func (r Rule) Compare(other Rule) int {
 	if cmp := strings.Compare(r.Sport, other.Sport); cmp != 0 {
 		return cmp
 	}
-	return strings.Compare(r.Origdest, other.Origdest)
+	if cmp := strings.Compare(r.Origdest, other.Origdest); cmp != 0 {
+		return cmp
+	}
+	return strings.Compare(effectiveSection(r.Section), effectiveSection(other.Section))
 }
 
 func (r Rule) Equals(other Rule) bool {
@@ -53,15 +67,35 @@ func (r Rule) Equals(other Rule) bool {
 }
 
 func (r Rule) Format() string {
-	return fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\t%s", r.Action, r.Source, r.Destination, r.Protocol, r.Dport, r.Sport, r.Origdest)
+	return formatWithComment(r.Comment, r.formatColumns())
+}
+
+// FormatNamed formats the rule in the column=value form, e.g.
+// "ACCEPT { source=net, dest=fw, proto=tcp, dport=22 }".
+func (r Rule) FormatNamed() string {
+	return formatWithComment(r.Comment, formatNamedColumns(ruleColumns, r.Action, r.Source, r.Destination, r.Protocol, r.Dport, r.Sport, r.Origdest))
+}
+
+func (r Rule) formatColumns() string {
+	return formatColumns(ruleColumns, r.Action, r.Source, r.Destination, r.Protocol, r.Dport, r.Sport, r.Origdest)
 }
 
 func Rules() ([]Rule, error) {
-	buff, err := os.ReadFile(fullRulesFile)
+	entries, _, err := RulesWithOptions(ReadOptions{})
+	return entries, err
+}
+
+// RulesWithOptions reads the rules file, following includes and evaluating
+// conditional directives as configured by opts. In lenient mode the lines
+// that could not be parsed are returned as warnings, in strict mode they
+// are returned as a ParseErrors error.
+func RulesWithOptions(opts ReadOptions) ([]Rule, []ParseError, error) {
+	lines, err := loadConfigFile(rulesFile, opts)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return getRulesBuff(buff)
+	issues := &parseIssues{}
+	return result(parseRuleLines(lines, 3, directiveState{}, issues), issues, opts.Strict)
 }
 
 func AddRule(rule Rule) error {
@@ -72,11 +106,23 @@ func RemoveRule(rule Rule) error {
 	return readWriteFile(fullRulesFile, removeRuleBuff, rule)
 }
 
+func UpdateRule(from, to Rule) error {
+	return readWriteFile(fullRulesFile, updateRuleBuff, entryUpdate[Rule]{old: from, new: to})
+}
+
 func getRulesBuff(buff []byte) ([]Rule, error) {
 	return parseRules(buff), nil
 }
 
+// addRuleBuff adds rule at the end of its section, creating the section if
+// needed. Rules without a section are part of the NEW section; they are
+// appended at the end of buff if it has no section. A rule equal to an
+// existing one once normalized is rejected.
 func addRuleBuff(buff []byte, rule Rule) ([]byte, error) {
+	if err := validateSection(rule.Section); err != nil {
+		return nil, err
+	}
+
 	rules, err := getRulesBuff(buff)
 	if err != nil {
 		return nil, err
@@ -84,38 +130,69 @@ func addRuleBuff(buff []byte, rule Rule) ([]byte, error) {
 
 	rule = rule.fillEmpty()
 
-	if slices.ContainsFunc(rules, func(r Rule) bool {
-		return r.Equals(rule)
-	}) {
+	if slices.ContainsFunc(rules, rule.EqualsNormalized) {
 		return nil, ErrRuleAlreadyExists
 	}
 
-	return fmt.Appendf(buff, "%s\n", rule.Format()), nil
+	if rule.Section == "" && len(sectionDirectives(buff)) == 0 {
+		return fmt.Appendf(buff, "%s\n", rule.Format()), nil
+	}
+
+	buff, offset, header := sectionInsertPoint(buff, effectiveSection(rule.Section))
+	return insertAt(buff, offset, fmt.Appendf(header, "%s\n", rule.Format())), nil
 }
 
+// removeRuleBuff removes the first line matching rule. Section directives and
+// the other lines of buff are left untouched.
 func removeRuleBuff(buff []byte, rule Rule) ([]byte, error) {
+	t := parseCST(buff)
+	i, _ := t.find(matchRule(rule))
+	if i == -1 {
+		return nil, ErrRuleNotFound
+	}
+	t.remove(i)
+	return t.Bytes(), nil
+}
+
+// updateRuleBuff replaces the rule matching u.old with u.new, leaving the
+// other lines of buff untouched. A rule moved to another section is removed
+// and added at the end of its new section.
+func updateRuleBuff(buff []byte, u entryUpdate[Rule]) ([]byte, error) {
+	if err := validateSection(u.new.Section); err != nil {
+		return nil, err
+	}
+	if effectiveSection(u.new.Section) != effectiveSection(u.old.Section) {
+		b, err := removeRuleBuff(buff, u.old)
+		if err != nil {
+			return nil, err
+		}
+		return addRuleBuff(b, u.new)
+	}
+
 	rules, err := getRulesBuff(buff)
 	if err != nil {
 		return nil, err
 	}
+	if !u.new.EqualsNormalized(u.old) && slices.ContainsFunc(rules, u.new.EqualsNormalized) {
+		return nil, ErrRuleAlreadyExists
+	}
 
-	rule = rule.fillEmpty()
-
-	index := slices.IndexFunc(rules, func(r Rule) bool {
-		return r.Equals(rule)
-	})
-	if index == -1 {
+	t := parseCST(buff)
+	i, state := t.find(matchRule(u.old))
+	if i == -1 {
 		return nil, ErrRuleNotFound
 	}
+	rule := u.new.fillEmpty()
+	rule.Comment = t.entryComment(i, state, rule.Comment)
+	t.replace(i, rule.Format())
+	return t.Bytes(), nil
+}
 
-	rules = slices.Delete(rules, index, index+1)
-
-	var b bytes.Buffer
-	for _, r := range rules {
-		b.WriteString(fmt.Sprintf("%s\n", r.Format()))
+// matchRule returns a cst.find function matching the lines equal to rule.
+func matchRule(rule Rule) func(logicalLine, directiveState) bool {
+	return func(l logicalLine, state directiveState) bool {
+		return slices.ContainsFunc(parseRuleLines([]logicalLine{l}, 3, state, nil), rule.Equals)
 	}
-
-	return b.Bytes(), nil
 }
 
 // fillEmpty fills empty fields with "-" where necessary
@@ -131,36 +208,70 @@ func (r Rule) fillEmpty() Rule {
 	return r
 }
 
-func parseRules(data []byte) (rules []Rule) {
-	iter := bytes.Lines(data)
-	for z := range iter {
-		z = bytes.TrimSpace(z)
-		if len(z) == 0 || z[0] == '#' {
-			continue
+func parseRules(data []byte) []Rule {
+	return parseRuleColumns(data, 3, 0)
+}
+
+// parseRuleColumns parses lines in the rule column layout, skipping lines with
+// less than minColumns columns. format is the format in effect before the
+// first line.
+func parseRuleColumns(data []byte, minColumns, format int) []Rule {
+	return parseRuleLines(tokenize(data), minColumns, directiveState{format: format}, nil)
+}
+
+// parseRuleLines parses logical lines in the rule column layout. state holds
+// the section and format in effect before the first line, the directives of
+// lines update it. In format 1, used by macro and action bodies, there is no
+// ORIGDEST column.
+func parseRuleLines(lines []logicalLine, minColumns int, state directiveState, issues *parseIssues) (rules []Rule) {
+	for _, l := range lines {
+		names := ruleColumns
+		if state.format == 1 {
+			names = macroFormat1Columns
 		}
-		z = bytes.ReplaceAll(z, []byte("\t"), []byte(" "))
-		parts := bytes.Fields(z)
-		if len(parts) < 3 {
+		parts, ok := entryColumns(l, &state, names, minColumns, issues)
+		if !ok {
 			continue
 		}
-		rule := Rule{
-			Action:      string(parts[0]),
-			Source:      string(parts[1]),
-			Destination: string(parts[2]),
-		}
-		if len(parts) > 3 {
-			rule.Protocol = string(parts[3])
-		}
-		if len(parts) > 4 {
-			rule.Dport = string(parts[4])
-		}
-		if len(parts) > 5 {
-			rule.Sport = string(parts[5])
-		}
-		if len(parts) > 6 {
-			rule.Origdest = string(parts[6])
+		// The columns after ORIGDEST, or after SPORT in format 1, are not
+		// kept, but a value in one of them restricts the rule.
+		kept := 7
+		if state.format == 1 {
+			kept = 6
 		}
+		limited := len(parts) > kept && slices.ContainsFunc(parts[kept:], func(p string) bool { return p != "" && p != "-" })
+		parts = parts[:min(len(parts), kept)]
+		rule := ruleFromFields(parts)
+		rule.limited = limited
+		rule.Section = state.section
+		rule.Comment = state.comment
+		rule.Location = l.location()
 		rules = append(rules, rule)
 	}
 	return
 }
+
+func ruleFromFields(parts []string) Rule {
+	rule := Rule{
+		Action: parts[0],
+	}
+	if len(parts) > 1 {
+		rule.Source = parts[1]
+	}
+	if len(parts) > 2 {
+		rule.Destination = parts[2]
+	}
+	if len(parts) > 3 {
+		rule.Protocol = parts[3]
+	}
+	if len(parts) > 4 {
+		rule.Dport = parts[4]
+	}
+	if len(parts) > 5 {
+		rule.Sport = parts[5]
+	}
+	if len(parts) > 6 {
+		rule.Origdest = parts[6]
+	}
+	return rule
+}
@@ -0,0 +1,295 @@
+package goshorewall
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Sections of the rules file, in the order in which they must appear.
+const (
+	SectionAll         = "ALL"
+	SectionEstablished = "ESTABLISHED"
+	SectionRelated     = "RELATED"
+	SectionInvalid     = "INVALID"
+	SectionUntracked   = "UNTRACKED"
+	SectionNew         = "NEW"
+)
+
+var ErrInvalidSection = errors.New("invalid rules section")
+
+var sectionOrder = []string{
+	SectionAll,
+	SectionEstablished,
+	SectionRelated,
+	SectionInvalid,
+	SectionUntracked,
+	SectionNew,
+}
+
+func validateSection(section string) error {
+	if section != "" && !slices.Contains(sectionOrder, section) {
+		return fmt.Errorf("%w: %q", ErrInvalidSection, section)
+	}
+	return nil
+}
+
+// effectiveSection returns the section of a rule with the given Section:
+// rules without a section are part of the NEW section.
+func effectiveSection(section string) string {
+	if section == "" {
+		return SectionNew
+	}
+	return section
+}
+
+// sectionBlockID returns the identifier used in the markers of the block of
+// the application id holding the rules of section. Rules without a section
+// live in the block identified by the bare application identifier.
+func sectionBlockID(id, section string) string {
+	if section == "" {
+		return id
+	}
+	return id + " section: " + section
+}
+
+type sectionDirective struct {
+	name  string
+	start int
+}
+
+// sectionDirectives returns the ?SECTION directives of buff in file order.
+func sectionDirectives(buff []byte) []sectionDirective {
+	var dirs []sectionDirective
+	for _, l := range tokenize(buff) {
+		if l.directiveName() == "SECTION" {
+			dirs = append(dirs, sectionDirective{
+				name:  strings.ToUpper(l.directiveArgs()),
+				start: l.start,
+			})
+		}
+	}
+	return dirs
+}
+
+// sectionAt returns the section in effect at offset in buff, or "" if no
+// ?SECTION directive precedes offset.
+func sectionAt(buff []byte, offset int) string {
+	section := ""
+	for _, d := range sectionDirectives(buff) {
+		if d.start >= offset {
+			break
+		}
+		section = d.name
+	}
+	return section
+}
+
+// insertAt returns a copy of buff with data inserted at offset.
+func insertAt(buff []byte, offset int, data []byte) []byte {
+	if offset > 0 && buff[offset-1] != '\n' {
+		data = append([]byte{'\n'}, data...)
+	}
+	b := make([]byte, 0, len(buff)+len(data))
+	b = append(b, buff[:offset]...)
+	b = append(b, data...)
+	return append(b, buff[offset:]...)
+}
+
+// sectionInsertPoint returns the buffer, the offset at which entries of
+// section must be appended and the ?SECTION directive to insert there if the
+// section does not exist yet.
+//
+// Shorewall treats rules preceding the first ?SECTION directive as part of
+// the NEW section, which must be the last one. If buff has no section yet,
+// a "?SECTION NEW" directive is first inserted before its entries, so that
+// earlier sections can be added before it.
+func sectionInsertPoint(buff []byte, section string) ([]byte, int, []byte) {
+	dirs := sectionDirectives(buff)
+	if len(dirs) == 0 {
+		if first := firstEntryOffset(buff); first != -1 {
+			buff = insertAt(buff, first, []byte("?SECTION "+SectionNew+"\n"))
+			dirs = sectionDirectives(buff)
+		}
+	}
+
+	order := slices.Index(sectionOrder, section)
+	for i, d := range dirs {
+		if d.name == section {
+			if i+1 < len(dirs) {
+				return buff, dirs[i+1].start, nil
+			}
+			return buff, len(buff), nil
+		}
+		if slices.Index(sectionOrder, d.name) > order {
+			return buff, d.start, []byte("?SECTION " + section + "\n")
+		}
+	}
+	return buff, len(buff), []byte("?SECTION " + section + "\n")
+}
+
+// firstEntryOffset returns the offset of the first entry of buff, or of the
+// first application block if it comes earlier, or -1 if buff has neither.
+func firstEntryOffset(buff []byte) int {
+	first := -1
+	for _, l := range tokenize(buff) {
+		if !l.directive {
+			first = l.start
+			break
+		}
+	}
+	marker := []byte("### Managed by goshorewall app ID: ")
+	if i := bytes.Index(buff, marker); i != -1 && (first == -1 || i < first) {
+		first = bytes.LastIndexByte(buff[:i], '\n') + 1
+	}
+	return first
+}
+
+// ensureSectionBlock makes sure that the application id has a block for
+// section, creating it at the end of the section if needed.
+func ensureSectionBlock(buff []byte, id, section string) ([]byte, error) {
+	_, _, found, err := extractApplicationSubsetBufferIndexes(sectionBlockID(id, section), buff)
+	if err != nil || found {
+		return buff, err
+	}
+	buff, offset, header := sectionInsertPoint(buff, section)
+	block := append(header, wrapBuffWithAppIdentifier(nil, sectionBlockID(id, section))...)
+	return insertAt(buff, offset, block), nil
+}
+
+// getAppRulesBuff returns the rules of the application id in file order,
+// from both its block without section and its per section blocks.
+func getAppRulesBuff(id string, buff []byte) ([]Rule, error) {
+	return parseAppRules(id, buff, nil)
+}
+
+// parseAppRules is getAppRulesBuff recording the problems found in issues.
+func parseAppRules(id string, buff []byte, issues *parseIssues) ([]Rule, error) {
+	type block struct {
+		start int
+		rules []Rule
+	}
+	var blocks []block
+
+	for _, section := range append([]string{""}, sectionOrder...) {
+		is, ie, found, err := extractApplicationSubsetBufferIndexes(sectionBlockID(id, section), buff)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		s := section
+		if s == "" {
+			s = sectionAt(buff, int(is))
+		}
+		blocks = append(blocks, block{
+			start: int(is),
+			rules: parseRuleLines(blockLines(buff, is, ie), 3, directiveState{section: s}, issues),
+		})
+	}
+
+	slices.SortFunc(blocks, func(a, b block) int {
+		return a.start - b.start
+	})
+
+	var rules []Rule
+	for _, b := range blocks {
+		rules = append(rules, b.rules...)
+	}
+	return rules, nil
+}
+
+// addAppRuleBuff adds rule to the block of the application id matching the
+// section of the rule. Rules without a section go to the block without
+// section while it lies in the NEW section, or the file has no section yet,
+// and to the block of the NEW section otherwise.
+func addAppRuleBuff(id string, buff []byte, rule Rule) ([]byte, error) {
+	if err := validateSection(rule.Section); err != nil {
+		return nil, err
+	}
+	if rule.Section == "" {
+		is, _, found, err := extractApplicationSubsetBufferIndexes(id, buff)
+		if err != nil {
+			return nil, err
+		}
+		if found && effectiveSection(sectionAt(buff, int(is))) == SectionNew || !found && len(sectionDirectives(buff)) == 0 {
+			return appUpdateBuff(id, buff, addRuleBuff, rule)
+		}
+		rule.Section = SectionNew
+	}
+
+	buff, err := ensureSectionBlock(buff, id, rule.Section)
+	if err != nil {
+		return nil, err
+	}
+	blockID := sectionBlockID(id, rule.Section)
+	rule.Section = ""
+	return appUpdateBuff(blockID, buff, addRuleBuff, rule)
+}
+
+// removeAppRuleBuff removes rule from the blocks of the application id.
+func removeAppRuleBuff(id string, buff []byte, rule Rule) ([]byte, error) {
+	if err := validateSection(rule.Section); err != nil {
+		return nil, err
+	}
+	section := rule.Section
+	rule.Section = ""
+	return editAppRuleBuff(id, buff, section, removeRuleBuff, rule)
+}
+
+// updateAppRuleBuff replaces the rule matching u.old in the blocks of the
+// application id with u.new. A rule moved to another section is removed and
+// added to the block of its new section.
+func updateAppRuleBuff(id string, buff []byte, u entryUpdate[Rule]) ([]byte, error) {
+	if err := validateSection(u.old.Section); err != nil {
+		return nil, err
+	}
+	if effectiveSection(u.new.Section) != effectiveSection(u.old.Section) {
+		b, err := removeAppRuleBuff(id, buff, u.old)
+		if err != nil {
+			return nil, err
+		}
+		return addAppRuleBuff(id, b, u.new)
+	}
+	section := u.old.Section
+	u.old.Section, u.new.Section = "", ""
+	return editAppRuleBuff(id, buff, section, updateRuleBuff, u)
+}
+
+// editAppRuleBuff applies fn to the block of the application id holding the
+// rules of section. The block of the section is searched first and then the
+// block without section, if that lies in the same section. An empty section
+// is the NEW section, whose rules are first searched in the block without
+// section.
+func editAppRuleBuff[S any](id string, buff []byte, section string, fn func([]byte, S) ([]byte, error), i S) ([]byte, error) {
+	if section == "" {
+		b, err := appUpdateBuff(id, buff, fn, i)
+		if !errors.Is(err, ErrRuleNotFound) {
+			return b, err
+		}
+		section = SectionNew
+	}
+
+	_, _, found, err := extractApplicationSubsetBufferIndexes(sectionBlockID(id, section), buff)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		b, err := appUpdateBuff(sectionBlockID(id, section), buff, fn, i)
+		if !errors.Is(err, ErrRuleNotFound) {
+			return b, err
+		}
+	}
+
+	is, _, found, err := extractApplicationSubsetBufferIndexes(id, buff)
+	if err != nil {
+		return nil, err
+	}
+	if !found || effectiveSection(sectionAt(buff, int(is))) != section {
+		return nil, ErrRuleNotFound
+	}
+	return appUpdateBuff(id, buff, fn, i)
+}
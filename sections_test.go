@@ -0,0 +1,157 @@
+package goshorewall
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+const rulesSections01 = `#ACTION	SOURCE	DEST	PROTO	DPORT
+?SECTION ALL
+ACCEPT	all	all	icmp	8
+?SECTION ESTABLISHED
+ACCEPT	all	all
+?SECTION NEW
+ACCEPT	net	fw	tcp	22
+`
+
+func TestParseRules_Sections(t *testing.T) {
+	rules := parseRules([]byte(rulesSections01))
+	assert.Equal(t, 3, len(rules), "expected 3 rules")
+	assert.Equal(t, SectionAll, rules[0].Section)
+	assert.Equal(t, SectionEstablished, rules[1].Section)
+	assert.Equal(t, SectionNew, rules[2].Section)
+
+	rules = parseRules([]byte(rules01))
+	assert.Equal(t, "", rules[0].Section)
+}
+
+func TestAddRuleBuff_Section(t *testing.T) {
+	buff, err := addRuleBuff([]byte(rulesSections01), Rule{Action: "DROP", Source: "net", Destination: "all", Section: SectionInvalid})
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, `#ACTION	SOURCE	DEST	PROTO	DPORT
+?SECTION ALL
+ACCEPT	all	all	icmp	8
+?SECTION ESTABLISHED
+ACCEPT	all	all
+?SECTION INVALID
+DROP	net	all				
+?SECTION NEW
+ACCEPT	net	fw	tcp	22
+`, string(buff))
+
+	buff, err = addRuleBuff(buff, Rule{Action: "ACCEPT", Source: "loc", Destination: "all", Section: SectionEstablished})
+	assert.NoError(t, err, "expected no error")
+	rules := parseRules(buff)
+	assert.Equal(t, 5, len(rules), "expected 5 rules")
+	assert.Equal(t, Rule{Action: "ACCEPT", Source: "loc", Destination: "all", Section: SectionEstablished}, rules[2])
+
+	_, err = addRuleBuff(buff, Rule{Action: "ACCEPT", Source: "net", Destination: "fw", Protocol: "tcp", Dport: "22", Section: SectionNew})
+	assert.ErrorIs(t, err, ErrRuleAlreadyExists)
+
+	_, err = addRuleBuff(buff, Rule{Action: "ACCEPT", Source: "net", Destination: "fw", Section: "OLD"})
+	assert.ErrorIs(t, err, ErrInvalidSection)
+}
+
+func TestAddRuleBuff_FirstSection(t *testing.T) {
+	buff, err := addRuleBuff([]byte("#ACTION SOURCE DEST\nACCEPT net fw\n"), Rule{Action: "ACCEPT", Source: "all", Destination: "all", Section: SectionEstablished})
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, "#ACTION SOURCE DEST\n?SECTION ESTABLISHED\nACCEPT\tall\tall\t\t\t\t\n?SECTION NEW\nACCEPT net fw\n", string(buff))
+}
+
+func TestRemoveRuleBuff_KeepsDirectives(t *testing.T) {
+	buff, err := removeRuleBuff([]byte(rulesSections01), Rule{Action: "ACCEPT", Source: "all", Destination: "all", Section: SectionEstablished})
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, `#ACTION	SOURCE	DEST	PROTO	DPORT
+?SECTION ALL
+ACCEPT	all	all	icmp	8
+?SECTION ESTABLISHED
+?SECTION NEW
+ACCEPT	net	fw	tcp	22
+`, string(buff))
+
+	_, err = removeRuleBuff([]byte(rulesSections01), Rule{Action: "ACCEPT", Source: "all", Destination: "all", Section: SectionNew})
+	assert.ErrorIs(t, err, ErrRuleNotFound)
+}
+
+func TestAppRules_Sections(t *testing.T) {
+	id := uuid.NewString()
+	buff := []byte(rulesSections01)
+
+	ssh := Rule{Action: "ACCEPT", Source: "vpn", Destination: "fw", Protocol: "tcp", Dport: "22", Section: SectionNew}
+	established := Rule{Action: "ACCEPT", Source: "vpn", Destination: "all", Section: SectionEstablished}
+	untracked := Rule{Action: "DROP", Source: "vpn", Destination: "all", Section: SectionUntracked}
+	legacy := Rule{Action: "ACCEPT", Source: "vpn", Destination: "fw", Protocol: "udp", Dport: "53"}
+
+	var err error
+	for _, r := range []Rule{ssh, established, untracked, legacy} {
+		buff, err = addAppRuleBuff(id, buff, r)
+		assert.NoError(t, err, "adding rule %v", r)
+	}
+	_, err = addAppRuleBuff(id, buff, ssh)
+	assert.ErrorIs(t, err, ErrRuleAlreadyExists)
+
+	// Rules are placed in their section
+	all := parseRules(buff)
+	assert.Equal(t, 7, len(all), "expected 7 rules")
+	for _, r := range all {
+		if r.Source == "vpn" && r.Protocol != "udp" {
+			assert.Contains(t, []string{ssh.Section, established.Section, untracked.Section}, r.Section)
+		}
+	}
+	assert.Equal(t, SectionUntracked, all[3].Section)
+
+	// The block without section is appended at the end, in the NEW section
+	rules, err := getAppRulesBuff(id, buff)
+	assert.NoError(t, err, "getting rules")
+	legacy.Section = SectionNew
+	assert.Equal(t, []Rule{established, untracked, ssh, legacy}, rules)
+
+	for _, r := range rules {
+		buff, err = removeAppRuleBuff(id, buff, r)
+		assert.NoError(t, err, "removing rule %v", r)
+	}
+	rules, err = getAppRulesBuff(id, buff)
+	assert.NoError(t, err, "getting rules")
+	assert.Empty(t, rules)
+
+	_, err = removeAppRuleBuff(id, buff, ssh)
+	assert.ErrorIs(t, err, ErrRuleNotFound)
+}
+
+func TestAddRuleBuff_NoSectionGoesToNew(t *testing.T) {
+	buff, err := addRuleBuff([]byte("#ACTION SOURCE DEST\n"), Rule{Action: "ACCEPT", Source: "all", Destination: "all", Section: SectionAll})
+	assert.NoError(t, err, "expected no error")
+	buff, err = addRuleBuff(buff, Rule{Action: "ACCEPT", Source: "net", Destination: "fw"})
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, "#ACTION SOURCE DEST\n?SECTION ALL\nACCEPT\tall\tall\t\t\t\t\n?SECTION NEW\nACCEPT\tnet\tfw\t\t\t\t\n", string(buff))
+
+	// A rule without section is the same rule as in the NEW section
+	_, err = addRuleBuff(buff, Rule{Action: "ACCEPT", Source: "net", Destination: "fw", Section: SectionNew})
+	assert.ErrorIs(t, err, ErrRuleAlreadyExists)
+	buff, err = removeRuleBuff(buff, Rule{Action: "ACCEPT", Source: "net", Destination: "fw"})
+	assert.NoError(t, err, "expected no error")
+	assert.Equal(t, 1, len(parseRules(buff)))
+}
+
+func TestAppRules_NoSectionGoesToNew(t *testing.T) {
+	id := uuid.NewString()
+	buff, err := addAppRuleBuff(id, nil, Rule{Action: "ACCEPT", Source: "all", Destination: "all", Section: SectionAll})
+	assert.NoError(t, err, "expected no error")
+	ssh := Rule{Action: "ACCEPT", Source: "net", Destination: "fw", Protocol: "tcp", Dport: "22"}
+	buff, err = addAppRuleBuff(id, buff, ssh)
+	assert.NoError(t, err, "expected no error")
+
+	rules, err := getAppRulesBuff(id, buff)
+	assert.NoError(t, err, "getting rules")
+	assert.Equal(t, SectionAll, rules[0].Section)
+	assert.Equal(t, SectionNew, rules[1].Section)
+	assert.True(t, ssh.Equals(rules[1]))
+
+	buff, err = removeAppRuleBuff(id, buff, ssh)
+	assert.NoError(t, err, "expected no error")
+	rules, err = getAppRulesBuff(id, buff)
+	assert.NoError(t, err, "getting rules")
+	assert.Equal(t, 1, len(rules))
+}
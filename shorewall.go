@@ -9,13 +9,24 @@ import (
 )
 
 const (
-	shorewallConfigPath = "/etc/shorewall"
+	shorewallConfigPath  = "/etc/shorewall"
+	shorewall6ConfigPath = "/etc/shorewall6"
+	shorewallBinary      = "/usr/sbin/shorewall"
+	shorewall6Binary     = "/usr/sbin/shorewall6"
 
 	zonesFile      = "zones"
 	interfacesFile = "interfaces"
+	hostsFile      = "hosts"
 	policyFile     = "policy"
 	rulesFile      = "rules"
 	snatFile       = "snat"
+	proxyArpFile   = "proxyarp"
+	proxyNdpFile   = "proxyndp"
+	confFile       = "shorewall.conf"
+	conf6File      = "shorewall6.conf"
+	actionsFile    = "actions"
+	actionsStdFile = "actions.std"
+	conntrackFile  = "conntrack"
 )
 
 var (
@@ -24,6 +35,14 @@ var (
 	fullPolicyFile     = path.Join(shorewallConfigPath, policyFile)
 	fullRulesFile      = path.Join(shorewallConfigPath, rulesFile)
 	fullSnatFile       = path.Join(shorewallConfigPath, snatFile)
+	fullProxyArpFile   = path.Join(shorewallConfigPath, proxyArpFile)
+	fullConfFile       = path.Join(shorewallConfigPath, confFile)
+	fullActionsFile    = path.Join(shorewallConfigPath, actionsFile)
+	fullConntrackFile  = path.Join(shorewallConfigPath, conntrackFile)
+
+	// proxyndp is a shorewall6 file
+	fullProxyNdpFile    = path.Join(shorewall6ConfigPath, proxyNdpFile)
+	fullInterfaces6File = path.Join(shorewall6ConfigPath, interfacesFile)
 )
 
 func executeCommand(command string, args ...string) (string, string, error) {
@@ -37,19 +56,37 @@ func executeCommand(command string, args ...string) (string, string, error) {
 }
 
 func Version() (string, error) {
-	stdout, stderr, err := executeCommand("/usr/sbin/shorewall", "version")
+	return version(IPv4)
+}
+
+// Version6 returns the version of shorewall6.
+func Version6() (string, error) {
+	return version(IPv6)
+}
+
+func Reload() error {
+	return reload(IPv4)
+}
+
+// Reload6 reloads the shorewall6 configuration.
+func Reload6() error {
+	return reload(IPv6)
+}
+
+func version(family Family) (string, error) {
+	stdout, stderr, err := executeCommand(family.binary(), "version")
 	if err != nil {
-		err = errors.Join(fmt.Errorf("failed to execute shorewall version command: %w", err), errors.New(stderr))
+		err = errors.Join(fmt.Errorf("failed to execute %s version command: %w", family.program(), err), errors.New(stderr))
 		return "", err
 	}
 
 	return stdout, nil
 }
 
-func Reload() error {
-	_, stderr, err := executeCommand("/usr/sbin/shorewall", "reload")
+func reload(family Family) error {
+	_, stderr, err := executeCommand(family.binary(), "reload")
 	if err != nil {
-		err = errors.Join(fmt.Errorf("failed to reload Shorewall: %w", err), errors.New(stderr))
+		err = errors.Join(fmt.Errorf("failed to reload %s: %w", family.program(), err), errors.New(stderr))
 		return err
 	}
 	return nil
@@ -0,0 +1,284 @@
+package goshorewall
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+var ErrNoPolicy = errors.New("no policy for zone pair")
+
+// Connection is a new connection evaluated by SimulateConnection.
+type Connection struct {
+	// SourceZone and DestZone are zone names, the firewall zone can be
+	// written $FW.
+	SourceZone string
+	DestZone   string
+	// Source and Dest are the addresses of the connection. A zero address
+	// is unknown, the rules restricting it cannot be evaluated.
+	Source netip.Addr
+	Dest   netip.Addr
+	// Protocol is a name such as "tcp" or a number.
+	Protocol string
+	// Port is the destination port, or the ICMP type. SourcePort is the
+	// source port. Zero is unknown.
+	Port       uint16
+	SourcePort uint16
+}
+
+// Verdict is the outcome of SimulateConnection.
+type Verdict struct {
+	// Action is ACCEPT, DROP or REJECT when a rule decides the connection,
+	// otherwise the policy, such as ACCEPT or CONTINUE, without its default
+	// action.
+	Action string
+	// Rule is the rule deciding the connection, nil if no rule matches.
+	Rule *Rule
+	// Policy is the policy deciding the connection, nil if a rule decides
+	// it or for the implicit ACCEPT of the traffic within a zone.
+	Policy *Policy
+	// Undecided are the rules preceding the decision that may match the
+	// connection but cannot be evaluated offline, such as rules using
+	// ipsets, DNS names, user-defined actions or DNAT. The verdict assumes
+	// they do not match.
+	Undecided []Rule
+}
+
+// Allowed reports whether the connection is accepted.
+func (v Verdict) Allowed() bool {
+	return v.Action == "ACCEPT"
+}
+
+// Location returns the location of the rule or policy deciding the
+// connection.
+func (v Verdict) Location() Location {
+	switch {
+	case v.Rule != nil:
+		return v.Rule.Location
+	case v.Policy != nil:
+		return v.Policy.Location
+	}
+	return Location{}
+}
+
+// SimulateConnection reads the zones, rules and policy files of the
+// configuration of opts.Family and tells whether conn would be allowed. The
+// rules of the ALL and NEW sections are walked in order, expanding macros,
+// and the first rule accepting, dropping or rejecting the connection decides
+// it; otherwise its zone pair policy does. It returns an error wrapping
+// ErrNoPolicy if no policy applies.
+func SimulateConnection(conn Connection, opts ReadOptions) (Verdict, error) {
+	s, err := loadReferenceSet(opts.Family.configPath(), opts.Family.confFile(), opts, nil)
+	if err != nil {
+		return Verdict{}, err
+	}
+	return simulateConnection(s, opts.Family, []string{opts.Family.configPath(), shorewallSharePath}, conn)
+}
+
+// SimulateConnection is SimulateConnection for the configuration managed by
+// the App instance.
+func (a *App) SimulateConnection(conn Connection, opts ReadOptions) (Verdict, error) {
+	opts.Family = a.family
+	s, err := loadReferenceSet(a.basePath, a.family.confFile(), opts, nil)
+	if err != nil {
+		return Verdict{}, err
+	}
+	return simulateConnection(s, a.family, []string{a.basePath, shorewallSharePath}, conn)
+}
+
+// matchResult is the outcome of matching a rule against a connection.
+type matchResult int
+
+const (
+	noMatch matchResult = iota
+	match
+	maybeMatch
+)
+
+// and combines the results of the columns of a rule.
+func (m matchResult) and(other matchResult) matchResult {
+	if m == noMatch || other == noMatch {
+		return noMatch
+	}
+	return max(m, other)
+}
+
+func simulateConnection(s referenceSet, family Family, macroDirs []string, conn Connection) (Verdict, error) {
+	zt := newZoneTable(s.zones)
+	pair := ZonePair{Source: conn.SourceZone, Destination: conn.DestZone}
+	for _, z := range []*string{&pair.Source, &pair.Destination} {
+		if isFirewallReference(*z) {
+			*z = zt.firewall
+		}
+		if !slices.Contains(zt.names, *z) {
+			return Verdict{}, fmt.Errorf("%w: %s", ErrZoneNotFound, *z)
+		}
+	}
+
+	var v Verdict
+rules:
+	for i, r := range s.rules {
+		if r.Section != SectionAll && !isNewSection(r.Section) {
+			continue
+		}
+		action, result := simulateRule(zt, family, macroDirs, pair, conn, r)
+		switch {
+		case result == maybeMatch:
+			v.Undecided = append(v.Undecided, r)
+		case result == match && action == "CONTINUE":
+			// The connection skips the remaining rules
+			break rules
+		case result == match && action != "":
+			v.Action, v.Rule = action, &s.rules[i]
+			return v, nil
+		}
+	}
+
+	pt := newPolicyTable(zt, s.policies)
+	if i := pt.lookup(pair); i != -1 {
+		v.Action, v.Policy = policyVerdict(s.policies[i]), &s.policies[i]
+		return v, nil
+	}
+	if pair.Source == pair.Destination {
+		v.Action = "ACCEPT"
+		return v, nil
+	}
+	return Verdict{}, fmt.Errorf("%w: %s to %s", ErrNoPolicy, pair.Source, pair.Destination)
+}
+
+// simulateRule matches r against conn. It returns the action of the rule if
+// it decides the connection or, for CONTINUE, sends it to the policy, and an
+// empty action for the rules that do not, such as LOG. Macros are expanded,
+// the first rule of the body matching the connection decides it.
+func simulateRule(zt zoneTable, family Family, macroDirs []string, pair ZonePair, conn Connection, r Rule) (string, matchResult) {
+	a, err := ParseRuleAction(r.Action)
+	if err != nil {
+		return "", noMatch
+	}
+	if !zt.ruleMatcher(r).matches(pair) {
+		return "", noMatch
+	}
+	if !slices.Contains(builtinActions, a.Name) {
+		body, err := expandMacroRule(macroDirs, r, 0)
+		if err != nil {
+			// A user-defined action, or a macro that cannot be read
+			return "", maybeMatch
+		}
+		undecided := false
+		for _, b := range body {
+			action, m := simulateRule(zt, family, macroDirs, pair, conn, b)
+			switch {
+			case m == maybeMatch:
+				undecided = true
+			case m == match && action != "" && undecided:
+				return "", maybeMatch
+			case m == match && action != "":
+				return action, match
+			}
+		}
+		if undecided {
+			return "", maybeMatch
+		}
+		return "", noMatch
+	}
+
+	result := matchAddress(family, r.Source, conn.Source).
+		and(matchProtocol(r.Protocol, conn.Protocol)).
+		and(matchPorts(r.Dport, conn.Port)).
+		and(matchPorts(r.Sport, conn.SourcePort))
+	if (r.Origdest != "" && r.Origdest != "-") || r.limited {
+		result = result.and(maybeMatch)
+	}
+
+	base := strings.TrimPrefix(a.Name, "A_")
+	if base == "DNAT" || base == "REDIRECT" {
+		// The destination is the target of the translation
+		return "", result.and(maybeMatch)
+	}
+	result = result.and(matchAddress(family, r.Destination, conn.Dest))
+	switch base {
+	case "ACCEPT", "DROP", "REJECT", "CONTINUE":
+		return base, result
+	}
+	return "", result
+}
+
+// matchAddress matches the addresses of a SOURCE or DEST column against
+// addr.
+func matchAddress(family Family, spec string, addr netip.Addr) matchResult {
+	a, err := ParseAddress(family, spec)
+	switch {
+	case err != nil:
+		return maybeMatch
+	case a.Addresses.IsEmpty() && a.Interface == "":
+		return match
+	case !addr.IsValid() || a.Interface != "":
+		return maybeMatch
+	}
+
+	result := match
+	if len(a.Addresses.Include) > 0 {
+		result = matchAddressItems(a.Addresses.Include, addr)
+	}
+	switch matchAddressItems(a.Addresses.Exclude, addr) {
+	case match:
+		return noMatch
+	case maybeMatch:
+		return result.and(maybeMatch)
+	}
+	return result
+}
+
+func matchAddressItems(items []AddressItem, addr netip.Addr) matchResult {
+	result := noMatch
+	for _, item := range items {
+		switch item.Kind {
+		case AddressHost, AddressNetwork:
+			if item.Prefix.Masked().Contains(addr) {
+				return match
+			}
+		case AddressRange:
+			if item.From.Compare(addr) <= 0 && addr.Compare(item.To) <= 0 {
+				return match
+			}
+		default:
+			result = maybeMatch
+		}
+	}
+	return result
+}
+
+func matchProtocol(proto, connProto string) matchResult {
+	proto = normalizeProtocol(proto)
+	if proto == "" {
+		return match
+	}
+	list, negated := strings.CutPrefix(proto, "!")
+	if slices.Contains(strings.Split(list, ","), normalizeProtocol(connProto)) != negated {
+		return match
+	}
+	return noMatch
+}
+
+func matchPorts(ports string, port uint16) matchResult {
+	p, err := ParsePorts(ports)
+	switch {
+	case err != nil:
+		return maybeMatch
+	case p.IsAny():
+		return match
+	case port == 0:
+		return maybeMatch
+	case slices.ContainsFunc(p.Ranges, func(r PortRange) bool { return r.Name != "" }):
+		// Service names are not resolved
+		if !p.Negated && p.Contains(port) {
+			return match
+		}
+		return maybeMatch
+	case p.Contains(port):
+		return match
+	}
+	return noMatch
+}
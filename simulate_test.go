@@ -0,0 +1,87 @@
+package goshorewall
+
+import (
+	"net/netip"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulateConnection(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, path.Join(dir, zonesFile), "fw\tfirewall\nnet\tipv4\nloc\tipv4\ndmz\tipv4\n")
+	writeFile(t, path.Join(dir, policyFile), "$FW\tall\tACCEPT\nloc\tnet\tACCEPT\nnet\tall\tDROP:Drop\tinfo\nall\tall\tREJECT\n")
+	writeFile(t, path.Join(dir, "macro.Web"), macroWeb)
+	writeFile(t, path.Join(dir, rulesFile), "?SECTION ALL\n"+
+		"DROP\tnet:203.0.113.0/24\tall\n"+
+		"?SECTION NEW\n"+
+		"ACCEPT\tnet\t$FW\ttcp\t22\n"+
+		"Web(ACCEPT)\tnet\tdmz:10.0.0.5\n"+
+		"ACCEPT\tnet:+trusted\tloc\ttcp\t3389\n"+
+		"LOG:info\tnet\tloc\n"+
+		"REJECT\tloc\tnet\ttcp\t25\n"+
+		"ACCEPT\tnet\t$FW\ttcp\t8080\t-\t-\t3/min\n")
+	app, err := NewAppWithBasePath(dir)
+	assert.NoError(t, err)
+
+	addr := netip.MustParseAddr
+	testCases := []struct {
+		name      string
+		conn      Connection
+		action    string
+		location  Location
+		undecided []int
+	}{
+		{"rule", Connection{SourceZone: "net", Source: addr("198.51.100.7"), DestZone: "$FW", Protocol: "tcp", Port: 22},
+			"ACCEPT", Location{path.Join(dir, rulesFile), 4}, nil},
+		{"ALL section", Connection{SourceZone: "net", Source: addr("203.0.113.9"), DestZone: "fw", Protocol: "tcp", Port: 22},
+			"DROP", Location{path.Join(dir, rulesFile), 2}, nil},
+		{"macro", Connection{SourceZone: "net", Source: addr("198.51.100.7"), DestZone: "dmz", Dest: addr("10.0.0.5"), Protocol: "6", Port: 443},
+			"ACCEPT", Location{path.Join(dir, rulesFile), 5}, nil},
+		{"macro other host", Connection{SourceZone: "net", Source: addr("198.51.100.7"), DestZone: "dmz", Dest: addr("10.0.0.6"), Protocol: "tcp", Port: 443},
+			"DROP", Location{path.Join(dir, policyFile), 3}, nil},
+		{"ipset", Connection{SourceZone: "net", Source: addr("198.51.100.7"), DestZone: "loc", Protocol: "tcp", Port: 3389},
+			"DROP", Location{path.Join(dir, policyFile), 3}, []int{6}},
+		{"reject", Connection{SourceZone: "loc", DestZone: "net", Protocol: "tcp", Port: 25},
+			"REJECT", Location{path.Join(dir, rulesFile), 8}, nil},
+		{"rate", Connection{SourceZone: "net", Source: addr("198.51.100.7"), DestZone: "fw", Protocol: "tcp", Port: 8080},
+			"DROP", Location{path.Join(dir, policyFile), 3}, []int{9}},
+		{"policy", Connection{SourceZone: "loc", DestZone: "net", Protocol: "tcp", Port: 80},
+			"ACCEPT", Location{path.Join(dir, policyFile), 2}, nil},
+		{"intra-zone", Connection{SourceZone: "loc", DestZone: "loc", Protocol: "udp", Port: 53},
+			"ACCEPT", Location{}, nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			v, err := app.SimulateConnection(tc.conn, ReadOptions{})
+			assert.NoError(t, err)
+			assert.Equal(t, tc.action, v.Action)
+			assert.Equal(t, tc.action == "ACCEPT", v.Allowed())
+			assert.Equal(t, tc.location, v.Location())
+			var undecided []int
+			for _, r := range v.Undecided {
+				undecided = append(undecided, r.Location.Line)
+			}
+			assert.Equal(t, tc.undecided, undecided)
+		})
+	}
+
+	_, err = app.SimulateConnection(Connection{SourceZone: "vpn", DestZone: "net"}, ReadOptions{})
+	assert.ErrorIs(t, err, ErrZoneNotFound)
+}
+
+func TestSimulateConnection_NoPolicy(t *testing.T) {
+	s := referenceSet{zones: parseZones([]byte("fw\tfirewall\nnet\tipv4\n"))}
+	_, err := simulateConnection(s, IPv4, nil, Connection{SourceZone: "net", DestZone: "fw", Protocol: "tcp", Port: 22})
+	assert.ErrorIs(t, err, ErrNoPolicy)
+}
+
+func TestMatchPorts(t *testing.T) {
+	assert.Equal(t, match, matchPorts("-", 0))
+	assert.Equal(t, match, matchPorts("80,443,8000:8100", 8080))
+	assert.Equal(t, noMatch, matchPorts("80,443", 22))
+	assert.Equal(t, maybeMatch, matchPorts("80", 0))
+	assert.Equal(t, maybeMatch, matchPorts("ssh", 22))
+	assert.Equal(t, noMatch, matchPorts("!22", 22))
+}
@@ -1,10 +1,8 @@
 package goshorewall
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
-	"os"
 	"slices"
 	"strings"
 )
@@ -18,6 +16,11 @@ type Snat struct {
 	Action      string
 	Source      string
 	Destination string
+	// Comment is the ?COMMENT annotation of the entry. It is not considered
+	// when comparing entries.
+	Comment string
+	// Location is the file and line the entry was read from.
+	Location Location
 }
 
 func (s Snat) Compare(other Snat) int {
@@ -35,15 +38,30 @@ func (s Snat) Equals(other Snat) bool {
 }
 
 func (s Snat) Format() string {
-	return fmt.Sprintf("%s\t%s\t%s", s.Action, s.Source, s.Destination)
+	return formatWithComment(s.Comment, formatColumns(snatColumns, s.Action, s.Source, s.Destination))
+}
+
+// FormatNamed formats the snat entry in the column=value form.
+func (s Snat) FormatNamed() string {
+	return formatWithComment(s.Comment, formatNamedColumns(snatColumns, s.Action, s.Source, s.Destination))
 }
 
 func Snats() ([]Snat, error) {
-	buff, err := os.ReadFile(fullSnatFile)
+	entries, _, err := SnatsWithOptions(ReadOptions{})
+	return entries, err
+}
+
+// SnatsWithOptions reads the snat file, following includes and evaluating
+// conditional directives as configured by opts. In lenient mode the lines
+// that could not be parsed are returned as warnings, in strict mode they
+// are returned as a ParseErrors error.
+func SnatsWithOptions(opts ReadOptions) ([]Snat, []ParseError, error) {
+	lines, err := loadConfigFile(snatFile, opts)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return getSnatsBuff(buff)
+	issues := &parseIssues{}
+	return result(parseSnatsLines(lines, issues), issues, opts.Strict)
 }
 
 func AddSnat(snat Snat) error {
@@ -54,6 +72,10 @@ func RemoveSnat(snat Snat) error {
 	return readWriteFile(fullSnatFile, removeSnatBuff, snat)
 }
 
+func UpdateSnat(from, to Snat) error {
+	return readWriteFile(fullSnatFile, updateSnatBuff, entryUpdate[Snat]{old: from, new: to})
+}
+
 func getSnatsBuff(buff []byte) ([]Snat, error) {
 	return parseSnats(buff), nil
 }
@@ -73,45 +95,61 @@ func addSnatBuff(buff []byte, snat Snat) ([]byte, error) {
 	return fmt.Appendf(buff, "%s\n", snat.Format()), nil
 }
 
+// removeSnatBuff removes the first line matching snat, leaving the other lines
+// of buff untouched.
 func removeSnatBuff(buff []byte, snat Snat) ([]byte, error) {
+	t := parseCST(buff)
+	i, _ := t.find(func(l logicalLine, _ directiveState) bool {
+		return slices.ContainsFunc(parseSnatsLines([]logicalLine{l}, nil), snat.Equals)
+	})
+	if i == -1 {
+		return nil, ErrSnatNotFound
+	}
+	t.remove(i)
+	return t.Bytes(), nil
+}
+
+// updateSnatBuff replaces the snat matching u.old with u.new,
+// leaving the other lines of buff untouched.
+func updateSnatBuff(buff []byte, u entryUpdate[Snat]) ([]byte, error) {
 	snats, err := getSnatsBuff(buff)
 	if err != nil {
 		return nil, err
 	}
+	if !u.new.Equals(u.old) && slices.ContainsFunc(snats, u.new.Equals) {
+		return nil, ErrSnatAlreadyExists
+	}
 
-	index := slices.IndexFunc(snats, func(s Snat) bool {
-		return s.Equals(snat)
+	t := parseCST(buff)
+	i, state := t.find(func(l logicalLine, _ directiveState) bool {
+		return slices.ContainsFunc(parseSnatsLines([]logicalLine{l}, nil), u.old.Equals)
 	})
-	if index == -1 {
+	if i == -1 {
 		return nil, ErrSnatNotFound
 	}
+	snat := u.new
+	snat.Comment = t.entryComment(i, state, snat.Comment)
+	t.replace(i, snat.Format())
+	return t.Bytes(), nil
+}
 
-	snats = slices.Delete(snats, index, index+1)
-
-	var b bytes.Buffer
-	for _, s := range snats {
-		line := fmt.Sprintf("%s\n", s.Format())
-		b.WriteString(line)
-	}
-	return b.Bytes(), nil
+func parseSnats(data []byte) []Snat {
+	return parseSnatsLines(tokenize(data), nil)
 }
 
-func parseSnats(data []byte) (snats []Snat) {
-	iter := bytes.Lines(data)
-	for z := range iter {
-		z = bytes.TrimSpace(z)
-		if len(z) == 0 || z[0] == '#' {
-			continue
-		}
-		z = bytes.ReplaceAll(z, []byte("\t"), []byte(" "))
-		parts := bytes.Fields(z)
-		if len(parts) < 3 {
+func parseSnatsLines(lines []logicalLine, issues *parseIssues) (snats []Snat) {
+	var state directiveState
+	for _, l := range lines {
+		parts, ok := entryColumns(l, &state, snatColumns, 3, issues)
+		if !ok {
 			continue
 		}
 		snat := Snat{
-			Action:      string(parts[0]),
-			Source:      string(parts[1]),
-			Destination: string(parts[2]),
+			Action:      parts[0],
+			Source:      parts[1],
+			Destination: parts[2],
+			Comment:     state.comment,
+			Location:    l.location(),
 		}
 		snats = append(snats, snat)
 	}
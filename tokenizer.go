@@ -0,0 +1,253 @@
+package goshorewall
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// logicalLine is a logical line of a Shorewall configuration file: one or
+// more physical lines joined by "\" continuations, with comments removed and
+// split into columns.
+type logicalLine struct {
+	// line is the 1-based number of the first physical line.
+	line int
+	// start and end are the byte offsets of the physical lines in the
+	// tokenized buffer, end includes the trailing newline.
+	start int
+	end   int
+	// fields are the columns of the line, with quotes removed.
+	fields []string
+	// comment is the text of the trailing comment, without the leading '#'.
+	comment string
+	// directive is set for compiler directives such as "?SECTION NEW" or the
+	// legacy "INCLUDE file". The name of the directive is in fields[0].
+	directive bool
+	// file is the path of the file the line was read from, if any.
+	file string
+}
+
+// directiveName returns the upper case name of the directive without the
+// leading '?', or "" if l is not a directive.
+func (l logicalLine) directiveName() string {
+	if !l.directive {
+		return ""
+	}
+	return strings.ToUpper(strings.TrimPrefix(l.fields[0], "?"))
+}
+
+// directiveArgs returns the text following the directive name.
+func (l logicalLine) directiveArgs() string {
+	if !l.directive {
+		return ""
+	}
+	return strings.Join(l.fields[1:], " ")
+}
+
+// directiveState tracks the directives that apply to the entries following
+// them in a file.
+type directiveState struct {
+	// comment is the text of the last ?COMMENT directive.
+	comment string
+	// section is the argument of the last ?SECTION directive.
+	section string
+	// format is the argument of the last ?FORMAT directive, 0 if there is
+	// none or if it is not a number.
+	format int
+}
+
+// update updates the state with l and reports whether l is a directive.
+func (s *directiveState) update(l logicalLine) bool {
+	switch l.directiveName() {
+	case "":
+		return false
+	case "COMMENT":
+		s.comment = l.directiveArgs()
+	case "SECTION":
+		s.section = strings.ToUpper(l.directiveArgs())
+	case "FORMAT":
+		s.format, _ = strconv.Atoi(l.directiveArgs())
+	}
+	return true
+}
+
+// formatAt returns the ?FORMAT in effect at the end of buff, 0 if there is
+// none.
+func formatAt(buff []byte) int {
+	var state directiveState
+	for _, l := range tokenize(buff) {
+		state.update(l)
+	}
+	return state.format
+}
+
+// formatDirective returns the ?FORMAT directive line selecting format.
+func formatDirective(format int) []byte {
+	return fmt.Appendf(nil, "?FORMAT %d\n", format)
+}
+
+// formatWithComment wraps the formatted entry line in a ?COMMENT directive
+// scoped to that entry only.
+func formatWithComment(comment, line string) string {
+	comment = strings.Join(strings.Fields(comment), " ")
+	if comment == "" {
+		return line
+	}
+	return "?COMMENT " + comment + "\n" + line + "\n?COMMENT"
+}
+
+// legacyDirectives are directives that can be written without the leading
+// '?' for compatibility with older Shorewall versions.
+var legacyDirectives = []string{"INCLUDE", "SECTION", "COMMENT", "FORMAT"}
+
+// tokenize splits data into the logical lines used by every Shorewall
+// columnar file:
+//
+//   - '#' starts a comment that runs until the end of the physical line,
+//     unless it appears between double quotes;
+//   - a physical line ending with '\' (after removing its comment) is
+//     continued on the next physical line, without its leading blanks;
+//   - double quotes group a column containing blanks and are removed;
+//   - blank lines and comment only lines are skipped;
+//   - the text of a ?COMMENT directive is kept verbatim.
+func tokenize(data []byte) []logicalLine {
+	var lines []logicalLine
+	var cur *logicalLine
+	var text strings.Builder
+	offset, number := 0, 0
+
+	for raw := range bytes.Lines(data) {
+		number++
+		start := offset
+		offset += len(raw)
+
+		content := strings.TrimRight(string(raw), "\r\n")
+
+		if cur == nil {
+			trimmed := strings.TrimSpace(content)
+			if isCommentDirective(trimmed) {
+				name, rest := trimmed, ""
+				if i := strings.IndexAny(trimmed, " \t"); i != -1 {
+					name, rest = trimmed[:i], strings.TrimSpace(trimmed[i:])
+				}
+				l := logicalLine{line: number, start: start, end: offset, fields: []string{name}, directive: true}
+				if rest != "" {
+					l.fields = append(l.fields, rest)
+				}
+				lines = append(lines, l)
+				continue
+			}
+			cur = &logicalLine{line: number, start: start}
+			text.Reset()
+		} else {
+			// As in Shorewall, the leading blanks of a continuation line are
+			// dropped, so that "a,\" followed by "\tb" reads "a,b".
+			content = strings.TrimLeft(content, " \t")
+		}
+
+		content, comment := stripComment(content)
+		if comment != "" {
+			cur.comment = comment
+		}
+
+		trimmed := strings.TrimRight(content, " \t")
+		if strings.HasSuffix(trimmed, "\\") {
+			text.WriteString(strings.TrimSuffix(trimmed, "\\"))
+			continue
+		}
+		text.WriteString(content)
+
+		cur.end = offset
+		cur.fields = splitFields(text.String())
+		if len(cur.fields) > 0 {
+			first := cur.fields[0]
+			cur.directive = strings.HasPrefix(first, "?") || isLegacyDirective(first)
+			lines = append(lines, *cur)
+		}
+		cur = nil
+	}
+
+	// A continuation on the last line of the buffer
+	if cur != nil {
+		cur.end = offset
+		cur.fields = splitFields(text.String())
+		if len(cur.fields) > 0 {
+			first := cur.fields[0]
+			cur.directive = strings.HasPrefix(first, "?") || isLegacyDirective(first)
+			lines = append(lines, *cur)
+		}
+	}
+	return lines
+}
+
+func isLegacyDirective(field string) bool {
+	for _, d := range legacyDirectives {
+		if field == d {
+			return true
+		}
+	}
+	return false
+}
+
+// isCommentDirective reports whether line is a ?COMMENT directive, whose
+// argument is free text.
+func isCommentDirective(line string) bool {
+	name := line
+	if i := strings.IndexAny(line, " \t"); i != -1 {
+		name = line[:i]
+	}
+	return strings.EqualFold(name, "?COMMENT")
+}
+
+// stripComment removes a trailing comment from line, ignoring '#' between
+// double quotes.
+func stripComment(line string) (string, string) {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"':
+			quoted = !quoted
+		case '#':
+			if !quoted {
+				return line[:i], strings.TrimSpace(line[i+1:])
+			}
+		}
+	}
+	return line, ""
+}
+
+// splitFields splits s on blanks, keeping blanks between double quotes in the
+// same field and removing the quotes.
+func splitFields(s string) []string {
+	var fields []string
+	var b strings.Builder
+	quoted, inField := false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			quoted = !quoted
+			inField = true
+		case !quoted && isBlank(c):
+			if inField {
+				fields = append(fields, b.String())
+				b.Reset()
+				inField = false
+			}
+		default:
+			b.WriteByte(c)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, b.String())
+	}
+	return fields
+}
+
+// isBlank reports whether c separates columns. As Perl's \s, used by
+// Shorewall, it includes carriage returns and form feeds.
+func isBlank(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\v' || c == '\f'
+}
@@ -0,0 +1,128 @@
+package goshorewall
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const tokenize01 = `#ACTION	SOURCE	DEST	PROTO	DPORT
+?SECTION NEW
+ACCEPT	net	fw	tcp	22 # ssh
+ACCEPT	net \
+	fw	udp \
+	51820
+
+?COMMENT Ticket #1234: monitoring
+ACCEPT	mon	fw	tcp	"9100"
+INCLUDE rules.d/web
+LOG:info	net	fw	-	-	-	-	"with # hash"
+`
+
+func TestTokenize(t *testing.T) {
+	lines := tokenize([]byte(tokenize01))
+	assert.Equal(t, 7, len(lines), "expected 7 logical lines")
+
+	assert.True(t, lines[0].directive)
+	assert.Equal(t, "SECTION", lines[0].directiveName())
+	assert.Equal(t, "NEW", lines[0].directiveArgs())
+	assert.Equal(t, 2, lines[0].line)
+
+	assert.False(t, lines[1].directive)
+	assert.Equal(t, []string{"ACCEPT", "net", "fw", "tcp", "22"}, lines[1].fields)
+	assert.Equal(t, "ssh", lines[1].comment)
+	assert.Equal(t, 3, lines[1].line)
+
+	assert.Equal(t, []string{"ACCEPT", "net", "fw", "udp", "51820"}, lines[2].fields)
+	assert.Equal(t, 4, lines[2].line)
+	assert.Equal(t, "ACCEPT\tnet \\\n\tfw\tudp \\\n\t51820\n", tokenize01[lines[2].start:lines[2].end])
+
+	assert.Equal(t, "COMMENT", lines[3].directiveName())
+	assert.Equal(t, "Ticket #1234: monitoring", lines[3].directiveArgs())
+
+	assert.Equal(t, []string{"ACCEPT", "mon", "fw", "tcp", "9100"}, lines[4].fields)
+
+	assert.Equal(t, "INCLUDE", lines[5].directiveName())
+	assert.Equal(t, "rules.d/web", lines[5].directiveArgs())
+
+	assert.Equal(t, "with # hash", lines[6].fields[7])
+	assert.Equal(t, "", lines[6].comment)
+}
+
+func TestTokenize_TrailingContinuation(t *testing.T) {
+	lines := tokenize([]byte("ACCEPT net \\\nfw \\"))
+	assert.Equal(t, 1, len(lines), "expected 1 logical line")
+	assert.Equal(t, []string{"ACCEPT", "net", "fw"}, lines[0].fields)
+	assert.Equal(t, 17, lines[0].end)
+}
+
+func TestTokenize_ContinuationBlanks(t *testing.T) {
+	lines := tokenize([]byte("ACCEPT\tnet:1.2.3.4,\\\n\t5.6.7.8\tfw\n"))
+	assert.Equal(t, 1, len(lines), "expected 1 logical line")
+	assert.Equal(t, []string{"ACCEPT", "net:1.2.3.4,5.6.7.8", "fw"}, lines[0].fields)
+
+	lines = tokenize([]byte("ACCEPT net\vfw\ftcp 22\r\n"))
+	assert.Equal(t, []string{"ACCEPT", "net", "fw", "tcp", "22"}, lines[0].fields)
+}
+
+func TestParseRules_InlineComment(t *testing.T) {
+	rules := parseRules([]byte("ACCEPT net fw tcp 22 # ssh\nACCEPT net \\\n  fw udp 53\n"))
+	assert.Equal(t, []Rule{
+		{Action: "ACCEPT", Source: "net", Destination: "fw", Protocol: "tcp", Dport: "22"},
+		{Action: "ACCEPT", Source: "net", Destination: "fw", Protocol: "udp", Dport: "53"},
+	}, rules)
+}
+
+const comments01 = `?COMMENT JIRA-1234 ssh from office
+ACCEPT	net:1.2.3.4	fw	tcp	22
+ACCEPT	net:1.2.3.5	fw	tcp	22
+?COMMENT
+ACCEPT	net	fw	tcp	80
+?COMMENT	JIRA-42
+MASQUERADE	10.0.0.0/8	eth0
+`
+
+func TestParse_Comment(t *testing.T) {
+	rules := parseRules([]byte(comments01))
+	assert.Equal(t, 4, len(rules), "expected 4 entries")
+	assert.Equal(t, "JIRA-1234 ssh from office", rules[0].Comment)
+	assert.Equal(t, "JIRA-1234 ssh from office", rules[1].Comment)
+	assert.Equal(t, "", rules[2].Comment)
+
+	snats := parseSnats([]byte(comments01))
+	assert.Equal(t, "JIRA-42", snats[len(snats)-1].Comment)
+
+	policies := parsePolicies([]byte("?COMMENT default\nall all REJECT info\n"))
+	assert.Equal(t, "default", policies[0].Comment)
+}
+
+func TestFormat_Comment(t *testing.T) {
+	rule := Rule{Action: "ACCEPT", Source: "net", Destination: "fw", Protocol: "tcp", Dport: "22", Comment: "JIRA-1234"}
+	assert.Equal(t, "?COMMENT JIRA-1234\nACCEPT\tnet\tfw\ttcp\t22\t\t\n?COMMENT", rule.Format())
+
+	zone := Zone{Name: "net", Type: "ipv4", Comment: "multi\nline"}
+	assert.Equal(t, "?COMMENT multi line\nnet\tipv4\n?COMMENT", zone.Format())
+
+	buff, err := addRuleBuff([]byte(rules01), rule)
+	assert.NoError(t, err, "expected no error")
+	rules := parseRules(buff)
+	assert.Equal(t, rule, rules[len(rules)-1])
+
+	// The comment must not leak onto the following entries
+	buff = append(buff, "ACCEPT net fw tcp 443\n"...)
+	rules = parseRules(buff)
+	assert.Equal(t, "", rules[len(rules)-1].Comment)
+
+	// Removing the rule removes its comment
+	buff, err = removeRuleBuff(buff, rule)
+	assert.NoError(t, err, "expected no error")
+	assert.NotContains(t, string(buff), "?COMMENT")
+}
+
+func TestRemoveBuff_KeepsComments(t *testing.T) {
+	buff, err := removeSnatBuff([]byte(comments01), Snat{Action: "ACCEPT", Source: "net:1.2.3.4", Destination: "fw"})
+	assert.NoError(t, err, "expected no error")
+	snats := parseSnats(buff)
+	assert.Equal(t, "JIRA-1234 ssh from office", snats[0].Comment)
+	assert.Equal(t, "JIRA-42", snats[2].Comment)
+}
@@ -1,9 +1,11 @@
 package goshorewall
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path"
 )
 
 func readWriteFile[S any](path string, f func([]byte, S) ([]byte, error), i S) error {
@@ -31,3 +33,37 @@ func readWriteFile[S any](path string, f func([]byte, S) ([]byte, error), i S) e
 	}
 	return file.Truncate(int64(n))
 }
+
+// writeTempFile writes buff to a new temporary file in the directory of p,
+// with the permissions of p, and returns its path. Renaming it to p then
+// replaces the content of p at once.
+func writeTempFile(p string, buff []byte) (string, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(path.Dir(p), "."+path.Base(p)+".*")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(buff)
+	err = errors.Join(err, f.Chmod(info.Mode().Perm()), f.Sync(), f.Close())
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// replaceFile replaces the content of p with buff through a temporary file.
+func replaceFile(p string, buff []byte) error {
+	t, err := writeTempFile(p, buff)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(t, p); err != nil {
+		os.Remove(t)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package goshorewall
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ZoneGraph is the connectivity graph of a configuration: its nodes are the
+// zones and its edges the zone pairs whose connections are accepted, by the
+// policy or by at least one rule.
+type ZoneGraph struct {
+	Zones []ZoneNode `json:"zones"`
+	Edges []ZoneEdge `json:"edges"`
+}
+
+// ZoneNode is a zone of a ZoneGraph.
+type ZoneNode struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Interfaces are the interfaces of the zone, from the interfaces and
+	// hosts files.
+	Interfaces []string `json:"interfaces,omitempty"`
+	AppID      string   `json:"app_id,omitempty"`
+}
+
+// ZoneEdge is a zone pair of a ZoneGraph.
+type ZoneEdge struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	// Policy is the effective policy of the pair without its default
+	// action, such as ACCEPT or DROP. It is ACCEPT for the traffic within a
+	// zone without policy, and empty for pairs without policy.
+	Policy      string       `json:"policy"`
+	PolicyEntry *GraphEntry  `json:"policy_entry,omitempty"`
+	Rules       []GraphEntry `json:"rules,omitempty"`
+	// AppIDs are the IDs of the Apps owning the policy and the rules of
+	// the edge.
+	AppIDs []string `json:"app_ids,omitempty"`
+}
+
+// GraphEntry is a policy or a rule of a ZoneEdge.
+type GraphEntry struct {
+	// Entry holds the columns of the entry separated by a space.
+	Entry string `json:"entry"`
+	File  string `json:"file,omitempty"`
+	Line  int    `json:"line,omitempty"`
+	AppID string `json:"app_id,omitempty"`
+}
+
+// BuildZoneGraph reads the zones, interfaces, hosts, policy and rules files
+// of the configuration of opts.Family and returns its connectivity graph. The
+// permitting rules of an edge are the rules of the ALL and NEW sections
+// accepting connections, directly or through a macro, or forwarding them
+// with DNAT or REDIRECT.
+func BuildZoneGraph(opts ReadOptions) (ZoneGraph, error) {
+	s, err := loadReferenceSet(opts.Family.configPath(), opts.Family.confFile(), opts, nil)
+	if err != nil {
+		return ZoneGraph{}, err
+	}
+	return buildZoneGraph(s)
+}
+
+// ZoneGraph is BuildZoneGraph for the configuration managed by the App
+// instance. The graph covers the whole files, the AppID fields tell which
+// entries belong to which App.
+func (a *App) ZoneGraph(opts ReadOptions) (ZoneGraph, error) {
+	opts.Family = a.family
+	s, err := loadReferenceSet(a.basePath, a.family.confFile(), opts, nil)
+	if err != nil {
+		return ZoneGraph{}, err
+	}
+	return buildZoneGraph(s)
+}
+
+func buildZoneGraph(s referenceSet) (ZoneGraph, error) {
+	var locations []Location
+	for _, z := range s.zones {
+		locations = append(locations, z.Location)
+	}
+	for _, p := range s.policies {
+		locations = append(locations, p.Location)
+	}
+	for _, r := range s.rules {
+		locations = append(locations, r.Location)
+	}
+	owners, err := locationOwners(locations)
+	if err != nil {
+		return ZoneGraph{}, err
+	}
+	zoneApps, owners := owners[:len(s.zones)], owners[len(s.zones):]
+	policyApps, ruleApps := owners[:len(s.policies)], owners[len(s.policies):]
+
+	g := ZoneGraph{Zones: []ZoneNode{}, Edges: []ZoneEdge{}}
+	zt := newZoneTable(s.zones)
+	for i, z := range s.zones {
+		node := ZoneNode{Name: zoneName(z), Type: z.Type, AppID: zoneApps[i]}
+		for _, iface := range s.interfaces {
+			if iface.Zone == node.Name && !slices.Contains(node.Interfaces, iface.Name) {
+				node.Interfaces = append(node.Interfaces, iface.Name)
+			}
+		}
+		for _, h := range s.hosts {
+			if h.Zone == node.Name && !slices.Contains(node.Interfaces, h.Interface()) {
+				node.Interfaces = append(node.Interfaces, h.Interface())
+			}
+		}
+		g.Zones = append(g.Zones, node)
+	}
+
+	pt := newPolicyTable(zt, s.policies)
+	for _, pair := range zt.pairs() {
+		e := ZoneEdge{Source: pair.Source, Destination: pair.Destination}
+		if i := pt.lookup(pair); i != -1 {
+			p := s.policies[i]
+			e.Policy = policyVerdict(p)
+			e.PolicyEntry = &GraphEntry{
+				Entry: entryText(formatColumns(policyColumns, p.Source, p.Destination, p.Policy, p.Log)),
+				File:  p.Location.File,
+				Line:  p.Location.Line,
+				AppID: policyApps[i],
+			}
+		} else if pair.Source == pair.Destination {
+			e.Policy = "ACCEPT"
+		}
+		for i, r := range s.rules {
+			if isPermittingRule(r) && zt.ruleMatcher(r).matches(pair) {
+				e.Rules = append(e.Rules, GraphEntry{
+					Entry: entryText(r.formatColumns()),
+					File:  r.Location.File,
+					Line:  r.Location.Line,
+					AppID: ruleApps[i],
+				})
+			}
+		}
+		if e.Policy != "ACCEPT" && len(e.Rules) == 0 {
+			continue
+		}
+		// Traffic within a zone is only shown when configured explicitly
+		if pair.Source == pair.Destination && e.PolicyEntry == nil && len(e.Rules) == 0 {
+			continue
+		}
+		if e.PolicyEntry != nil && e.PolicyEntry.AppID != "" {
+			e.AppIDs = append(e.AppIDs, e.PolicyEntry.AppID)
+		}
+		for _, r := range e.Rules {
+			if r.AppID != "" && !slices.Contains(e.AppIDs, r.AppID) {
+				e.AppIDs = append(e.AppIDs, r.AppID)
+			}
+		}
+		g.Edges = append(g.Edges, e)
+	}
+	return g, nil
+}
+
+// isPermittingRule reports whether r is a rule of the ALL or NEW section
+// accepting or forwarding connections.
+func isPermittingRule(r Rule) bool {
+	if r.Section != SectionAll && !isNewSection(r.Section) {
+		return false
+	}
+	switch strings.TrimPrefix(ruleVerdict(r), "A_") {
+	case "ACCEPT", "DNAT", "REDIRECT":
+		return true
+	}
+	return false
+}
+
+func entryText(columns string) string {
+	return strings.Join(strings.Fields(columns), " ")
+}
+
+// JSON returns the graph encoded in indented JSON.
+func (g ZoneGraph) JSON() ([]byte, error) {
+	return json.MarshalIndent(g, "", "  ")
+}
+
+// DOT returns the graph in the Graphviz DOT language. The firewall zone is
+// drawn as a box. Edges are labelled with their policy and the number of
+// permitting rules, the edges accepted only by rules are dashed, and the
+// App IDs are given in the tooltip.
+func (g ZoneGraph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph zones {\n")
+	for _, z := range g.Zones {
+		label := z.Name + `\n` + z.Type
+		if len(z.Interfaces) > 0 {
+			label += `\n` + strings.Join(z.Interfaces, ",")
+		}
+		attrs := []string{"label=" + dotQuote(label)}
+		if z.Type == "firewall" {
+			attrs = append(attrs, "shape=box")
+		}
+		if z.AppID != "" {
+			attrs = append(attrs, "tooltip="+dotQuote("app: "+z.AppID))
+		}
+		fmt.Fprintf(&b, "\t%s [%s];\n", dotQuote(z.Name), strings.Join(attrs, ", "))
+	}
+	for _, e := range g.Edges {
+		label := e.Policy
+		if label == "" {
+			label = "no policy"
+		}
+		switch len(e.Rules) {
+		case 0:
+		case 1:
+			label += `\n1 rule`
+		default:
+			label += fmt.Sprintf(`\n%d rules`, len(e.Rules))
+		}
+		attrs := []string{"label=" + dotQuote(label)}
+		if e.Policy != "ACCEPT" {
+			attrs = append(attrs, "style=dashed")
+		}
+		if len(e.AppIDs) > 0 {
+			attrs = append(attrs, "tooltip="+dotQuote("apps: "+strings.Join(e.AppIDs, ",")))
+		}
+		fmt.Fprintf(&b, "\t%s -> %s [%s];\n", dotQuote(e.Source), dotQuote(e.Destination), strings.Join(attrs, ", "))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// dotQuote quotes s as a DOT string. Backslashes are kept, so that labels
+// can use escapes such as \n.
+func dotQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
@@ -0,0 +1,59 @@
+package goshorewall
+
+import (
+	"encoding/json"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZoneGraph(t *testing.T) {
+	const id = "4f3c1a52-8d1e-4c7b-9a36-2f5b0e7d9c01"
+
+	dir := t.TempDir()
+	writeFile(t, path.Join(dir, zonesFile), "fw\tfirewall\nnet\tipv4\n"+string(wrapBuffWithAppIdentifier([]byte("dmz\tipv4\n"), id)))
+	writeFile(t, path.Join(dir, interfacesFile), "?FORMAT 2\nnet\teth0\tdhcp\n"+string(wrapBuffWithAppIdentifier([]byte("dmz\teth2\n"), id)))
+	writeFile(t, path.Join(dir, policyFile), "$FW\tall\tACCEPT\n"+string(wrapBuffWithAppIdentifier([]byte("dmz\tnet\tACCEPT\n"), id))+"all\tall\tREJECT\n")
+	writeFile(t, path.Join(dir, rulesFile), "ACCEPT\tnet\t$FW\ttcp\t22\n"+
+		string(wrapBuffWithAppIdentifier([]byte("DNAT\tnet\tdmz:10.0.0.5\ttcp\t80\nDROP\tnet\tdmz\ttcp\t23\n"), id)))
+	app, err := NewAppWithBasePath(dir)
+	assert.NoError(t, err)
+
+	g, err := app.ZoneGraph(ReadOptions{})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []ZoneNode{
+		{Name: "fw", Type: "firewall"},
+		{Name: "net", Type: "ipv4", Interfaces: []string{"eth0"}},
+		{Name: "dmz", Type: "ipv4", Interfaces: []string{"eth2"}, AppID: id},
+	}, g.Zones)
+
+	policies := path.Join(dir, policyFile)
+	rules := path.Join(dir, rulesFile)
+	reject := &GraphEntry{Entry: "all all REJECT", File: policies, Line: 5}
+	assert.Equal(t, []ZoneEdge{
+		{Source: "fw", Destination: "net", Policy: "ACCEPT", PolicyEntry: &GraphEntry{Entry: "$FW all ACCEPT", File: policies, Line: 1}},
+		{Source: "fw", Destination: "dmz", Policy: "ACCEPT", PolicyEntry: &GraphEntry{Entry: "$FW all ACCEPT", File: policies, Line: 1}},
+		{Source: "net", Destination: "fw", Policy: "REJECT", PolicyEntry: reject,
+			Rules: []GraphEntry{{Entry: "ACCEPT net $FW tcp 22", File: rules, Line: 1}}},
+		{Source: "net", Destination: "dmz", Policy: "REJECT", PolicyEntry: reject,
+			Rules: []GraphEntry{{Entry: "DNAT net dmz:10.0.0.5 tcp 80", File: rules, Line: 3, AppID: id}}, AppIDs: []string{id}},
+		{Source: "dmz", Destination: "net", Policy: "ACCEPT",
+			PolicyEntry: &GraphEntry{Entry: "dmz net ACCEPT", File: policies, Line: 3, AppID: id}, AppIDs: []string{id}},
+	}, g.Edges)
+
+	dot := g.DOT()
+	assert.Contains(t, dot, "digraph zones {\n")
+	assert.Contains(t, dot, "\t\"fw\" [label=\"fw\\nfirewall\", shape=box];\n")
+	assert.Contains(t, dot, "\t\"dmz\" [label=\"dmz\\nipv4\\neth2\", tooltip=\"app: "+id+"\"];\n")
+	assert.Contains(t, dot, "\t\"net\" -> \"dmz\" [label=\"REJECT\\n1 rule\", style=dashed, tooltip=\"apps: "+id+"\"];\n")
+	assert.Contains(t, dot, "\t\"fw\" -> \"net\" [label=\"ACCEPT\"];\n")
+
+	b, err := g.JSON()
+	assert.NoError(t, err)
+	var decoded ZoneGraph
+	assert.NoError(t, json.Unmarshal(b, &decoded))
+	assert.Equal(t, g, decoded)
+	assert.Contains(t, string(b), `"app_ids": [`)
+}
@@ -1,10 +1,8 @@
 package goshorewall
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
-	"os"
 	"slices"
 	"strings"
 )
@@ -12,27 +10,183 @@ import (
 type Zone struct {
 	Name string
 	Type string
+	// Comment is the ?COMMENT annotation of the zone.
+	Comment string
+	// Location is the file and line the zone was read from.
+	Location Location
+}
+
+func (z Zone) Format() string {
+	return formatWithComment(z.Comment, formatColumns(zoneColumns, z.Name, z.Type))
+}
+
+// FormatNamed formats the zone in the column=value form.
+func (z Zone) FormatNamed() string {
+	return formatWithComment(z.Comment, formatNamedColumns(zoneColumns, z.Name, z.Type))
 }
 
 var (
 	ErrZoneAlreadyExists = errors.New("zone already exists")
 	ErrZoneNotFound      = errors.New("zone not found")
+	// ErrZoneInvalid is wrapped by every error returned by Zone.Validate.
+	ErrZoneInvalid               = errors.New("invalid zone")
+	ErrZoneInvalidName           = fmt.Errorf("%w: invalid name", ErrZoneInvalid)
+	ErrZoneNameTooLong           = fmt.Errorf("%w: name too long", ErrZoneInvalid)
+	ErrZoneReservedName          = fmt.Errorf("%w: reserved name", ErrZoneInvalid)
+	ErrZoneInvalidType           = fmt.Errorf("%w: invalid type", ErrZoneInvalid)
+	ErrZoneFirewallAlreadyExists = fmt.Errorf("%w: a firewall zone already exists", ErrZoneInvalid)
+	ErrZoneFirewallNotFound      = fmt.Errorf("%w: no firewall zone", ErrZoneInvalid)
 )
 
+// DefaultMaxZoneNameLength is the maximum length of a zone name accepted by
+// Zone.Validate, the limit of Shorewall with the default LOGFORMAT. App
+// instances can be configured with another limit, see
+// App.SetMaxZoneNameLength.
+const DefaultMaxZoneNameLength = 5
+
+// reservedZoneNames cannot be used as zone names.
+var reservedZoneNames = []string{"all", "any", "none", "SOURCE", "DEST"}
+
+// zoneTypes are the valid values of the TYPE column, "-" and an empty
+// column stand for ip.
+var zoneTypes = []string{
+	"ip", "ipv4", "ipv6", "firewall",
+	"ipsec", "ipsec4", "ipsec6", "bport", "bport4", "bport6",
+	"vserver", "loopback", "local",
+}
+
+// Validate checks the name and the type of the zone. The ZONE column of a
+// nested zone, "child:parent,...", is checked name by name.
+func (z Zone) Validate() error {
+	return z.ValidateWithMaxNameLength(DefaultMaxZoneNameLength)
+}
+
+// ValidateWithMaxNameLength is Validate accepting names of up to maxLength
+// characters.
+func (z Zone) ValidateWithMaxNameLength(maxLength int) error {
+	child, parents, nested := strings.Cut(z.Name, ":")
+	names := []string{child}
+	if nested {
+		names = append(names, strings.Split(parents, ",")...)
+	}
+	for _, name := range names {
+		if err := validateZoneName(name, maxLength); err != nil {
+			return err
+		}
+	}
+	if z.Type != "" && z.Type != "-" && !slices.Contains(zoneTypes, z.Type) {
+		return fmt.Errorf("%w: %q", ErrZoneInvalidType, z.Type)
+	}
+	return nil
+}
+
+func validateZoneName(name string, maxLength int) error {
+	if slices.Contains(reservedZoneNames, name) {
+		return fmt.Errorf("%w: %q", ErrZoneReservedName, name)
+	}
+	if name == "" {
+		return fmt.Errorf("%w: empty name", ErrZoneInvalidName)
+	}
+	if len(name) > maxLength {
+		return fmt.Errorf("%w: %q is longer than %d characters", ErrZoneNameTooLong, name, maxLength)
+	}
+	for i, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && (c >= '0' && c <= '9' || c == '_')) {
+			return fmt.Errorf("%w: %q", ErrZoneInvalidName, name)
+		}
+	}
+	return nil
+}
+
+// ValidateZones validates every zone and checks that exactly one of them is
+// the firewall zone.
+func ValidateZones(zones []Zone) error {
+	return validateZones(zones, DefaultMaxZoneNameLength)
+}
+
+func validateZones(zones []Zone, maxNameLength int) error {
+	firewalls := 0
+	for _, z := range zones {
+		if err := z.ValidateWithMaxNameLength(maxNameLength); err != nil {
+			return err
+		}
+		if z.Type == "firewall" {
+			firewalls++
+		}
+	}
+	switch {
+	case firewalls == 0:
+		return ErrZoneFirewallNotFound
+	case firewalls > 1:
+		return ErrZoneFirewallAlreadyExists
+	}
+	return nil
+}
+
+// checkFirewallZone returns ErrZoneFirewallAlreadyExists if zone is a
+// firewall zone and one of zones, other than the zone named except, is too.
+func checkFirewallZone(zones []Zone, zone Zone, except string) error {
+	if zone.Type != "firewall" {
+		return nil
+	}
+	if i := slices.IndexFunc(zones, func(z Zone) bool {
+		return z.Type == "firewall" && z.Name != except
+	}); i != -1 {
+		return fmt.Errorf("%w: %s", ErrZoneFirewallAlreadyExists, zones[i].Name)
+	}
+	return nil
+}
+
+// validatedZonesEdit wraps fn, an edit of the zones file, to validate the
+// zones of the whole edited file with ValidateZones, accepting names of up to
+// maxNameLength characters.
+func validatedZonesEdit[S any](fn func([]byte, S) ([]byte, error), maxNameLength int) func([]byte, S) ([]byte, error) {
+	return func(buff []byte, item S) ([]byte, error) {
+		buff, err := fn(buff, item)
+		if err != nil {
+			return nil, err
+		}
+		if err := validateZones(parseZones(buff), maxNameLength); err != nil {
+			return nil, err
+		}
+		return buff, nil
+	}
+}
+
 func Zones() ([]Zone, error) {
-	buff, err := os.ReadFile(fullZonesFile)
+	entries, _, err := ZonesWithOptions(ReadOptions{})
+	return entries, err
+}
+
+// ZonesWithOptions reads the zones file, following includes and evaluating
+// conditional directives as configured by opts. In lenient mode the lines
+// that could not be parsed are returned as warnings, in strict mode they
+// are returned as a ParseErrors error.
+func ZonesWithOptions(opts ReadOptions) ([]Zone, []ParseError, error) {
+	lines, err := loadConfigFile(zonesFile, opts)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return getZonesBuff(buff)
+	issues := &parseIssues{}
+	return result(parseZonesLines(lines, issues), issues, opts.Strict)
 }
 
+// AddZone adds zone to the zones file. The zones of the edited file are
+// checked with ValidateZones.
 func AddZone(zone Zone) error {
-	return readWriteFile(fullZonesFile, addZoneBuff, zone)
+	return readWriteFile(fullZonesFile, validatedZonesEdit(addZoneBuff, DefaultMaxZoneNameLength), zone)
 }
 
+// RemoveZone removes the zone named zoneName. The zones of the edited file
+// are checked with ValidateZones, so the firewall zone cannot be removed.
 func RemoveZone(zoneName string) error {
-	return readWriteFile(fullZonesFile, removeZoneBuff, zoneName)
+	return readWriteFile(fullZonesFile, validatedZonesEdit(removeZoneBuff, DefaultMaxZoneNameLength), zoneName)
+}
+
+// UpdateZone replaces the zone named name with zone. The zones of the edited
+// file are checked with ValidateZones.
+func UpdateZone(name string, zone Zone) error {
+	return readWriteFile(fullZonesFile, validatedZonesEdit(updateZoneBuff, DefaultMaxZoneNameLength), entryUpdate[Zone]{old: Zone{Name: name}, new: zone})
 }
 
 func getZonesBuff(buff []byte) ([]Zone, error) {
@@ -54,48 +208,76 @@ func addZoneBuff(buff []byte, zone Zone) ([]byte, error) {
 	}) {
 		return nil, ErrZoneAlreadyExists
 	}
+	if err := checkFirewallZone(zones, zone, ""); err != nil {
+		return nil, err
+	}
 
-	return fmt.Appendf(buff, "%s\t%s\n", zone.Name, zone.Type), nil
+	return fmt.Appendf(buff, "%s\n", zone.Format()), nil
 }
 
+// removeZoneBuff removes the zone named zoneName, leaving the other lines of
+// buff untouched.
 func removeZoneBuff(buff []byte, zoneName string) ([]byte, error) {
+	t := parseCST(buff)
+	i, _ := t.find(func(l logicalLine, _ directiveState) bool {
+		return slices.ContainsFunc(parseZonesLines([]logicalLine{l}, nil), func(z Zone) bool {
+			return z.Name == zoneName
+		})
+	})
+	if i == -1 {
+		return nil, ErrZoneNotFound
+	}
+	t.remove(i)
+	return t.Bytes(), nil
+}
+
+// updateZoneBuff replaces the zone matching u.old with u.new,
+// leaving the other lines of buff untouched.
+func updateZoneBuff(buff []byte, u entryUpdate[Zone]) ([]byte, error) {
 	zones, err := getZonesBuff(buff)
 	if err != nil {
 		return nil, err
 	}
+	if u.new.Name != u.old.Name && slices.ContainsFunc(zones, func(z Zone) bool {
+		return z.Name == u.new.Name
+	}) {
+		return nil, ErrZoneAlreadyExists
+	}
+	if err := checkFirewallZone(zones, u.new, u.old.Name); err != nil {
+		return nil, err
+	}
 
-	index := slices.IndexFunc(zones, func(z Zone) bool {
-		return z.Name == zoneName
+	t := parseCST(buff)
+	i, state := t.find(func(l logicalLine, _ directiveState) bool {
+		return slices.ContainsFunc(parseZonesLines([]logicalLine{l}, nil), func(z Zone) bool {
+			return z.Name == u.old.Name
+		})
 	})
-	if index == -1 {
+	if i == -1 {
 		return nil, ErrZoneNotFound
 	}
+	zone := u.new
+	zone.Comment = t.entryComment(i, state, zone.Comment)
+	t.replace(i, zone.Format())
+	return t.Bytes(), nil
+}
 
-	zones = slices.Delete(zones, index, index+1)
-
-	var b bytes.Buffer
-	for _, z := range zones {
-		b.WriteString(z.Name + "\t" + z.Type + "\n")
-	}
-
-	return b.Bytes(), nil
+func parseZones(data []byte) []Zone {
+	return parseZonesLines(tokenize(data), nil)
 }
 
-func parseZones(data []byte) (zones []Zone) {
-	iter := bytes.Lines(data)
-	for z := range iter {
-		z = bytes.TrimSpace(z)
-		if len(z) == 0 || z[0] == '#' {
-			continue
-		}
-		z = bytes.ReplaceAll(z, []byte("\t"), []byte(" "))
-		parts := bytes.Fields(z)
-		if len(parts) < 2 {
+func parseZonesLines(lines []logicalLine, issues *parseIssues) (zones []Zone) {
+	var state directiveState
+	for _, l := range lines {
+		parts, ok := entryColumns(l, &state, zoneColumns, 2, issues)
+		if !ok {
 			continue
 		}
 		zone := Zone{
-			Name: string(parts[0]),
-			Type: string(parts[1]),
+			Name:     parts[0],
+			Type:     parts[1],
+			Comment:  state.comment,
+			Location: l.location(),
 		}
 		zones = append(zones, zone)
 	}
@@ -83,3 +83,75 @@ zone2		ip
 	assert.Equal(t, "zone2", zones[1].Name)
 	assert.Equal(t, "ip", zones[1].Type)
 }
+
+func TestZone_Validate(t *testing.T) {
+	testCases := []struct {
+		zone Zone
+		err  error
+	}{
+		{Zone{Name: "fw", Type: "firewall"}, nil},
+		{Zone{Name: "net", Type: "ipv4"}, nil},
+		{Zone{Name: "loc", Type: "-"}, nil},
+		{Zone{Name: "vpn:net", Type: "ipsec"}, nil},
+		{Zone{Name: "dmz_1", Type: "bport4"}, nil},
+		{Zone{Name: "all", Type: "ipv4"}, ErrZoneReservedName},
+		{Zone{Name: "SOURCE", Type: "ipv4"}, ErrZoneReservedName},
+		{Zone{Name: "vpn:any", Type: "ipv4"}, ErrZoneReservedName},
+		{Zone{Name: "toolong", Type: "ipv4"}, ErrZoneNameTooLong},
+		{Zone{Name: "1net", Type: "ipv4"}, ErrZoneInvalidName},
+		{Zone{Name: "", Type: "ipv4"}, ErrZoneInvalidName},
+		{Zone{Name: "net", Type: "ipv5"}, ErrZoneInvalidType},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.zone.Name+"/"+tc.zone.Type, func(t *testing.T) {
+			err := tc.zone.Validate()
+			if tc.err == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tc.err)
+			assert.ErrorIs(t, err, ErrZoneInvalid)
+		})
+	}
+}
+
+func TestValidateZones(t *testing.T) {
+	assert.NoError(t, ValidateZones([]Zone{{Name: "fw", Type: "firewall"}, {Name: "net", Type: "ipv4"}}))
+	assert.ErrorIs(t, ValidateZones([]Zone{{Name: "net", Type: "ipv4"}}), ErrZoneFirewallNotFound)
+	assert.ErrorIs(t, ValidateZones([]Zone{{Name: "fw", Type: "firewall"}, {Name: "fw2", Type: "firewall"}}), ErrZoneFirewallAlreadyExists)
+}
+
+func TestAddZoneBuff_Invalid(t *testing.T) {
+	_, err := validatedZonesEdit(addZoneBuff, DefaultMaxZoneNameLength)([]byte(zones01), Zone{Name: "none", Type: "ip"})
+	assert.ErrorIs(t, err, ErrZoneReservedName)
+
+	_, err = addZoneBuff([]byte(zones02), Zone{Name: "fw", Type: "firewall"})
+	assert.ErrorIs(t, err, ErrZoneFirewallAlreadyExists)
+
+	_, err = updateZoneBuff([]byte(zones02), entryUpdate[Zone]{old: Zone{Name: "asczxy"}, new: Zone{Name: "fw", Type: "firewall"}})
+	assert.ErrorIs(t, err, ErrZoneFirewallAlreadyExists)
+
+	_, err = updateZoneBuff([]byte("fw firewall\nnet ipv4\n"), entryUpdate[Zone]{old: Zone{Name: "fw"}, new: Zone{Name: "fw", Type: "firewall", Comment: "the firewall"}})
+	assert.NoError(t, err)
+}
+
+func TestValidatedZonesEdit(t *testing.T) {
+	buff := []byte("fw firewall\nnet ipv4\n")
+
+	_, err := validatedZonesEdit(removeZoneBuff, DefaultMaxZoneNameLength)(buff, "fw")
+	assert.ErrorIs(t, err, ErrZoneFirewallNotFound)
+
+	_, err = validatedZonesEdit(updateZoneBuff, DefaultMaxZoneNameLength)(buff, entryUpdate[Zone]{old: Zone{Name: "fw"}, new: Zone{Name: "fw", Type: "ipv4"}})
+	assert.ErrorIs(t, err, ErrZoneFirewallNotFound)
+
+	_, err = validatedZonesEdit(addZoneBuff, DefaultMaxZoneNameLength)([]byte("net ipv4\n"), Zone{Name: "loc", Type: "ipv4"})
+	assert.ErrorIs(t, err, ErrZoneFirewallNotFound)
+
+	_, err = validatedZonesEdit(addZoneBuff, DefaultMaxZoneNameLength)(buff, Zone{Name: "guests", Type: "ipv4"})
+	assert.ErrorIs(t, err, ErrZoneNameTooLong)
+
+	b, err := validatedZonesEdit(addZoneBuff, 8)(buff, Zone{Name: "guests", Type: "ipv4"})
+	assert.NoError(t, err)
+	assert.Equal(t, "fw firewall\nnet ipv4\nguests\tipv4\n", string(b))
+}